@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//types of Event
+const (
+	EventSignUp               = "user.signup"
+	EventProfileUpdated       = "user.profile.updated"
+	EventPasswordChanged      = "user.password.changed"
+	EventEmailChanged         = "user.email.changed"
+	EventSignIn               = "session.signin"
+	EventSignInFailed         = "session.signin.failed"
+	EventSignOut              = "session.signout"
+	EventImpersonationStarted = "impersonation.started"
+	EventImpersonationEnded   = "impersonation.ended"
+	EventRolesChanged         = "user.roles.changed"
+)
+
+//DefaultLimit is how many events a Query returns if it has no Limit
+const DefaultLimit = 50
+
+//MaxLimit is the most events a Query returns
+const MaxLimit = 500
+
+//Event is something that happened to a user's account
+type Event struct {
+	ID   bson.ObjectId `json:"id" bson:"_id"`
+	Type string        `json:"type"`
+	Time time.Time     `json:"time"`
+	//UserID is the hex ID of the user the event happened to, if known
+	UserID string `json:"userID,omitempty"`
+	//Email is the email given, for failed sign-ins
+	Email string `json:"email,omitempty"`
+	//ActorID is the hex ID of the admin acting as, or on, the user, if any
+	ActorID string `json:"actorID,omitempty"`
+	//Session is the Handle of the session, not the SessionID itself
+	Session   string `json:"session,omitempty"`
+	ClientIP  string `json:"clientIP,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	//Detail describes the event further, e.g. which fields changed
+	Detail string `json:"detail,omitempty"`
+}
+
+//Query selects events, newest first, and those at the same time by
+//descending ID. Zero fields match any event.
+type Query struct {
+	UserID string
+	Email  string
+	Type   string
+	//Since and Until bound the event times: Since <= Time < Until
+	Since time.Time
+	Until time.Time
+	//BeforeID also selects the events at Until with a lower ID, so a
+	//page can start right after the last event of the one before
+	BeforeID bson.ObjectId
+	Limit    int
+}
+
+//limit returns how many events the query may return
+func (q *Query) limit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		return MaxLimit
+	}
+	return q.Limit
+}
+
+//matches reports whether the event is selected by the query
+func (q *Query) matches(e *Event) bool {
+	return (len(q.UserID) == 0 || e.UserID == q.UserID) &&
+		(len(q.Email) == 0 || e.Email == q.Email) &&
+		(len(q.Type) == 0 || e.Type == q.Type) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until) ||
+			(len(q.BeforeID) > 0 && e.Time.Equal(q.Until) && e.ID < q.BeforeID))
+}
+
+//newer reports whether event a comes before b in the results
+func newer(a *Event, b *Event) bool {
+	if a.Time.Equal(b.Time) {
+		return a.ID > b.ID
+	}
+	return a.Time.After(b.Time)
+}
+
+//Store is an append-only store of audit events
+type Store interface {
+	//Append adds the event to the store, giving it an ID and time if unset
+	Append(e *Event) error
+
+	//Find returns the events selected by the query, newest first
+	Find(q *Query) ([]*Event, error)
+}
+
+//prepare gives the event an ID and time if unset
+func prepare(e *Event) {
+	if len(e.ID) == 0 {
+		e.ID = bson.NewObjectId()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+}
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+//FileStore appends events to a file as JSON, one per line. Find reads
+//the whole file, so it suits small deployments and local testing.
+type FileStore struct {
+	Path string
+	mx   sync.Mutex
+}
+
+//NewFileStore constructs a new FileStore, creating the file if it doesn't exist
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %v", err)
+	}
+	f.Close()
+	return &FileStore{
+		Path: path,
+	}, nil
+}
+
+//Append adds the event to the store, giving it an ID and time if unset
+func (fs *FileStore) Append(e *Event) error {
+	prepare(e)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding audit event: %v", err)
+	}
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	f, err := os.OpenFile(fs.Path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing audit event: %v", err)
+	}
+	return nil
+}
+
+//Find returns the events selected by the query, newest first
+func (fs *FileStore) Find(q *Query) ([]*Event, error) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	f, err := os.Open(fs.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+	matches := []*Event{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("error decoding audit event: %v", err)
+		}
+		if q.matches(e) {
+			matches = append(matches, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %v", err)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return newer(matches[i], matches[j])
+	})
+	if len(matches) > q.limit() {
+		matches = matches[:q.limit()]
+	}
+	return matches, nil
+}
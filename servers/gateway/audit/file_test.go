@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("error creating file store: %v", err)
+	}
+
+	start := time.Now()
+	events := []*Event{
+		{Type: EventSignUp, UserID: "user1", Time: start},
+		{Type: EventSignIn, UserID: "user1", Session: "abc", ClientIP: "10.0.0.1", Time: start.Add(time.Second)},
+		{Type: EventSignInFailed, Email: "nobody@example.com", Time: start.Add(2 * time.Second)},
+		{Type: EventSignIn, UserID: "user2", Time: start.Add(3 * time.Second)},
+		{Type: EventSignOut, UserID: "user1", Session: "abc", Time: start.Add(4 * time.Second)},
+	}
+	for _, e := range events {
+		if err := store.Append(e); err != nil {
+			t.Fatalf("error appending event: %v", err)
+		}
+		if len(e.ID) == 0 {
+			t.Errorf("event was not given an ID")
+		}
+	}
+
+	cases := []struct {
+		name     string
+		query    *Query
+		expected []*Event
+	}{
+		{"All", &Query{}, []*Event{events[4], events[3], events[2], events[1], events[0]}},
+		{"By User", &Query{UserID: "user1"}, []*Event{events[4], events[1], events[0]}},
+		{"By Email", &Query{Email: "nobody@example.com"}, []*Event{events[2]}},
+		{"By Type", &Query{Type: EventSignIn}, []*Event{events[3], events[1]}},
+		{"Time Range", &Query{Since: start.Add(time.Second), Until: start.Add(3 * time.Second)}, []*Event{events[2], events[1]}},
+		{"Limit", &Query{UserID: "user1", Limit: 2}, []*Event{events[4], events[1]}},
+	}
+	for _, c := range cases {
+		found, err := store.Find(c.query)
+		if err != nil {
+			t.Fatalf("case %s: error finding events: %v", c.name, err)
+		}
+		if len(found) != len(c.expected) {
+			t.Errorf("case %s: expected %d events but got %d", c.name, len(c.expected), len(found))
+			continue
+		}
+		for i, e := range found {
+			if e.ID != c.expected[i].ID {
+				t.Errorf("case %s: event %d: expected %s but got %s", c.name, i, c.expected[i].ID.Hex(), e.ID.Hex())
+			}
+		}
+	}
+
+	//paging back from an event finds the others at the same time
+	same := start.Add(5 * time.Second)
+	page := []*Event{
+		{Type: EventSignIn, UserID: "user3", Time: same},
+		{Type: EventSignOut, UserID: "user3", Time: same},
+		{Type: EventSignIn, UserID: "user3", Time: same},
+	}
+	for _, e := range page {
+		store.Append(e)
+	}
+	found, _ := store.Find(&Query{UserID: "user3", Limit: 1})
+	if len(found) != 1 || found[0].ID != page[2].ID {
+		t.Fatalf("expected the newest event at the same time first")
+	}
+	found, _ = store.Find(&Query{UserID: "user3", Until: found[0].Time, BeforeID: found[0].ID})
+	if len(found) != 2 || found[0].ID != page[1].ID || found[1].ID != page[0].ID {
+		t.Errorf("paging back with until and beforeID skipped or repeated events: %v", found)
+	}
+
+	//events are kept when the store is reopened
+	reopened, _ := NewFileStore(store.Path)
+	found, _ = reopened.Find(&Query{})
+	if len(found) != len(events)+len(page) || found[4].ClientIP != "" || found[6].ClientIP != "10.0.0.1" {
+		t.Errorf("events were not kept in the file")
+	}
+}
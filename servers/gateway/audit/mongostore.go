@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"fmt"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//MongoStore is a Store backed by a mongo collection
+type MongoStore struct {
+	session *mgo.Session
+	dbname  string
+	colname string
+}
+
+//NewMongoStore constructs a new MongoStore
+func NewMongoStore(sess *mgo.Session, dbName string, collectionName string) *MongoStore {
+	if sess == nil {
+		panic("nil pointer passed for session")
+	}
+	return &MongoStore{
+		session: sess,
+		dbname:  dbName,
+		colname: collectionName,
+	}
+}
+
+//Append adds the event to the store, giving it an ID and time if unset
+func (ms *MongoStore) Append(e *Event) error {
+	prepare(e)
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.Insert(e); err != nil {
+		return fmt.Errorf("error inserting audit event: %v", err)
+	}
+	return nil
+}
+
+//Find returns the events selected by the query, newest first
+func (ms *MongoStore) Find(q *Query) ([]*Event, error) {
+	filter := bson.M{}
+	if len(q.UserID) > 0 {
+		filter["userid"] = q.UserID
+	}
+	if len(q.Email) > 0 {
+		filter["email"] = q.Email
+	}
+	if len(q.Type) > 0 {
+		filter["type"] = q.Type
+	}
+	times := bson.M{}
+	if !q.Since.IsZero() {
+		times["$gte"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		times["$lt"] = q.Until
+	}
+	if len(times) > 0 {
+		filter["time"] = times
+	}
+	if !q.Until.IsZero() && len(q.BeforeID) > 0 {
+		// or at Until, but before the ID
+		atUntil := bson.M{"time": q.Until, "_id": bson.M{"$lt": q.BeforeID}}
+		delete(filter, "time")
+		filter["$or"] = []bson.M{{"time": times}, atUntil}
+	}
+	events := []*Event{}
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.Find(filter).Sort("-time", "-_id").Limit(q.limit()).All(&events); err != nil {
+		return nil, fmt.Errorf("error finding audit events: %v", err)
+	}
+	return events, nil
+}
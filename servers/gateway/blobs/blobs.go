@@ -0,0 +1,44 @@
+package blobs
+
+import (
+	"errors"
+	"io"
+)
+
+//ErrNotFound is returned when there is no blob with the key
+var ErrNotFound = errors.New("blob not found")
+
+//Store stores blobs, like uploaded photos, by key. Keys may contain
+//letters, digits, '-', '_' and '.', and a '/' to group blobs.
+type Store interface {
+	//Put saves the blob under the key, replacing any blob already there
+	Put(key string, data []byte) error
+
+	//Get returns a reader for the blob, which the caller must close
+	Get(key string) (io.ReadCloser, error)
+
+	//Delete removes the blob; deleting a missing blob is not an error
+	Delete(key string) error
+}
+
+//validKey reports whether the key is safe to use in any Store
+func validKey(key string) bool {
+	if len(key) == 0 || key[0] == '/' || key[len(key)-1] == '/' {
+		return false
+	}
+	prev := rune(0)
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		case r == '.' || r == '/':
+			//no "..", "./" or "//", so keys can't climb out of a directory
+			if prev == '.' || prev == '/' {
+				return false
+			}
+		default:
+			return false
+		}
+		prev = r
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package blobs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//FSStore is a Store that keeps each blob in a file under a directory
+type FSStore struct {
+	Dir string
+}
+
+//NewFSStore constructs a new FSStore, creating the directory if it doesn't exist
+func NewFSStore(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating blob directory: %v", err)
+	}
+	return &FSStore{
+		Dir: dir,
+	}, nil
+}
+
+//path returns the file the blob with the key is kept in
+func (fs *FSStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("error invalid blob key %q", key)
+	}
+	return filepath.Join(fs.Dir, filepath.FromSlash(key)), nil
+}
+
+//Put saves the blob under the key, replacing any blob already there.
+//It is written to a temporary file first, so readers never see part of it.
+func (fs *FSStore) Put(key string, data []byte) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating blob directory: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("error creating blob: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing blob: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing blob: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving blob: %v", err)
+	}
+	return nil
+}
+
+//Get returns a reader for the blob, which the caller must close
+func (fs *FSStore) Get(key string) (io.ReadCloser, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening blob: %v", err)
+	}
+	return f, nil
+}
+
+//Delete removes the blob; deleting a missing blob is not an error
+func (fs *FSStore) Delete(key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting blob: %v", err)
+	}
+	return nil
+}
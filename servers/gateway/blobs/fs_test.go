@@ -0,0 +1,56 @@
+package blobs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFSStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFSStore(dir)
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	key := "photos/abc123-256.jpg"
+	if _, err := store.Get(key); err != ErrNotFound {
+		t.Errorf("expected %v for a missing blob but got %v", ErrNotFound, err)
+	}
+	for _, data := range []string{"first", "second"} {
+		if err := store.Put(key, []byte(data)); err != nil {
+			t.Fatalf("error putting blob: %v", err)
+		}
+		rc, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("error getting blob: %v", err)
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != data {
+			t.Errorf("expected blob %q but got %q", data, got)
+		}
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("error deleting blob: %v", err)
+	}
+	if _, err := store.Get(key); err != ErrNotFound {
+		t.Errorf("expected %v after deleting but got %v", ErrNotFound, err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Errorf("unexpected error deleting a missing blob: %v", err)
+	}
+
+	for _, bad := range []string{"", "../escape", "photos/../../escape", "/abs", "dir/", "a//b", "sp ace"} {
+		if err := store.Put(bad, []byte("x")); err == nil {
+			t.Errorf("expected an error putting blob with invalid key %q", bad)
+		}
+		if _, err := store.Get(bad); err != ErrNotFound {
+			t.Errorf("expected %v getting blob with invalid key %q but got %v", ErrNotFound, bad, err)
+		}
+	}
+}
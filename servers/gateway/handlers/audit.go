@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//audit records the event with the client the request came from. Failing
+//to record it is logged rather than failing the request.
+func (ctx *Ctx) audit(r *http.Request, e *audit.Event) {
+	if ctx.AuditStore == nil {
+		return
+	}
+	e.ClientIP = sessions.ClientIP(r)
+	e.UserAgent = r.UserAgent()
+	if err := ctx.AuditStore.Append(e); err != nil {
+		log.Printf("error recording audit event %s: %v", e.Type, err)
+	}
+}
+
+//auditSession records an event about the session's user
+func (ctx *Ctx) auditSession(r *http.Request, eventType string, state *SessionState, sessID sessions.SessionID, detail string) {
+	e := &audit.Event{
+		Type:    eventType,
+		UserID:  state.SessionOwner(),
+		Session: sessID.Handle(),
+		Detail:  detail,
+	}
+	if state.Impersonator != nil {
+		e.ActorID = state.Impersonator.ID.Hex()
+	}
+	ctx.audit(r, e)
+}
+
+//changedFields lists the names of the fields that changed, for an event's Detail
+func changedFields(changed map[string]bool) string {
+	names := []string{}
+	for name, ok := range changed {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+//auditQuery reads an audit.Query from the request's query parameters:
+//`type`, `since` and `until` (RFC 3339 times), `beforeID` and `limit`
+func auditQuery(r *http.Request) (*audit.Query, error) {
+	params := r.URL.Query()
+	q := &audit.Query{
+		Type: params.Get("type"),
+	}
+	if since := params.Get("since"); len(since) > 0 {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing since: %v", err)
+		}
+		q.Since = t
+	}
+	if until := params.Get("until"); len(until) > 0 {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing until: %v", err)
+		}
+		q.Until = t
+	}
+	if beforeID := params.Get("beforeID"); len(beforeID) > 0 {
+		if !bson.IsObjectIdHex(beforeID) || q.Until.IsZero() {
+			return nil, fmt.Errorf("error beforeID must be an event ID, along with until")
+		}
+		q.BeforeID = bson.ObjectIdHex(beforeID)
+	}
+	if limit := params.Get("limit"); len(limit) > 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("error limit must be a positive number")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+//UsersMeActivityHandler returns the audit events of the signed in user,
+//newest first. Pass the time and ID of the oldest event as `until` and
+//`beforeID` to page back, so events at the same time aren't skipped.
+func (ctx *Ctx) UsersMeActivityHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		session := SessionState{}
+		if _, err := ctx.GetScopedSessionState(r, &session, ScopeProfileRead); err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), scopeErrorStatus(err))
+			return
+		}
+		if ctx.AuditStore == nil {
+			http.Error(w, fmt.Sprintf("the audit log is not enabled"), http.StatusNotFound)
+			return
+		}
+		q, err := auditQuery(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		q.UserID = session.SessionOwner()
+		events, err := ctx.AuditStore.Find(q)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error finding activity: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(events); err != nil {
+			http.Error(w, fmt.Sprintf("error returning activity json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+	}
+}
+
+//AdminAuditHandler lets an admin query the audit log of every user. It
+//accepts the parameters of UsersMeActivityHandler plus `userID` and `email`.
+//It must be wrapped with RequirePermission.
+func (ctx *Ctx) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if ctx.AuditStore == nil {
+			http.Error(w, fmt.Sprintf("the audit log is not enabled"), http.StatusNotFound)
+			return
+		}
+		q, err := auditQuery(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		q.UserID = r.URL.Query().Get("userID")
+		q.Email = r.URL.Query().Get("email")
+		events, err := ctx.AuditStore.Find(q)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error querying audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(events); err != nil {
+			http.Error(w, fmt.Sprintf("error returning audit json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+)
+
+func TestUsersMeActivityHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	signUp(t, ctx, "user2")
+	//events at the same time, which paging by time alone would skip
+	same := time.Now()
+	for i := 0; i < 3; i++ {
+		ctx.AuditStore.Append(&audit.Event{Type: audit.EventSignIn, UserID: u.ID.Hex(), Time: same})
+	}
+
+	page := func(query string) []*audit.Event {
+		w := do(ctx.UsersMeActivityHandler, "GET", "/v1/users/me/activity?"+query, nil, auth)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d for %q but got %d: %s", http.StatusOK, query, w.Code, w.Body.String())
+		}
+		events := []*audit.Event{}
+		if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+			t.Fatalf("error decoding activity: %v", err)
+		}
+		return events
+	}
+
+	all := page("")
+	if len(all) != 4 {
+		t.Fatalf("expected the user's 4 events but got %d", len(all))
+	}
+	seen := map[string]bool{}
+	query := "limit=1"
+	for i := 0; i < len(all); i++ {
+		events := page(query)
+		if len(events) != 1 {
+			t.Fatalf("page %d: expected 1 event but got %d", i, len(events))
+		}
+		e := events[0]
+		if e.UserID != u.ID.Hex() || seen[e.ID.Hex()] {
+			t.Fatalf("page %d: unexpected event %v", i, e)
+		}
+		seen[e.ID.Hex()] = true
+		query = fmt.Sprintf("limit=1&until=%s&beforeID=%s", url.QueryEscape(e.Time.Format(time.RFC3339Nano)), e.ID.Hex())
+	}
+	if events := page(query); len(events) != 0 {
+		t.Errorf("expected no events after the last page but got %d", len(events))
+	}
+
+	for _, query := range []string{"beforeID=nope&until=" + url.QueryEscape(same.Format(time.RFC3339)), "beforeID=" + all[0].ID.Hex()} {
+		if w := do(ctx.UsersMeActivityHandler, "GET", "/v1/users/me/activity?"+query, nil, auth); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %d for %q but got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
@@ -3,14 +3,20 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
 )
 
+//headerRefreshToken is the response header refresh tokens are returned in
+const headerRefreshToken = "Refresh-Token"
+
 //TODO: define HTTP handler functions as described in the
 //assignment description. Remember to use your handler context
 //struct as the receiver on these functions so that you have
@@ -20,9 +26,10 @@ func (us *Ctx) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		sess := SessionState{}
-		_, err := sessions.GetState(r, us.Key, us.SessionsStore, &sess)
+		_, err := us.GetScopedSessionState(r, &sess, ScopeProfileRead)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), scopeErrorStatus(err))
+			return
 		}
 		prefix := r.URL.Query().Get("q")
 		if len(prefix) > 0 {
@@ -71,22 +78,28 @@ func (us *Ctx) UsersHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		// begin a new session
-		newSession := SessionState{
-			AuthenticatedUser: user,
-			TimeBegin:         time.Now(),
-		}
+		newSession := NewSessionState(r, user)
 
-		_, errBeginSession := sessions.BeginSession(us.Key, us.SessionsStore, &newSession, w)
+		sessID, errBeginSession := sessions.BeginSession(us.Key, us.SessionsStore, newSession, w)
+		if errBeginSession == sessions.ErrTooManySessions {
+			http.Error(w, fmt.Sprintf("error generating session for user: %v", errBeginSession), http.StatusConflict)
+			return
+		}
 		if errBeginSession != nil {
 			http.Error(w, fmt.Sprintf("error generating session for user: %v", errBeginSession), http.StatusInternalServerError)
 			return
 		}
+		us.auditSession(r, audit.EventSignUp, newSession, sessID, "")
+		// send the link to verify their email
+		if err := us.sendVerification(user); err != nil {
+			log.Printf("error sending verification email: %v", err)
+		}
 		// return with a http.StatusCreated and json encoded form of that created user
+		w.WriteHeader(http.StatusCreated)
 		if err := json.NewEncoder(w).Encode(user); err != nil {
 			http.Error(w, fmt.Sprintf("error returning new user json: %v", err), http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusCreated)
 		// Somehow put the user/sessionn I have started into a request context?
 		// Add this new user to the Trie
 		us.RootTrieNode.Add(strings.ToLower(user.Email), user.ID)
@@ -102,9 +115,10 @@ func (us *Ctx) UsersMeHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		sess := SessionState{}
-		_, err := sessions.GetState(r, us.Key, us.SessionsStore, &sess)
+		_, err := us.GetScopedSessionState(r, &sess, ScopeProfileRead)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), scopeErrorStatus(err))
+			return
 		}
 		user := sess.AuthenticatedUser
 		if err := json.NewEncoder(w).Encode(user); err != nil {
@@ -114,13 +128,19 @@ func (us *Ctx) UsersMeHandler(w http.ResponseWriter, r *http.Request) {
 	case "PATCH":
 		// Get the user from the request body
 		sess := SessionState{}
-		sessID, err := sessions.GetState(r, us.Key, us.SessionsStore, &sess)
+		sessID, err := us.GetScopedSessionState(r, &sess, ScopeProfileWrite)
+		if err == ErrInsufficientScope || err == ErrUnverified {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusBadRequest)
+			return
 		}
 		user := sess.AuthenticatedUser
 		oldFirst := user.FirstName
 		oldLast := user.LastName
+		oldUserName := user.UserName
 		///////////
 		// Get the updates from the request body as well
 		upd := &users.Updates{}
@@ -128,17 +148,42 @@ func (us *Ctx) UsersMeHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
 			return
 		}
+		// make sure nobody else has the new username
+		if len(upd.UserName) > 0 && upd.UserName != oldUserName {
+			if _, err := us.UsersStore.GetByUserName(upd.UserName); err == nil {
+				http.Error(w, fmt.Sprintf("error username already exists"), http.StatusBadRequest)
+				return
+			}
+		}
 		// make sure the updates are valid
 		if err := user.ApplyUpdates(upd); err != nil {
 			http.Error(w, fmt.Sprintf("error applying updated updates: %v", err), http.StatusBadRequest)
 			return
 		}
 		// update the user in our provided database
-		if err := us.UsersStore.Update(user.ID, upd); err != nil {
+		err = us.UsersStore.Update(user.ID, upd)
+		if err == users.ErrUserNameTaken {
+			// someone else took the username since we checked
+			http.Error(w, fmt.Sprintf("error username already exists"), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("could not update user: %v", err), http.StatusInternalServerError)
 			return
 		}
-		us.SessionsStore.Save(sessID, &sess)
+		// profile changed, so move the session to a new ID
+		newID, err := sessions.RotateSession(us.Key, us.SessionsStore, sessID, &sess, w)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error rotating session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// and update the copies in the user's other sessions
+		us.updateSessionUsers(user)
+		us.auditSession(r, audit.EventProfileUpdated, &sess, newID, changedFields(map[string]bool{
+			"firstName": oldFirst != user.FirstName,
+			"lastName":  oldLast != user.LastName,
+			"userName":  oldUserName != user.UserName,
+		}))
 		///////////
 		// Return updated user as json
 		if err := json.NewEncoder(w).Encode(user); err != nil {
@@ -150,6 +195,10 @@ func (us *Ctx) UsersMeHandler(w http.ResponseWriter, r *http.Request) {
 		us.RootTrieNode.Delete(strings.ToLower(oldLast), user.ID)
 		us.RootTrieNode.Add(strings.ToLower(user.FirstName), user.ID)
 		us.RootTrieNode.Add(strings.ToLower(user.LastName), user.ID)
+		if oldUserName != user.UserName {
+			us.RootTrieNode.Delete(strings.ToLower(oldUserName), user.ID)
+			us.RootTrieNode.Add(strings.ToLower(user.UserName), user.ID)
+		}
 	default:
 		http.Error(w, fmt.Sprintf("only accepts GET and PATCH"), http.StatusMethodNotAllowed)
 	}
@@ -164,29 +213,133 @@ func (sess *Ctx) SessionsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
 			return
 		}
+		// slow down password guessing
+		if !sess.allowSignIn(w, r, cred.Email) {
+			return
+		}
 		// get the user with the provided email
 		u, err := sess.UsersStore.GetByEmail(cred.Email)
 		if err != nil {
+			// take as long as a wrong password would
+			users.AuthenticateUnknown(cred.Password)
+			sess.failSignIn(r, cred.Email, "")
 			http.Error(w, fmt.Sprintf("invalid credentials"), http.StatusUnauthorized)
 			return
 		}
 		// authenticate with ther password
 		if err := u.Authenticate(cred.Password); err != nil {
+			sess.failSignIn(r, cred.Email, u.ID.Hex())
 			http.Error(w, fmt.Sprintf("invalid credentials"), http.StatusUnauthorized)
 			return
 		}
-		newSession := SessionState{
-			AuthenticatedUser: u,
-			TimeBegin:         time.Now(),
+		// with a second factor, failures are forgotten once it is entered too
+		if u.TwoFactor.Enabled {
+			sess.releaseSignIn(r, cred.Email)
+		} else {
+			sess.succeedSignIn(r, cred.Email)
 		}
-		// begin a session
-		if _, err := sessions.BeginSession(sess.Key, sess.SessionsStore, &newSession, w); err != nil {
+		sess.beginSignIn(w, r, u, cred.RememberMe, "password")
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
+	}
+}
+
+//beginSignIn begins a session for the user who just authenticated with
+//`method` and responds with the user, or with a TwoFactorChallenge if
+//they must still enter their second factor
+func (sess *Ctx) beginSignIn(w http.ResponseWriter, r *http.Request, u *users.User, rememberMe bool, method string) {
+	newSession := NewSessionState(r, u)
+	if rememberMe && sess.RememberMeDuration > 0 {
+		newSession.Lifetime = sess.RememberMeDuration
+	}
+	// the first factor alone only begins a pending session, which
+	// doesn't count against the user's session limit
+	begin := sessions.BeginSession
+	if u.TwoFactor.Enabled {
+		newSession.PendingTwoFactor = true
+		newSession.Expires = newSession.TimeBegin.Add(pendingTwoFactorLifetime)
+		begin = sessions.BeginPendingSession
+	}
+	// begin a session
+	sessID, err := begin(sess.Key, sess.SessionsStore, newSession, w)
+	if err == sessions.ErrTooManySessions {
+		http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if newSession.PendingTwoFactor {
+		w.WriteHeader(http.StatusAccepted)
+		challenge := &TwoFactorChallenge{TwoFactorRequired: true, Expires: newSession.Expires}
+		if err := json.NewEncoder(w).Encode(challenge); err != nil {
+			http.Error(w, fmt.Sprintf("error returning challenge json: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	sess.auditSession(r, audit.EventSignIn, newSession, sessID, method)
+	// hand out a refresh token so the client can outlive the session
+	if sess.RefreshTokens != nil {
+		refreshToken, err := sess.RefreshTokens.Issue(u.ID.Hex(), sessID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error issuing refresh token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add(headerRefreshToken, refreshToken.String())
+	}
+	// return the user
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("error returning new user json: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (sess *Ctx) SessionsRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		if sess.RefreshTokens == nil {
+			http.Error(w, fmt.Sprintf("refresh tokens are not enabled"), http.StatusNotFound)
+			return
+		}
+		refresh := &RefreshRequest{}
+		if err := json.NewDecoder(r.Body).Decode(refresh); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		// use up the old token
+		userID, familyID, err := sess.RefreshTokens.Redeem(refresh.RefreshToken)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+			return
+		}
+		if !bson.IsObjectIdHex(userID) {
+			http.Error(w, fmt.Sprintf("invalid refresh token"), http.StatusUnauthorized)
+			return
+		}
+		u, err := sess.UsersStore.GetByID(bson.ObjectIdHex(userID))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid refresh token"), http.StatusUnauthorized)
+			return
+		}
+		// begin a fresh session and hand out the next token
+		sessID, err := sessions.BeginSession(sess.Key, sess.SessionsStore, NewSessionState(r, u), w)
+		if err == sessions.ErrTooManySessions {
+			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
 			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusInternalServerError)
 			return
 		}
-		// return the user
+		refreshToken, err := sess.RefreshTokens.Rotate(familyID, sessID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error issuing refresh token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add(headerRefreshToken, refreshToken.String())
 		if err := json.NewEncoder(w).Encode(u); err != nil {
-			http.Error(w, fmt.Sprintf("error returning new user json: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("error returning user json: %v", err), http.StatusInternalServerError)
 			return
 		}
 	default:
@@ -196,16 +349,313 @@ func (sess *Ctx) SessionsHandler(w http.ResponseWriter, r *http.Request) {
 
 func (sess *Ctx) SessionsMineHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
+	case "GET":
+		session := SessionState{}
+		sessID, err := sess.GetSessionState(r, &session)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		summary := session.Summary(sessID)
+		summary.Current = true
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			http.Error(w, fmt.Sprintf("error returning session json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "PATCH":
+		session := SessionState{}
+		sessID, err := sess.GetSessionState(r, &session)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		upd := &SessionUpdates{}
+		if err := json.NewDecoder(r.Body).Decode(upd); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(upd.DeviceLabel) > maxDeviceLabelLength {
+			http.Error(w, fmt.Sprintf("error device label must be at most %d characters", maxDeviceLabelLength), http.StatusBadRequest)
+			return
+		}
+		session.DeviceLabel = upd.DeviceLabel
+		if err := sess.SessionsStore.Save(sessID, &session); err != nil {
+			http.Error(w, fmt.Sprintf("error saving session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		summary := session.Summary(sessID)
+		summary.Current = true
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			http.Error(w, fmt.Sprintf("error returning session json: %v", err), http.StatusInternalServerError)
+			return
+		}
 	case "DELETE":
 		session := SessionState{}
-		_, err := sessions.GetState(r, sess.Key, sess.SessionsStore, session)
+		_, err := sessions.GetState(r, sess.Key, sess.SessionsStore, &session)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
 		}
-		if _, err := sessions.EndSession(r, sess.Key, sess.SessionsStore); err != nil {
+		sessID, err := sessions.EndSession(r, sess.Key, sess.SessionsStore)
+		if err != nil {
 			http.Error(w, fmt.Sprintf("error deleting state %v", err), http.StatusInternalServerError)
+			return
+		}
+		sessions.RemoveUserSession(sess.SessionsStore, session.SessionOwner(), sessID)
+		sessions.ClearSessionID(w)
+		if session.Impersonator != nil {
+			sess.auditSession(r, audit.EventImpersonationEnded, &session, sessID, "")
+		} else {
+			sess.auditSession(r, audit.EventSignOut, &session, sessID, "")
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET, PATCH and DELETE"), http.StatusMethodNotAllowed)
+	}
+}
+
+func (sess *Ctx) UsersMeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		session := SessionState{}
+		sessID, err := sess.GetScopedSessionState(r, &session, ScopeProfileRead)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), scopeErrorStatus(err))
+			return
+		}
+		summaries, err := sess.userSessionSummaries(session.SessionOwner(), sessID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error listing sessions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(summaries); err != nil {
+			http.Error(w, fmt.Sprintf("error returning sessions json: %v", err), http.StatusInternalServerError)
+			return
 		}
 	default:
-		http.Error(w, fmt.Sprintf("only accepts DELETE"), http.StatusMethodNotAllowed)
+		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+	}
+}
+
+//userSessionSummaries lists the live sessions of a user, dropping
+//sessions that have expired from the user's index along the way
+func (sess *Ctx) userSessionSummaries(userID string, current sessions.SessionID) ([]*SessionSummary, error) {
+	sessIDs, err := sessions.UserSessions(sess.SessionsStore, userID)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]*SessionSummary, 0, len(sessIDs))
+	for _, sessID := range sessIDs {
+		state := SessionState{}
+		if err := sess.SessionsStore.Get(sessID, &state); err != nil {
+			sessions.RemoveUserSession(sess.SessionsStore, userID, sessID)
+			continue
+		}
+		summary := state.Summary(sessID)
+		summary.Current = sessID == current
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+//RefreshRequest is the body of a request to redeem a refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+//TokenResponse is returned when a stateless token is issued
+type TokenResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+func (sess *Ctx) SessionsTokensHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		// only a full session can mint tokens
+		session := SessionState{}
+		if _, err := sess.GetSessionState(r, &session); err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		// a token's X-User couldn't show an impersonator
+		if session.IsScoped() || session.Impersonator != nil {
+			http.Error(w, fmt.Sprintf("only a full session can mint tokens"), http.StatusForbidden)
+			return
+		}
+		// tokens aren't limited to scopes, so minting one counts as a write
+		if err := sess.checkVerified(&session, true); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusForbidden)
+			return
+		}
+		u := session.AuthenticatedUser
+		// the token carries the user, so it can be forwarded without a lookup
+		token, claims, err := sessions.NewToken(sess.Key, session.SessionOwner(), &sessions.TokenUser{
+			Email:       u.Email,
+			UserName:    u.UserName,
+			FirstName:   u.FirstName,
+			LastName:    u.LastName,
+			PhotoURL:    u.PhotoURL,
+			Verified:    u.Verified,
+			Roles:       u.Roles,
+			Permissions: u.Permissions,
+		}, sess.TokenLifetime)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error creating token: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(&TokenResponse{Token: token, Expires: claims.ExpiresAt()}); err != nil {
+			http.Error(w, fmt.Sprintf("error returning token json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "DELETE":
+		// revoke the token used to make this request
+		claims, err := sessions.GetToken(r, sess.Key, sess.TokenDenylist)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid token: %v", err), http.StatusUnauthorized)
+			return
+		}
+		if err := sess.TokenDenylist.Revoke(claims.ID, claims.ExpiresAt()); err != nil {
+			http.Error(w, fmt.Sprintf("error revoking token: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST and DELETE"), http.StatusMethodNotAllowed)
+	}
+}
+
+//TicketRequest is the body of a request for a ticket
+type TicketRequest struct {
+	Path string `json:"path"`
+}
+
+//TicketResponse is returned when a ticket is issued
+type TicketResponse struct {
+	Ticket  string    `json:"ticket"`
+	Expires time.Time `json:"expires"`
+}
+
+func (sess *Ctx) SessionsTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		if sessions.DefaultTickets == nil {
+			http.Error(w, fmt.Sprintf("tickets are not enabled"), http.StatusNotFound)
+			return
+		}
+		session := SessionState{}
+		sessID, err := sess.GetSessionState(r, &session)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		tr := &TicketRequest{}
+		if err := json.NewDecoder(r.Body).Decode(tr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(tr.Path, "/") {
+			http.Error(w, fmt.Sprintf("error path must start with /"), http.StatusBadRequest)
+			return
+		}
+		ticket, expires, err := sessions.DefaultTickets.Issue(sessID, tr.Path)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error creating ticket: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(&TicketResponse{Ticket: ticket.String(), Expires: expires}); err != nil {
+			http.Error(w, fmt.Sprintf("error returning ticket json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
+	}
+}
+
+//StepUpRequest is the body of a request to re-authenticate a session.
+//Users with a second factor must also set Code or RecoveryCode.
+type StepUpRequest struct {
+	Password string `json:"password"`
+	TOTPRequest
+}
+
+//SessionsStepUpHandler re-authenticates a session, e.g. one that is
+//being used from a different client than it was begun from or a
+//remember-me session about to make a sensitive change. It binds the
+//session to the current client and moves it to a new SessionID. Users
+//with a second factor must enter it too, or a stolen session and
+//password would be enough to make those changes.
+func (sess *Ctx) SessionsStepUpHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		session := SessionState{}
+		sessID, err := sess.GetSessionState(r, &session)
+		if err != nil && err != sessions.ErrStepUpRequired {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		su := &StepUpRequest{}
+		if err := json.NewDecoder(r.Body).Decode(su); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		// the session copy of the user has no password hash, and an
+		// impersonation session is re-authenticated by the admin
+		user := session.AuthenticatedUser
+		if session.Impersonator != nil {
+			user = session.Impersonator
+		}
+		u, err := sess.UsersStore.GetByID(user.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid credentials"), http.StatusUnauthorized)
+			return
+		}
+		// asked for up front, so it doesn't tell whether the password was right
+		if u.TwoFactor.Enabled && len(su.Code) == 0 && len(su.RecoveryCode) == 0 {
+			http.Error(w, fmt.Sprintf("%v", ErrTwoFactorRequired), http.StatusUnauthorized)
+			return
+		}
+		if !sess.allowSignIn(w, r, u.Email) {
+			return
+		}
+		if err := u.Authenticate(su.Password); err != nil {
+			sess.failSignIn(r, u.Email, u.ID.Hex())
+			http.Error(w, fmt.Sprintf("invalid credentials"), http.StatusUnauthorized)
+			return
+		}
+		if u.TwoFactor.Enabled {
+			ok, _, err := sess.checkSecondFactor(u, &su.TOTPRequest)
+			if err == ErrTooManyCodes {
+				http.Error(w, fmt.Sprintf("error %v", err), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("error checking code: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				sess.auditSession(r, audit.EventSignInFailed, &session, sessID, "wrong second factor")
+				http.Error(w, fmt.Sprintf("invalid credentials"), http.StatusUnauthorized)
+				return
+			}
+		}
+		sess.succeedSignIn(r, u.Email)
+		session.ClientIP = sessions.ClientIP(r)
+		session.UserAgent = r.UserAgent()
+		session.LastSeen = time.Now()
+		session.AuthTime = session.LastSeen
+		newID, err := sessions.RotateSession(sess.Key, sess.SessionsStore, sessID, &session, w)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error rotating session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		summary := session.Summary(newID)
+		summary.Current = true
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			http.Error(w, fmt.Sprintf("error returning session json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
 	}
 }
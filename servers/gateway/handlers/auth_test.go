@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+)
+
+func TestUsersMeHandlerUserName(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	signUp(t, ctx, "user2")
+
+	w := do(ctx.UsersMeHandler, "PATCH", "/v1/users/me", &users.Updates{UserName: "user2"}, auth)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d taking another user's username but got %d", http.StatusBadRequest, w.Code)
+	}
+	if u.UserName != "user1" {
+		t.Errorf("the username was changed to %s", u.UserName)
+	}
+
+	w = do(ctx.UsersMeHandler, "PATCH", "/v1/users/me", &users.Updates{UserName: "user3"}, auth)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d for a free username but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	stored, _ := ctx.UsersStore.GetByID(u.ID)
+	if stored.UserName != "user3" || stored.FirstName != "Test" || stored.LastName != "user1" {
+		t.Errorf("incorrect user after changing the username: %+v", stored)
+	}
+	if _, err := ctx.UsersStore.GetByUserName("user1"); err == nil {
+		t.Errorf("the old username still finds the user")
+	}
+}
+
+func TestUsersHandlerSearchScope(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	_, auth := signUp(t, ctx, "user1")
+
+	scoped := func(scope string) string {
+		w := do(ctx.SessionsScopedHandler, "POST", "/v1/sessions/scoped", &ScopedSessionRequest{Scopes: []string{scope}}, auth)
+		resp := &ScopedSessionResponse{}
+		if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+			t.Fatalf("error beginning scoped session: %d %v", w.Code, err)
+		}
+		return "Bearer " + resp.SessionID
+	}
+	if w := do(ctx.UsersHandler, "GET", "/v1/users?q=user", nil, scoped(ScopeMessagesRead)); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d searching without %s but got %d", http.StatusForbidden, ScopeProfileRead, w.Code)
+	}
+	if w := do(ctx.UsersHandler, "GET", "/v1/users?q=user", nil, scoped(ScopeProfileRead)); w.Code != http.StatusOK {
+		t.Errorf("expected %d searching with %s but got %d", http.StatusOK, ScopeProfileRead, w.Code)
+	}
+}
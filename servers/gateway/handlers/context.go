@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/blobs"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/indexes"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/mail"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/oidc"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
 )
 
@@ -18,4 +24,49 @@ type Ctx struct {
 	SessionsStore sessions.Store
 	UsersStore    users.Store
 	RootTrieNode  *indexes.TrieNode
+	//TokenDenylist holds revoked stateless tokens
+	TokenDenylist sessions.Denylist
+	//TokenLifetime is how long new stateless tokens are valid for
+	TokenLifetime time.Duration
+	//RefreshTokens issues refresh tokens at sign-in, if non-nil
+	RefreshTokens *sessions.RefreshTokens
+	//ImpersonationDuration is how long an impersonation session lasts
+	ImpersonationDuration time.Duration
+	//RememberMeDuration is how long sessions begun with "remember me"
+	//last when unused; 0 turns remember me off
+	RememberMeDuration time.Duration
+	//ReauthWindow is how long after signing in a remember-me session may
+	//make sensitive changes before it has to re-authenticate
+	ReauthWindow time.Duration
+	//Mailer sends email to users
+	Mailer mail.Mailer
+	//ResetCodes issues the codes emailed to reset a password
+	ResetCodes *sessions.Codes
+	//VerifyCodes issues the codes in email verification links
+	VerifyCodes *sessions.Codes
+	//CurrentEmailCodes and NewEmailCodes issue the codes sent to the
+	//current and new addresses to confirm an email change
+	CurrentEmailCodes *sessions.Codes
+	NewEmailCodes     *sessions.Codes
+	//VerifyURL is the URL of UsersVerifyHandler that links point to
+	VerifyURL string
+	//UnverifiedPolicy decides what unverified users may do
+	UnverifiedPolicy UnverifiedPolicy
+	//TOTPIssuer names this service in authenticator apps
+	TOTPIssuer string
+	//EmailThrottle and IPThrottle slow down and lock out failed
+	//sign-ins per email and per IP address, if non-nil
+	EmailThrottle *sessions.Throttle
+	IPThrottle    *sessions.Throttle
+	//TwoFactorAttempts counts each user's second factor codes since their
+	//last right one, across all of their pending sessions, if non-nil
+	TwoFactorAttempts sessions.Attempts
+	//AuditStore records sign-ins, sign-outs and account changes, if non-nil
+	AuditStore audit.Store
+	//PhotosStore holds the photos users upload
+	PhotosStore blobs.Store
+	//PhotosURL is the public URL PhotosHandler is served at, ending in '/'
+	PhotosURL string
+	//OIDCClient signs users in with an OpenID Provider, if non-nil
+	OIDCClient *oidc.Client
 }
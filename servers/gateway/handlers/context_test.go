@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/blobs"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/indexes"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/mail"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+const testPassword = "password123"
+
+//outbox is a Mailer that keeps the messages it is sent
+type outbox struct {
+	messages []*mail.Message
+	mx       sync.Mutex
+}
+
+func (o *outbox) Send(msg *mail.Message) error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+//last returns the last message sent to `to`, or nil
+func (o *outbox) last(to string) *mail.Message {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if o.messages[i].To == to {
+			return o.messages[i]
+		}
+	}
+	return nil
+}
+
+//count returns how many messages were sent to `to`
+func (o *outbox) count(to string) int {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	n := 0
+	for _, msg := range o.messages {
+		if msg.To == to {
+			n++
+		}
+	}
+	return n
+}
+
+//newTestCtx returns a Ctx backed by in-memory and temp dir stores,
+//whose Mailer is an outbox, and a func removing the temp dir
+func newTestCtx(t *testing.T) (*Ctx, func()) {
+	dir, err := ioutil.TempDir("", "handlers")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	auditStore, err := audit.NewFileStore(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("error creating audit store: %v", err)
+	}
+	photosStore, err := blobs.NewFSStore(filepath.Join(dir, "photos"))
+	if err != nil {
+		t.Fatalf("error creating photos store: %v", err)
+	}
+	key := "test key"
+	codes := sessions.NewMemStore(time.Hour, time.Minute)
+	policy := sessions.ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: time.Minute,
+	}
+	attempts := sessions.NewMemAttempts()
+	ctx := &Ctx{
+		Key:               key,
+		SessionsStore:     sessions.NewMemStore(time.Hour, time.Minute),
+		UsersStore:        users.NewMemeStore(time.Hour, time.Minute),
+		RootTrieNode:      indexes.NewTrieNode(0, nil),
+		TokenDenylist:     sessions.NewMemDenylist(),
+		TokenLifetime:     time.Minute,
+		Mailer:            &outbox{},
+		ResetCodes:        sessions.NewCodes(key, "reset", codes, time.Hour),
+		VerifyCodes:       sessions.NewCodes(key, "verify", codes, time.Hour),
+		CurrentEmailCodes: sessions.NewCodes(key, "email-current", codes, time.Hour),
+		NewEmailCodes:     sessions.NewCodes(key, "email-new", codes, time.Hour),
+		VerifyURL:         "https://example.com/v1/users/verify",
+		EmailThrottle:     sessions.NewThrottle(attempts, policy),
+		IPThrottle:        sessions.NewThrottle(attempts, policy),
+		TwoFactorAttempts: attempts,
+		AuditStore:        auditStore,
+		PhotosStore:       photosStore,
+		PhotosURL:         "/v1/photos/",
+	}
+	return ctx, func() { os.RemoveAll(dir) }
+}
+
+//do sends a request with the JSON encoding of `body`, if non-nil, and
+//the Authorization header `auth`, if non-empty, to the handler
+func do(handler http.HandlerFunc, method string, target string, body interface{}, auth string) *httptest.ResponseRecorder {
+	buf := &bytes.Buffer{}
+	if body != nil {
+		json.NewEncoder(buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, target, buf)
+	if len(auth) > 0 {
+		r.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+//signUp creates a user with testPassword and returns them
+//along with the Authorization header of their new session
+func signUp(t *testing.T, ctx *Ctx, userName string) (*users.User, string) {
+	nu := &users.NewUser{
+		Email:        userName + "@example.com",
+		Password:     testPassword,
+		PasswordConf: testPassword,
+		UserName:     userName,
+		FirstName:    "Test",
+		LastName:     userName,
+	}
+	w := do(ctx.UsersHandler, "POST", "/v1/users", nu, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("error signing up %s: %d %s", userName, w.Code, w.Body.String())
+	}
+	u, err := ctx.UsersStore.GetByUserName(userName)
+	if err != nil {
+		t.Fatalf("error getting signed up user: %v", err)
+	}
+	return u, w.Header().Get("Authorization")
+}
+
+//signIn signs in with the credentials and returns the response
+func signIn(ctx *Ctx, email string, password string) *httptest.ResponseRecorder {
+	return do(ctx.SessionsHandler, "POST", "/v1/sessions", &users.Credentials{Email: email, Password: password}, "")
+}
+
+//linkPattern finds the link in an email
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+//emailedLink returns the query of the link in the last email sent to `to`
+func emailedLink(t *testing.T, ctx *Ctx, to string) url.Values {
+	msg := ctx.Mailer.(*outbox).last(to)
+	if msg == nil {
+		t.Fatalf("no email was sent to %s", to)
+	}
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil {
+		t.Fatalf("error parsing emailed link: %v", err)
+	}
+	return link.Query()
+}
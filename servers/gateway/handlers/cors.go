@@ -14,6 +14,10 @@ with the following headers to all requests:
 */
 type CORS struct {
 	Handler http.Handler
+	//AllowedOrigins, if set, are the only origins allowed to make
+	//credentialed (cookie) requests. When empty any origin is allowed,
+	//but without credentials.
+	AllowedOrigins []string
 }
 
 func NewCORS(handler http.Handler) *CORS {
@@ -21,10 +25,21 @@ func NewCORS(handler http.Handler) *CORS {
 }
 
 func (c *CORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
+	if len(c.AllowedOrigins) == 0 {
+		w.Header().Add("Access-Control-Allow-Origin", "*")
+	} else {
+		origin := r.Header.Get("Origin")
+		for _, allowed := range c.AllowedOrigins {
+			if origin == allowed {
+				w.Header().Add("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		w.Header().Add("Vary", "Origin")
+	}
 	w.Header().Add("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Authorization")
-	w.Header().Add("Access-Control-Expose-Headers", "Authorization")
+	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token")
+	w.Header().Add("Access-Control-Expose-Headers", "Authorization, Refresh-Token")
 	w.Header().Add("Access-Control-Max-Age", "600")
 	if r.Method != "OPTIONS" {
 		c.Handler.ServeHTTP(w, r)
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/mail"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//emailChangeSubject is what email change codes are issued for, so
+//they only confirm changing this user's email to that address
+func emailChangeSubject(u *users.User, email string) string {
+	return u.ID.Hex() + "\n" + email
+}
+
+//sendEmailChangeCodes emails a code to both the user's current address
+//and the new one. Both codes are needed to change the email, so it
+//takes someone with access to the account's current mailbox, and the
+//new address can't be one the user doesn't own.
+func (ctx *Ctx) sendEmailChangeCodes(u *users.User, email string) error {
+	subject := emailChangeSubject(u, email)
+	currentCode, expires, err := ctx.CurrentEmailCodes.Issue(subject)
+	if err != nil {
+		return err
+	}
+	newCode, _, err := ctx.NewEmailCodes.Issue(subject)
+	if err != nil {
+		return err
+	}
+	err = ctx.Mailer.Send(&mail.Message{
+		To:      u.Email,
+		Subject: "Confirm your email change",
+		Body: fmt.Sprintf("Someone asked to change the email of your account to %s. "+
+			"If it was you, enter this code to confirm it:\r\n\r\n%s\r\n\r\n"+
+			"The code expires at %s. If it wasn't you, don't share the code "+
+			"and change your password.", email, currentCode, expires.Format(time.RFC1123)),
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Mailer.Send(&mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Enter this code to confirm %s as the new email of your account:\r\n\r\n%s\r\n\r\n"+
+			"The code expires at %s.", email, newCode, expires.Format(time.RFC1123)),
+	})
+}
+
+//UsersMeEmailHandler changes the signed in user's email. POST {email}
+//sends confirmation codes to the current and new addresses, and PUT
+//with both codes makes the change. POST responds the same way whether
+//or not another user has the new address, so it can't be used to find
+//out who has an account; PUT fails with 409 Conflict if one does.
+func (ctx *Ctx) UsersMeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	session := SessionState{}
+	sessID, err := ctx.GetSessionState(r, &session)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+		return
+	}
+	if session.IsScoped() || session.Impersonator != nil {
+		http.Error(w, fmt.Sprintf("only a full session can change the email"), http.StatusForbidden)
+		return
+	}
+	ec := &users.EmailChange{}
+	switch r.Method {
+	case "POST", "PUT":
+		if err := json.NewDecoder(r.Body).Decode(ec); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := users.ValidateEmail(ec.Email); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := ctx.UsersStore.GetByID(session.AuthenticatedUser.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error user not found"), http.StatusNotFound)
+			return
+		}
+		if ec.Email == u.Email {
+			http.Error(w, fmt.Sprintf("error that is already your email"), http.StatusBadRequest)
+			return
+		}
+		if r.Method == "POST" {
+			ctx.beginEmailChange(w, &session, u, ec)
+		} else {
+			ctx.confirmEmailChange(w, r, &session, sessID, u, ec)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST and PUT"), http.StatusMethodNotAllowed)
+	}
+}
+
+//beginEmailChange sends the codes to confirm the change
+func (ctx *Ctx) beginEmailChange(w http.ResponseWriter, session *SessionState, u *users.User, ec *users.EmailChange) {
+	if err := ctx.checkRecentAuth(session); err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusForbidden)
+		return
+	}
+	if err := ctx.sendEmailChangeCodes(u, ec.Email); err != nil {
+		http.Error(w, fmt.Sprintf("error sending confirmation codes: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//confirmEmailChange changes the email if both codes are valid
+func (ctx *Ctx) confirmEmailChange(w http.ResponseWriter, r *http.Request, session *SessionState, sessID sessions.SessionID, u *users.User, ec *users.EmailChange) {
+	subject := emailChangeSubject(u, ec.Email)
+	// check both before using either up, so a typo doesn't waste the other
+	if err := ctx.CurrentEmailCodes.Check(ec.CurrentEmailCode, subject); err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+	if err := ctx.NewEmailCodes.Check(ec.NewEmailCode, subject); err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+	if err := ctx.CurrentEmailCodes.Redeem(ec.CurrentEmailCode, subject); err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+	if err := ctx.NewEmailCodes.Redeem(ec.NewEmailCode, subject); err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+	err := ctx.UsersStore.SetEmail(u.ID, ec.Email)
+	if err == users.ErrEmailTaken {
+		// only someone who owns the address can learn it's taken
+		http.Error(w, fmt.Sprintf("error email already exists"), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error changing email: %v", err), http.StatusInternalServerError)
+		return
+	}
+	oldEmail := u.Email
+	u.Email = ec.Email
+	u.Verified = true
+	ctx.updateSessionUsers(u)
+	ctx.RootTrieNode.Delete(strings.ToLower(oldEmail), u.ID)
+	ctx.RootTrieNode.Add(strings.ToLower(u.Email), u.ID)
+	ctx.auditSession(r, audit.EventEmailChanged, session, sessID, fmt.Sprintf("from %s to %s", oldEmail, u.Email))
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("error returning user json: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
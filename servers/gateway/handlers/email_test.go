@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+)
+
+//emailedCode returns the code in the last email sent to `to`
+func emailedCode(t *testing.T, ctx *Ctx, to string) string {
+	msg := ctx.Mailer.(*outbox).last(to)
+	if msg == nil {
+		t.Fatalf("no email was sent to %s", to)
+	}
+	parts := strings.Split(msg.Body, "\r\n\r\n")
+	if len(parts) < 2 {
+		t.Fatalf("no code in email to %s: %q", to, msg.Body)
+	}
+	return parts[1]
+}
+
+func TestUsersMeEmailHandlerTakenEmail(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	other, _ := signUp(t, ctx, "user2")
+
+	//asking for a taken address looks like asking for a free one
+	free := do(ctx.UsersMeEmailHandler, "POST", "/v1/users/me/email", &users.EmailChange{Email: "free@example.com"}, auth)
+	taken := do(ctx.UsersMeEmailHandler, "POST", "/v1/users/me/email", &users.EmailChange{Email: other.Email}, auth)
+	if free.Code != http.StatusAccepted || taken.Code != free.Code || taken.Body.String() != free.Body.String() {
+		t.Errorf("responses differ for a free and a taken address: %d %q and %d %q",
+			free.Code, free.Body.String(), taken.Code, taken.Body.String())
+	}
+
+	//only with both codes does the change fail
+	ec := &users.EmailChange{
+		Email:            other.Email,
+		CurrentEmailCode: emailedCode(t, ctx, u.Email),
+		NewEmailCode:     emailedCode(t, ctx, other.Email),
+	}
+	if w := do(ctx.UsersMeEmailHandler, "PUT", "/v1/users/me/email", ec, auth); w.Code != http.StatusConflict {
+		t.Errorf("expected %d taking another user's email but got %d: %s", http.StatusConflict, w.Code, w.Body.String())
+	}
+	if stored, _ := ctx.UsersStore.GetByID(u.ID); stored.Email != "user1@example.com" {
+		t.Errorf("the email was changed to %s", stored.Email)
+	}
+}
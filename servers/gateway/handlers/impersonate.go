@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//ImpersonationRequest is the body of a request to impersonate a user
+type ImpersonationRequest struct {
+	UserID string `json:"userID"`
+	Reason string `json:"reason"`
+	//Scopes the session is granted, if not just impersonationScopes
+	Scopes []string `json:"scopes"`
+}
+
+//impersonationScopes are the scopes an impersonation session is
+//granted unless the request asks for others, so it can see what the
+//user sees but not change anything as them
+var impersonationScopes = []string{ScopeProfileRead, ScopeMessagesRead}
+
+//ImpersonationResponse is returned when an impersonation session is begun
+type ImpersonationResponse struct {
+	SessionID string      `json:"sessionID"`
+	Expires   time.Time   `json:"expires"`
+	Scopes    []string    `json:"scopes"`
+	User      *users.User `json:"user"`
+}
+
+//ImpersonationHandler lets an admin begin a time-limited session as
+//another user, e.g. to see what a support ticket is about. It must be
+//wrapped with RequirePermission, which also keeps impersonation sessions
+//from starting another one. The new SessionID is returned in the response
+//body, so the admin's own session is unchanged, and the session doesn't
+//count against the user's session limit, so it can't end theirs. It is
+//limited to read scopes unless the request asks for others. The started
+//event records when the session expires, since an expired session may
+//be removed by the store without ever ending in a request.
+func (ctx *Ctx) ImpersonationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		admin, _ := requestSession(r)
+		if err := ctx.checkRecentAuth(admin); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusForbidden)
+			return
+		}
+		ir := &ImpersonationRequest{}
+		if err := json.NewDecoder(r.Body).Decode(ir); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(ir.Reason) == 0 {
+			http.Error(w, fmt.Sprintf("error a reason is required"), http.StatusBadRequest)
+			return
+		}
+		scopes := impersonationScopes
+		if len(ir.Scopes) > 0 {
+			for _, scope := range ir.Scopes {
+				if !knownScopes[scope] {
+					http.Error(w, fmt.Sprintf("error unknown scope %q", scope), http.StatusBadRequest)
+					return
+				}
+			}
+			scopes = ir.Scopes
+		}
+		if !bson.IsObjectIdHex(ir.UserID) {
+			http.Error(w, fmt.Sprintf("error invalid user ID"), http.StatusBadRequest)
+			return
+		}
+		user, err := ctx.UsersStore.GetByID(bson.ObjectIdHex(ir.UserID))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error user not found"), http.StatusNotFound)
+			return
+		}
+		// acting as a privileged user could grant the admin more than they have
+		if len(user.AllPermissions()) > 0 {
+			http.Error(w, fmt.Sprintf("users with roles or permissions can't be impersonated"), http.StatusForbidden)
+			return
+		}
+		state := NewSessionState(r, user)
+		state.Impersonator = admin.AuthenticatedUser
+		state.Expires = state.TimeBegin.Add(ctx.ImpersonationDuration)
+		state.Scopes = scopes
+		sessID, err := sessions.NewDetachedSession(ctx.Key, ctx.SessionsStore, state)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.auditSession(r, audit.EventImpersonationStarted, state, sessID, fmt.Sprintf("reason: %q, scopes: %s, expires: %s",
+			ir.Reason, strings.Join(scopes, " "), state.Expires.Format(time.RFC3339)))
+		w.WriteHeader(http.StatusCreated)
+		resp := &ImpersonationResponse{
+			SessionID: sessID.String(),
+			Expires:   state.Expires,
+			Scopes:    state.Scopes,
+			User:      user,
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, fmt.Sprintf("error returning session json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+)
+
+func TestImpersonationHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	ctx.ImpersonationDuration = time.Hour
+	admin, adminAuth := signUp(t, ctx, "admin1")
+	user, _ := signUp(t, ctx, "user1")
+	ctx.UsersStore.SetRoles(admin.ID, &users.Roles{Roles: []string{users.RoleAdmin}})
+	ctx.updateSessionUsers(admin)
+	handler := ctx.RequirePermission(users.PermissionUsersImpersonate, ctx.ImpersonationHandler)
+
+	ir := &ImpersonationRequest{UserID: user.ID.Hex(), Reason: "ticket 42", Scopes: []string{"admin:everything"}}
+	if w := do(handler, "POST", "/v1/admin/impersonations", ir, adminAuth); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown scope but got %d", http.StatusBadRequest, w.Code)
+	}
+
+	ir.Scopes = nil
+	w := do(handler, "POST", "/v1/admin/impersonations", ir, adminAuth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d impersonating but got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	resp := &ImpersonationResponse{}
+	if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if strings.Join(resp.Scopes, " ") != strings.Join(impersonationScopes, " ") {
+		t.Errorf("expected scopes %v but got %v", impersonationScopes, resp.Scopes)
+	}
+
+	//the session can see the user's profile but not change it
+	auth := "Bearer " + resp.SessionID
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, auth); w.Code != http.StatusOK {
+		t.Errorf("expected %d reading the profile but got %d", http.StatusOK, w.Code)
+	}
+	if w := do(ctx.UsersMeHandler, "PATCH", "/v1/users/me", &users.Updates{FirstName: "Eve"}, auth); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d changing the profile but got %d", http.StatusForbidden, w.Code)
+	}
+
+	//the started event records when the session expires, in case
+	//it is never ended by a request
+	events, err := ctx.AuditStore.Find(&audit.Query{UserID: user.ID.Hex(), Type: audit.EventImpersonationStarted})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected 1 started event but got %d: %v", len(events), err)
+	}
+	if expires := resp.Expires.Format(time.RFC3339); !strings.Contains(events[0].Detail, expires) {
+		t.Errorf("started event %q does not record the expiry %s", events[0].Detail, expires)
+	}
+	if events[0].ActorID != admin.ID.Hex() {
+		t.Errorf("expected actor %s but got %s", admin.ID.Hex(), events[0].ActorID)
+	}
+}
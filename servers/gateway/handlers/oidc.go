@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/oidc"
+)
+
+//ErrIdentityEmailUnverified is returned when an external identity has no
+//email the provider has verified, so it can't be linked to or create a user
+var ErrIdentityEmailUnverified = errors.New("identity provider has not verified the account's email")
+
+//maxUserNameAttempts is how many suffixed usernames are tried
+//when the one derived from an identity is taken
+const maxUserNameAttempts = 5
+
+//oidcStateCookie is the name of the cookie binding a login's state to
+//the browser that began it
+const oidcStateCookie = "oidc_state"
+
+//ErrStateMismatch is returned when the OpenID Provider sends a browser
+//back with the state of a login that browser didn't begin
+var ErrStateMismatch = errors.New("login was begun in another browser")
+
+//stateCookie returns the cookie holding a hash of the login's state. It
+//is sent back with the provider's redirect, which is a top-level GET
+//navigation, so SameSite=Lax lets it through but not other cross-site
+//requests.
+func stateCookie(state string) *http.Cookie {
+	hash := sha256.Sum256([]byte(state))
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(hash[:]),
+		Path:     "/v1/sessions/oidc",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+//checkStateCookie returns ErrStateMismatch unless the request carries
+//the stateCookie of `state`
+func checkStateCookie(r *http.Request, state string) error {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return ErrStateMismatch
+	}
+	expected := stateCookie(state).Value
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(expected)) != 1 {
+		return ErrStateMismatch
+	}
+	return nil
+}
+
+//SessionsOIDCHandler begins signing in with the OpenID Provider by
+//redirecting the user there. The login's state is bound to the browser
+//with a cookie, so an attacker can't get someone else's browser to
+//complete a login they began and sign it in to the attacker's account.
+func (ctx *Ctx) SessionsOIDCHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if ctx.OIDCClient == nil {
+			http.Error(w, fmt.Sprintf("single sign-on is not enabled"), http.StatusNotFound)
+			return
+		}
+		authURL, state, err := ctx.OIDCClient.AuthCodeURL()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error beginning sign-in: %v", err), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, stateCookie(state))
+		http.Redirect(w, r, authURL, http.StatusFound)
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+	}
+}
+
+//SessionsOIDCCallbackHandler is where the OpenID Provider sends the user
+//back to. It redeems the code, finds or creates the user linked to the
+//identity, and begins a session like signing in with a password. The
+//SessionID can only reach the browser after a redirect in a cookie, so
+//this needs the sessions.CookieTransport.
+func (ctx *Ctx) SessionsOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if ctx.OIDCClient == nil {
+			http.Error(w, fmt.Sprintf("single sign-on is not enabled"), http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query()
+		if errCode := query.Get("error"); len(errCode) > 0 {
+			http.Error(w, fmt.Sprintf("sign-in failed at the identity provider: %s %s", errCode, query.Get("error_description")), http.StatusUnauthorized)
+			return
+		}
+		// the state cookie is only good for this one login
+		cleared := stateCookie("")
+		cleared.MaxAge = -1
+		http.SetCookie(w, cleared)
+		if err := checkStateCookie(r, query.Get("state")); err != nil {
+			http.Error(w, fmt.Sprintf("error completing sign-in: %v", err), http.StatusBadRequest)
+			return
+		}
+		identity, err := ctx.OIDCClient.Exchange(query.Get("state"), query.Get("code"))
+		if err == oidc.ErrInvalidState {
+			http.Error(w, fmt.Sprintf("error completing sign-in: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error completing sign-in: %v", err), http.StatusUnauthorized)
+			return
+		}
+		u, err := ctx.userForIdentity(identity)
+		if err == ErrIdentityEmailUnverified {
+			http.Error(w, fmt.Sprintf("error completing sign-in: %v", err), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error completing sign-in: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.beginSignIn(w, r, u, false, "oidc")
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+	}
+}
+
+//userForIdentity returns the user linked to the external identity. The
+//first time an identity signs in it is linked to the user with the same
+//email, or a new user is created for it. Either way the provider must
+//have verified the email, so an identity can't claim someone else's account.
+func (ctx *Ctx) userForIdentity(identity *oidc.Identity) (*users.User, error) {
+	linked := users.Identity{Issuer: identity.Issuer, Subject: identity.Subject}
+	u, err := ctx.UsersStore.GetByIdentity(linked)
+	if err == nil {
+		return u, nil
+	}
+	if err != users.ErrUserNotFound {
+		return nil, err
+	}
+	if len(identity.Email) == 0 || !identity.EmailVerified {
+		return nil, ErrIdentityEmailUnverified
+	}
+	u, err = ctx.UsersStore.GetByEmail(identity.Email)
+	if err != nil {
+		if u, err = ctx.insertIdentityUser(identity); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.UsersStore.AddIdentity(u.ID, linked); err != nil {
+		return nil, err
+	}
+	// the provider vouched for the email
+	if !u.Verified {
+		if err := ctx.UsersStore.SetVerified(u.ID); err != nil {
+			return nil, err
+		}
+	}
+	return ctx.UsersStore.GetByID(u.ID)
+}
+
+//insertIdentityUser creates a user for an external identity. The user
+//gets a random password nobody knows, so they sign in with the provider
+//until they reset it.
+func (ctx *Ctx) insertIdentityUser(identity *oidc.Identity) (*users.User, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("error generating password: %v", err)
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf)
+	userName, err := ctx.identityUserName(identity)
+	if err != nil {
+		return nil, err
+	}
+	nu := &users.NewUser{
+		Email:        identity.Email,
+		Password:     password,
+		PasswordConf: password,
+		UserName:     userName,
+		FirstName:    identity.GivenName,
+		LastName:     identity.FamilyName,
+	}
+	if err := nu.Validate(); err != nil {
+		return nil, err
+	}
+	u, err := ctx.UsersStore.Insert(nu)
+	if err != nil {
+		return nil, err
+	}
+	ctx.RootTrieNode.Add(strings.ToLower(u.Email), u.ID)
+	ctx.RootTrieNode.Add(strings.ToLower(u.UserName), u.ID)
+	ctx.RootTrieNode.Add(strings.ToLower(u.FirstName), u.ID)
+	ctx.RootTrieNode.Add(strings.ToLower(u.LastName), u.ID)
+	return u, nil
+}
+
+//identityUserName picks an unused username for an external identity,
+//from its preferred username or the start of its email
+func (ctx *Ctx) identityUserName(identity *oidc.Identity) (string, error) {
+	base := identity.PreferredUsername
+	if len(base) == 0 {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	userName := base
+	for i := 0; i < maxUserNameAttempts; i++ {
+		if _, err := ctx.UsersStore.GetByUserName(userName); err != nil {
+			return userName, nil
+		}
+		buf := make([]byte, 3)
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("error generating username: %v", err)
+		}
+		userName = fmt.Sprintf("%s%x", base, buf)
+	}
+	return "", fmt.Errorf("error no unused username for %q", base)
+}
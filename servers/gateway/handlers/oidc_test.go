@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStateCookie(t *testing.T) {
+	cookie := stateCookie("state1")
+	if cookie.Value == "state1" || !cookie.Secure || !cookie.HttpOnly {
+		t.Errorf("state cookie must hold a hash of the state and be Secure and HttpOnly: %+v", cookie)
+	}
+
+	cases := []struct {
+		name     string
+		cookie   string
+		expected error
+	}{
+		{"Same Browser", "state1", nil},
+		{"Other Login", "state2", ErrStateMismatch},
+		{"No Cookie", "", ErrStateMismatch},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/v1/sessions/oidc/callback", nil)
+		if len(c.cookie) > 0 {
+			r.AddCookie(stateCookie(c.cookie))
+		}
+		if err := checkStateCookie(r, "state1"); err != c.expected {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/blobs"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/photos"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//photosPath is the path PhotosHandler serves photos under
+const photosPath = "/v1/photos/"
+
+//photoKey returns the blob key of a user's photo at the size. Photos are
+//kept under the user's ID, so removing one user's photo can never
+//remove another's, even if they uploaded the same image.
+func photoKey(u *users.User, id string, size int) string {
+	return u.ID.Hex() + "/" + photos.Name(id, size)
+}
+
+//photoURL returns the URL the user's photo is served from. It names the
+//largest size; the others are at the same URL with the size changed.
+func (ctx *Ctx) photoURL(u *users.User, id string) string {
+	return ctx.PhotosURL + photoKey(u, id, photos.Sizes[len(photos.Sizes)-1])
+}
+
+//deletePhoto removes the blobs of the user's uploaded photo, if they have one
+func (ctx *Ctx) deletePhoto(u *users.User) {
+	prefix := ctx.PhotosURL + u.ID.Hex() + "/"
+	if !strings.HasPrefix(u.PhotoURL, prefix) {
+		return
+	}
+	name := strings.TrimPrefix(u.PhotoURL, prefix)
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return
+	}
+	id := name[:i]
+	for _, size := range photos.Sizes {
+		ctx.PhotosStore.Delete(photoKey(u, id, size))
+	}
+}
+
+//setPhotoURL saves the user's new photo URL, updates the copies of the
+//user in their sessions and records the change
+func (ctx *Ctx) setPhotoURL(r *http.Request, session *SessionState, sessID sessions.SessionID, u *users.User, photoURL string) error {
+	if err := ctx.UsersStore.SetPhotoURL(u.ID, photoURL); err != nil {
+		return err
+	}
+	u.PhotoURL = photoURL
+	ctx.updateSessionUsers(u)
+	ctx.auditSession(r, audit.EventProfileUpdated, session, sessID, "photoURL")
+	return nil
+}
+
+//UsersMePhotoHandler sets the signed in user's photo. PUT takes a JPEG,
+//PNG or GIF image as the request body and resizes it to photos.Sizes,
+//and DELETE goes back to the user's Gravatar photo.
+func (ctx *Ctx) UsersMePhotoHandler(w http.ResponseWriter, r *http.Request) {
+	session := SessionState{}
+	sessID, err := ctx.GetScopedSessionState(r, &session, ScopeProfileWrite)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not get session state %v", err), scopeErrorStatus(err))
+		return
+	}
+	u := session.AuthenticatedUser
+	switch r.Method {
+	case "PUT":
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, photos.MaxBytes))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reading photo: %v", photos.ErrTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		photo, err := photos.Process(data)
+		if err == photos.ErrTooLarge {
+			http.Error(w, fmt.Sprintf("error %v", err), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err == photos.ErrUnsupportedType {
+			http.Error(w, fmt.Sprintf("error %v", err), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error processing photo: %v", err), http.StatusBadRequest)
+			return
+		}
+		for size, sized := range photo.Sizes {
+			if err := ctx.PhotosStore.Put(photoKey(u, photo.ID, size), sized); err != nil {
+				http.Error(w, fmt.Sprintf("error saving photo: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		newURL := ctx.photoURL(u, photo.ID)
+		if newURL != u.PhotoURL {
+			old := *u
+			if err := ctx.setPhotoURL(r, &session, sessID, u, newURL); err != nil {
+				http.Error(w, fmt.Sprintf("error updating photo: %v", err), http.StatusInternalServerError)
+				return
+			}
+			ctx.deletePhoto(&old)
+		}
+	case "DELETE":
+		old := *u
+		if err := ctx.setPhotoURL(r, &session, sessID, u, users.GravatarURL(u.Email)); err != nil {
+			http.Error(w, fmt.Sprintf("error updating photo: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.deletePhoto(&old)
+	default:
+		http.Error(w, fmt.Sprintf("only accepts PUT and DELETE"), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, fmt.Sprintf("error returning user json: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+//PhotosHandler serves uploaded photos. Their URLs change whenever the
+//photo does, so they can be cached for as long as clients like.
+func (ctx *Ctx) PhotosHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, photosPath)
+	rc, err := ctx.PhotosStore.Get(key)
+	if err == blobs.ErrNotFound {
+		http.Error(w, fmt.Sprintf("error photo not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting photo: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading photo: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	// the key names the photo's content, so it makes a strong ETag
+	w.Header().Set("ETag", `"`+strings.Replace(key, "/", "-", -1)+`"`)
+	http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+}
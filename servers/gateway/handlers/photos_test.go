@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+)
+
+func TestUsersMePhotoHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+
+	put := func(data []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/v1/users/me/photo", bytes.NewReader(data))
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		ctx.UsersMePhotoHandler(w, r)
+		return w
+	}
+
+	if w := put([]byte("not an image")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected %d for a text body but got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	w := put(buf.Bytes())
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d for a PNG but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	returned := &users.User{}
+	if err := json.NewDecoder(w.Body).Decode(returned); err != nil {
+		t.Fatalf("error decoding user: %v", err)
+	}
+	if !strings.HasPrefix(returned.PhotoURL, ctx.PhotosURL) {
+		t.Fatalf("expected a photo URL under %s but got %s", ctx.PhotosURL, returned.PhotoURL)
+	}
+	if stored, _ := ctx.UsersStore.GetByID(u.ID); stored.PhotoURL != returned.PhotoURL {
+		t.Errorf("the photo URL was not saved: %s", stored.PhotoURL)
+	}
+	if w := do(ctx.PhotosHandler, "GET", returned.PhotoURL, nil, ""); w.Code != http.StatusOK {
+		t.Errorf("expected %d getting the photo but got %d", http.StatusOK, w.Code)
+	}
+
+	//going back to Gravatar removes the upload
+	if w := do(ctx.UsersMePhotoHandler, "DELETE", "/v1/users/me/photo", nil, auth); w.Code != http.StatusOK {
+		t.Fatalf("expected %d deleting the photo but got %d", http.StatusOK, w.Code)
+	}
+	if w := do(ctx.PhotosHandler, "GET", returned.PhotoURL, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d getting a deleted photo but got %d", http.StatusNotFound, w.Code)
+	}
+}
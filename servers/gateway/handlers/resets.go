@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/mail"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//ResetCodeRequest is the body of a request for a password reset code
+type ResetCodeRequest struct {
+	Email string `json:"email"`
+}
+
+//passwordsPath is the prefix of the path a password reset is PUT to
+const passwordsPath = "/v1/passwords/"
+
+//ResetsCodesHandler emails a single-use password reset code to the
+//user with the given email. It responds the same way whether or not
+//the user exists, so it can't be used to find out who has an account.
+func (ctx *Ctx) ResetsCodesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		rc := &ResetCodeRequest{}
+		if err := json.NewDecoder(r.Body).Decode(rc); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := ctx.UsersStore.GetByEmail(rc.Email)
+		if err == nil {
+			if err := ctx.sendResetCode(u); err != nil {
+				http.Error(w, fmt.Sprintf("error sending reset code: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
+	}
+}
+
+//sendResetCode issues a reset code for the user and emails it to them
+func (ctx *Ctx) sendResetCode(u *users.User) error {
+	code, expires, err := ctx.ResetCodes.Issue(u.ID.Hex())
+	if err != nil {
+		return err
+	}
+	return ctx.Mailer.Send(&mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. "+
+			"If it was you, use this code to choose a new password:\r\n\r\n%s\r\n\r\n"+
+			"The code can be used once and expires at %s. If you didn't ask "+
+			"for it, you can ignore this email.", code, expires.Format(time.RFC1123)),
+	})
+}
+
+//PasswordsHandler sets a new password for the user whose email is
+//in the path, using a reset code, and signs them out everywhere
+func (ctx *Ctx) PasswordsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		email := strings.TrimPrefix(r.URL.Path, passwordsPath)
+		pr := &users.PasswordReset{}
+		if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := ctx.UsersStore.GetByEmail(email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", sessions.ErrInvalidCode), http.StatusUnauthorized)
+			return
+		}
+		if err := users.ValidatePassword(pr.Password, pr.PasswordConf); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		if err := ctx.ResetCodes.Redeem(pr.ResetCode, u.ID.Hex()); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+			return
+		}
+		if err := u.SetPassword(pr.Password); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		if err := ctx.UsersStore.SetPassHash(u.ID, u.PassHash); err != nil {
+			http.Error(w, fmt.Sprintf("error saving password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.revokeUserSessions(u)
+		ctx.audit(r, &audit.Event{Type: audit.EventPasswordChanged, UserID: u.ID.Hex(), Detail: "reset code"})
+	default:
+		http.Error(w, fmt.Sprintf("only accepts PUT"), http.StatusMethodNotAllowed)
+	}
+}
+
+//UsersMePasswordHandler changes the signed in user's password. The
+//current password is required, so a session left signed in can't be
+//used to take over the account. Every other session is signed out and
+//the current one moves to a new SessionID.
+func (ctx *Ctx) UsersMePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		session := SessionState{}
+		sessID, err := ctx.GetSessionState(r, &session)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		if session.IsScoped() || session.Impersonator != nil {
+			http.Error(w, fmt.Sprintf("only a full session can change the password"), http.StatusForbidden)
+			return
+		}
+		pc := &users.PasswordChange{}
+		if err := json.NewDecoder(r.Body).Decode(pc); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		// the session copy of the user has no password hash
+		u, err := ctx.UsersStore.GetByID(session.AuthenticatedUser.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error user not found"), http.StatusNotFound)
+			return
+		}
+		// guessing the current password is throttled like signing in
+		if !ctx.allowSignIn(w, r, u.Email) {
+			return
+		}
+		if err := u.Authenticate(pc.CurrentPassword); err != nil {
+			ctx.failSignIn(r, u.Email, u.ID.Hex())
+			http.Error(w, fmt.Sprintf("error current password is incorrect"), http.StatusForbidden)
+			return
+		}
+		ctx.succeedSignIn(r, u.Email)
+		if err := users.ValidatePassword(pc.Password, pc.PasswordConf); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		if err := u.SetPassword(pc.Password); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		if err := ctx.UsersStore.SetPassHash(u.ID, u.PassHash); err != nil {
+			http.Error(w, fmt.Sprintf("error saving password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.revokeUserSessions(u, sessID)
+		// the user just entered their password
+		session.AuthTime = time.Now()
+		newID, err := sessions.RotateSession(ctx.Key, ctx.SessionsStore, sessID, &session, w)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error rotating session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.auditSession(r, audit.EventPasswordChanged, &session, newID, "")
+		// the old refresh tokens were revoked with the other sessions
+		if ctx.RefreshTokens != nil {
+			refreshToken, err := ctx.RefreshTokens.Issue(u.ID.Hex(), newID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("error issuing refresh token: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Add(headerRefreshToken, refreshToken.String())
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("only accepts PUT"), http.StatusMethodNotAllowed)
+	}
+}
+
+//revokeUserSessions ends all of the user's sessions, except those listed
+//in `keep`, and invalidates their stateless and refresh tokens, e.g.
+//after their password changes
+func (ctx *Ctx) revokeUserSessions(u *users.User, keep ...sessions.SessionID) {
+	userID := u.ID.Hex()
+	if err := sessions.EndUserSessions(ctx.SessionsStore, userID, keep...); err != nil {
+		log.Printf("error ending sessions of user %s: %v", userID, err)
+	}
+	if ctx.TokenDenylist != nil {
+		if err := ctx.TokenDenylist.RevokeUser(userID); err != nil {
+			log.Printf("error revoking tokens of user %s: %v", userID, err)
+		}
+	}
+	if ctx.RefreshTokens != nil {
+		if err := ctx.RefreshTokens.RevokeUser(userID); err != nil {
+			log.Printf("error revoking refresh tokens of user %s: %v", userID, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+)
+
+//resetCodePattern finds the code in a password reset email
+var resetCodePattern = regexp.MustCompile(`\r\n\r\n(\S+)\r\n\r\n`)
+
+func TestPasswordReset(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	box := ctx.Mailer.(*outbox)
+
+	//unknown emails get the same response, but no email
+	if w := do(ctx.ResetsCodesHandler, "POST", "/v1/resets/codes", &ResetCodeRequest{Email: "nobody@example.com"}, ""); w.Code != http.StatusAccepted {
+		t.Errorf("expected %d for an unknown email but got %d", http.StatusAccepted, w.Code)
+	}
+	if box.count("nobody@example.com") != 0 {
+		t.Errorf("a reset code was sent to an unknown email")
+	}
+	if w := do(ctx.ResetsCodesHandler, "POST", "/v1/resets/codes", &ResetCodeRequest{Email: u.Email}, ""); w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d requesting a reset code but got %d", http.StatusAccepted, w.Code)
+	}
+	msg := box.last(u.Email)
+	if msg == nil {
+		t.Fatalf("no reset code was sent")
+	}
+	code := resetCodePattern.FindStringSubmatch(msg.Body)[1]
+
+	newPassword := "new password 456"
+	reset := &users.PasswordReset{ResetCode: "wrong", Password: newPassword, PasswordConf: newPassword}
+	if w := do(ctx.PasswordsHandler, "PUT", passwordsPath+u.Email, reset, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for a wrong code but got %d", http.StatusUnauthorized, w.Code)
+	}
+	reset.ResetCode = code
+	if w := do(ctx.PasswordsHandler, "PUT", passwordsPath+u.Email, reset, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected %d resetting the password but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := do(ctx.PasswordsHandler, "PUT", passwordsPath+u.Email, reset, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d reusing the code but got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := signIn(ctx, u.Email, newPassword); w.Code != http.StatusOK {
+		t.Errorf("expected %d signing in with the new password but got %d", http.StatusOK, w.Code)
+	}
+	//the sessions from before the reset are signed out
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, auth); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for a session from before the reset but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestUsersMePasswordHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	other := signIn(ctx, u.Email, testPassword).Header().Get("Authorization")
+
+	newPassword := "new password 456"
+	change := &users.PasswordChange{CurrentPassword: "wrong password", Password: newPassword, PasswordConf: newPassword}
+	if w := do(ctx.UsersMePasswordHandler, "PUT", "/v1/users/me/password", change, auth); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a wrong current password but got %d", http.StatusForbidden, w.Code)
+	}
+	change.CurrentPassword = testPassword
+	w := do(ctx.UsersMePasswordHandler, "PUT", "/v1/users/me/password", change, auth)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d changing the password but got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	//the current session moves to a new SessionID, and the others end
+	rotated := w.Header().Get("Authorization")
+	if len(rotated) == 0 || rotated == auth {
+		t.Errorf("the session was not rotated")
+	}
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, rotated); w.Code != http.StatusOK {
+		t.Errorf("expected %d for the rotated session but got %d", http.StatusOK, w.Code)
+	}
+	for _, ended := range []string{auth, other} {
+		if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, ended); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected %d for an old session but got %d", http.StatusUnauthorized, w.Code)
+		}
+	}
+	if w := signIn(ctx, u.Email, newPassword); w.Code != http.StatusOK {
+		t.Errorf("expected %d signing in with the new password but got %d", http.StatusOK, w.Code)
+	}
+}
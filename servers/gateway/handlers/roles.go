@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//contextKey is the type of the keys handlers keep values under in a
+//request's context, so they can't collide with other packages' keys
+type contextKey int
+
+//authorizedSessionKey is the context key of the authorizedSession
+const authorizedSessionKey contextKey = iota
+
+//authorizedSession is the session RequirePermission authorized a request with
+type authorizedSession struct {
+	state  *SessionState
+	sessID sessions.SessionID
+}
+
+//RequirePermission wraps a handler so it is only called for requests
+//from a session whose user has the permission. Impersonation and scoped
+//sessions are refused, so a user's privileges can't be used through
+//either. The user's roles are those in the session's copy of them,
+//which AdminRolesHandler updates when it changes them. The handler
+//gets the session with requestSession.
+func (ctx *Ctx) RequirePermission(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := &SessionState{}
+		sessID, err := ctx.GetSessionState(r, state)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		if state.Impersonator != nil || state.IsScoped() {
+			http.Error(w, fmt.Sprintf("error the %s permission is required", permission), http.StatusForbidden)
+			return
+		}
+		if state.AuthenticatedUser == nil || !state.AuthenticatedUser.Can(permission) {
+			http.Error(w, fmt.Sprintf("error the %s permission is required", permission), http.StatusForbidden)
+			return
+		}
+		authorized := &authorizedSession{state: state, sessID: sessID}
+		handler(w, r.WithContext(context.WithValue(r.Context(), authorizedSessionKey, authorized)))
+	}
+}
+
+//requestSession returns the session RequirePermission authorized the request with
+func requestSession(r *http.Request) (*SessionState, sessions.SessionID) {
+	authorized, ok := r.Context().Value(authorizedSessionKey).(*authorizedSession)
+	if !ok {
+		return &SessionState{}, sessions.InvalidSessionID
+	}
+	return authorized.state, authorized.sessID
+}
+
+//RolesResponse lists the roles users may be given and what each grants
+type RolesResponse struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+//AdminRolesHandler manages users' roles. GET /v1/admin/roles lists the
+//roles and their permissions, GET /v1/admin/roles/{userID} returns a
+//user's roles and directly granted permissions, and PUT replaces them.
+func (ctx *Ctx) AdminRolesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimPrefix(r.URL.Path, "/v1/admin/roles")
+	userID = strings.TrimPrefix(userID, "/")
+	if len(userID) == 0 {
+		if r.Method != "GET" {
+			http.Error(w, fmt.Sprintf("only accepts GET"), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(&RolesResponse{Roles: users.RolePermissions}); err != nil {
+			http.Error(w, fmt.Sprintf("error returning roles json: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	if !bson.IsObjectIdHex(userID) {
+		http.Error(w, fmt.Sprintf("error invalid user ID"), http.StatusBadRequest)
+		return
+	}
+	user, err := ctx.UsersStore.GetByID(bson.ObjectIdHex(userID))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error user not found"), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		admin, sessID := requestSession(r)
+		// so an admin can't lock themselves out, or grant themselves more
+		if admin.AuthenticatedUser.ID == user.ID {
+			http.Error(w, fmt.Sprintf("error you can't change your own roles"), http.StatusForbidden)
+			return
+		}
+		if err := ctx.checkRecentAuth(admin); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusForbidden)
+			return
+		}
+		roles := &users.Roles{}
+		if err := json.NewDecoder(r.Body).Decode(roles); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := roles.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		if err := ctx.UsersStore.SetRoles(user.ID, roles); err != nil {
+			http.Error(w, fmt.Sprintf("error saving roles: %v", err), http.StatusInternalServerError)
+			return
+		}
+		user.Roles = roles.Roles
+		user.Permissions = roles.Permissions
+		// the user's sessions see the change on their next request, but
+		// their tokens claim the old roles, so they must mint new ones
+		ctx.updateSessionUsers(user)
+		if err := ctx.TokenDenylist.RevokeUser(user.ID.Hex()); err != nil {
+			http.Error(w, fmt.Sprintf("error revoking tokens: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.audit(r, &audit.Event{
+			Type:    audit.EventRolesChanged,
+			UserID:  user.ID.Hex(),
+			ActorID: admin.SessionOwner(),
+			Session: sessID.Handle(),
+			Detail:  fmt.Sprintf("roles: %v, permissions: %v", roles.Roles, roles.Permissions),
+		})
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET and PUT"), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(&users.Roles{Roles: user.Roles, Permissions: user.Permissions}); err != nil {
+		http.Error(w, fmt.Sprintf("error returning roles json: %v", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+func TestRequirePermission(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	admin, adminAuth := signUp(t, ctx, "admin1")
+	_, userAuth := signUp(t, ctx, "user1")
+	ctx.UsersStore.SetRoles(admin.ID, &users.Roles{Roles: []string{users.RoleAdmin}})
+	ctx.updateSessionUsers(admin)
+
+	var authorized *SessionState
+	handler := ctx.RequirePermission(users.PermissionAuditRead, func(w http.ResponseWriter, r *http.Request) {
+		authorized, _ = requestSession(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if w := do(handler, "GET", "/", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d without a session but got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(handler, "GET", "/", nil, userAuth); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d without the permission but got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do(handler, "GET", "/", nil, adminAuth); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d with the permission but got %d", http.StatusNoContent, w.Code)
+	}
+	if authorized == nil || !authorized.AuthenticatedUser.HasRole(users.RoleAdmin) {
+		t.Errorf("handler did not get the authorized session")
+	}
+
+	//scoped sessions can't use their user's permissions
+	w := do(ctx.SessionsScopedHandler, "POST", "/v1/sessions/scoped", &ScopedSessionRequest{Scopes: []string{ScopeProfileRead}}, adminAuth)
+	scoped := &ScopedSessionResponse{}
+	if err := json.NewDecoder(w.Body).Decode(scoped); err != nil {
+		t.Fatalf("error beginning scoped session: %d %v", w.Code, err)
+	}
+	if w := do(handler, "GET", "/", nil, "Bearer "+scoped.SessionID); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a scoped session but got %d", http.StatusForbidden, w.Code)
+	}
+
+	//taking the role away updates the session, so it takes effect at once
+	ctx.UsersStore.SetRoles(admin.ID, &users.Roles{})
+	ctx.updateSessionUsers(admin)
+	if w := do(handler, "GET", "/", nil, adminAuth); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d after the role was taken away but got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestAdminRolesHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	admin, adminAuth := signUp(t, ctx, "admin1")
+	user, userAuth := signUp(t, ctx, "user1")
+	ctx.UsersStore.SetRoles(admin.ID, &users.Roles{Roles: []string{users.RoleAdmin}})
+	ctx.updateSessionUsers(admin)
+	handler := ctx.RequirePermission(users.PermissionRolesManage, ctx.AdminRolesHandler)
+
+	w := do(ctx.SessionsTokensHandler, "POST", "/v1/sessions/tokens", nil, userAuth)
+	token := &TokenResponse{}
+	if err := json.NewDecoder(w.Body).Decode(token); err != nil {
+		t.Fatalf("error minting token: %d %v", w.Code, err)
+	}
+
+	roles := &users.Roles{Roles: []string{users.RoleModerator}}
+	if w := do(handler, "PUT", "/v1/admin/roles/"+admin.ID.Hex(), roles, adminAuth); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d changing your own roles but got %d", http.StatusForbidden, w.Code)
+	}
+	if w := do(handler, "PUT", "/v1/admin/roles/"+user.ID.Hex(), &users.Roles{Roles: []string{"superuser"}}, adminAuth); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown role but got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := do(handler, "PUT", "/v1/admin/roles/"+user.ID.Hex(), roles, adminAuth); w.Code != http.StatusOK {
+		t.Fatalf("expected %d changing roles but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	//the token claims the old roles, so it is revoked
+	if _, err := sessions.ValidateToken(token.Token, ctx.Key, ctx.TokenDenylist); err != sessions.ErrTokenRevoked {
+		t.Errorf("expected %v for a token minted before the change but got %v", sessions.ErrTokenRevoked, err)
+	}
+	//and the user's sessions forward the new permissions
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", userAuth)
+	xUser, err := ctx.XUser(r, ScopeMessagesRead)
+	if err != nil {
+		t.Fatalf("error getting X-User: %v", err)
+	}
+	if !strings.Contains(xUser, users.PermissionMessagesModerate) {
+		t.Errorf("X-User is missing the new role's permissions: %s", xUser)
+	}
+}
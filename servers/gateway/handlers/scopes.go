@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//scopes a session may be limited to
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+)
+
+//knownScopes are the scopes a scoped session may be granted
+var knownScopes = map[string]bool{
+	ScopeProfileRead:   true,
+	ScopeProfileWrite:  true,
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+}
+
+//ErrInsufficientScope is returned when a session wasn't granted
+//the scope a request needs
+var ErrInsufficientScope = errors.New("session does not have the required scope")
+
+//HasScope reports whether the session was granted `scope`. Sessions
+//begun by signing in have no Scopes and may do anything.
+func (ss *SessionState) HasScope(scope string) bool {
+	if ss.Scopes == nil || len(scope) == 0 {
+		return true
+	}
+	for _, s := range ss.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//IsScoped reports whether the session was limited to some scopes
+func (ss *SessionState) IsScoped() bool {
+	return ss.Scopes != nil
+}
+
+//GetScopedSessionState gets the state of the session the request
+//belongs to, like GetSessionState, and returns ErrInsufficientScope
+//if the session wasn't granted `scope`, or ErrUnverified if the
+//UnverifiedPolicy doesn't allow it
+func (ctx *Ctx) GetScopedSessionState(r *http.Request, state *SessionState, scope string) (sessions.SessionID, error) {
+	sid, err := ctx.GetSessionState(r, state)
+	if err != nil {
+		return sid, err
+	}
+	if !state.HasScope(scope) {
+		return sessions.InvalidSessionID, ErrInsufficientScope
+	}
+	if err := ctx.checkVerified(state, isWriteScope(scope)); err != nil {
+		return sessions.InvalidSessionID, err
+	}
+	return sid, nil
+}
+
+//scopeErrorStatus returns the status code for an error getting a
+//scoped session's state
+func scopeErrorStatus(err error) int {
+	if err == ErrInsufficientScope || err == ErrUnverified {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+//ScopedSessionRequest is the body of a request for a scoped session
+type ScopedSessionRequest struct {
+	Scopes      []string `json:"scopes"`
+	DeviceLabel string   `json:"deviceLabel"`
+}
+
+//ScopedSessionResponse is returned when a scoped session is begun
+type ScopedSessionResponse struct {
+	SessionID string    `json:"sessionID"`
+	Scopes    []string  `json:"scopes"`
+	TimeBegin time.Time `json:"timeBegin"`
+}
+
+//SessionsScopedHandler begins a session limited to some scopes for the
+//signed in user, e.g. to hand to an integration. The new SessionID is
+//returned in the response body, and the caller's session is unchanged.
+func (ctx *Ctx) SessionsScopedHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		session := SessionState{}
+		if _, err := ctx.GetSessionState(r, &session); err != nil {
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		// scoped sessions can't hand out more sessions
+		if session.IsScoped() || session.Impersonator != nil {
+			http.Error(w, fmt.Sprintf("only a full session can begin scoped sessions"), http.StatusForbidden)
+			return
+		}
+		if err := ctx.checkRecentAuth(&session); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusForbidden)
+			return
+		}
+		sr := &ScopedSessionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(sr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(sr.Scopes) == 0 {
+			http.Error(w, fmt.Sprintf("error at least one scope is required"), http.StatusBadRequest)
+			return
+		}
+		for _, scope := range sr.Scopes {
+			if !knownScopes[scope] {
+				http.Error(w, fmt.Sprintf("error unknown scope %q", scope), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(sr.DeviceLabel) > maxDeviceLabelLength {
+			http.Error(w, fmt.Sprintf("error device label must be at most %d characters", maxDeviceLabelLength), http.StatusBadRequest)
+			return
+		}
+		state := NewSessionState(r, session.AuthenticatedUser)
+		state.Scopes = sr.Scopes
+		state.DeviceLabel = sr.DeviceLabel
+		sessID, err := sessions.NewSession(ctx.Key, ctx.SessionsStore, state)
+		if err == sessions.ErrTooManySessions {
+			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		resp := &ScopedSessionResponse{
+			SessionID: sessID.String(),
+			Scopes:    state.Scopes,
+			TimeBegin: state.TimeBegin,
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, fmt.Sprintf("error returning session json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
+	}
+}
@@ -1,16 +1,191 @@
 package handlers
 
 import (
+	"errors"
+	"net/http"
 	"time"
 
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
 )
 
 //TODO: define a session state struct for this web server
 //see the assignment description for the fields you should include
 //remember that other packages can only see exported fields!
 
+//lastSeenResolution is how stale LastSeen may get before an
+//authenticated request writes the session back to the store
+const lastSeenResolution = time.Minute
+
 type SessionState struct {
 	TimeBegin         time.Time
 	AuthenticatedUser *users.User
+	//client the session was started from
+	ClientIP  string
+	UserAgent string
+	//user-editable name for the device, e.g. "work laptop"
+	DeviceLabel string
+	//last time an authenticated request used this session
+	LastSeen time.Time
+	//admin acting as AuthenticatedUser, for impersonation sessions
+	Impersonator *users.User
+	//when the session ends regardless of activity, if set
+	Expires time.Time
+	//what the session may do, nil for sessions begun by signing in
+	Scopes []string
+	//how long the session lasts unused, for remember-me sessions
+	Lifetime time.Duration
+	//last time the user entered their password for this session
+	AuthTime time.Time
+	//set until the user enters their second factor
+	PendingTwoFactor bool
+}
+
+//maxDeviceLabelLength is the longest DeviceLabel a user may set
+const maxDeviceLabelLength = 64
+
+//SessionUpdates represents allowed updates to a session
+type SessionUpdates struct {
+	DeviceLabel string `json:"deviceLabel"`
+}
+
+//SessionSummary describes one of a user's sessions in listings
+type SessionSummary struct {
+	ID          string    `json:"id"`
+	ClientIP    string    `json:"clientIP"`
+	UserAgent   string    `json:"userAgent"`
+	DeviceLabel string    `json:"deviceLabel"`
+	TimeBegin   time.Time `json:"timeBegin"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Scopes      []string  `json:"scopes,omitempty"`
+	Current     bool      `json:"current"`
+}
+
+//NewSessionState creates the state for a session that the given
+//user is beginning with the request r
+func NewSessionState(r *http.Request, user *users.User) *SessionState {
+	now := time.Now()
+	return &SessionState{
+		TimeBegin:         now,
+		AuthenticatedUser: user,
+		ClientIP:          sessions.ClientIP(r),
+		UserAgent:         r.UserAgent(),
+		LastSeen:          now,
+		AuthTime:          now,
+	}
+}
+
+//SessionDuration returns how long the session lasts unused, so
+//remember-me sessions outlive the store's session duration
+func (ss *SessionState) SessionDuration() time.Duration {
+	return ss.Lifetime
+}
+
+//ErrReauthRequired is returned when a remember-me session must
+//re-authenticate before making a sensitive change
+var ErrReauthRequired = errors.New("re-authentication required for this operation")
+
+//checkRecentAuth returns ErrReauthRequired if the session is a
+//remember-me session whose user hasn't entered their password recently
+func (ctx *Ctx) checkRecentAuth(ss *SessionState) error {
+	if ss.Lifetime > 0 && time.Since(ss.AuthTime) > ctx.ReauthWindow {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+//SessionClient returns the client the session was begun from, so
+//requests from other clients can be refused or stepped up
+func (ss *SessionState) SessionClient() (string, string) {
+	return ss.ClientIP, ss.UserAgent
+}
+
+//SessionOwner returns the ID of the authenticated user, so
+//the session store can index sessions by user
+func (ss *SessionState) SessionOwner() string {
+	if ss.AuthenticatedUser == nil {
+		return ""
+	}
+	return ss.AuthenticatedUser.ID.Hex()
+}
+
+//Summary returns the listing entry for this session
+func (ss *SessionState) Summary(sid sessions.SessionID) *SessionSummary {
+	return &SessionSummary{
+		ID:          sid.Handle(),
+		ClientIP:    ss.ClientIP,
+		UserAgent:   ss.UserAgent,
+		DeviceLabel: ss.DeviceLabel,
+		TimeBegin:   ss.TimeBegin,
+		LastSeen:    ss.LastSeen,
+		Scopes:      ss.Scopes,
+	}
+}
+
+//ErrSessionExpired is returned when a session with a fixed lifetime has ended
+var ErrSessionExpired = errors.New("session has expired")
+
+//GetSessionState gets the state of the session the request belongs to.
+//When the session's LastSeen time has gone stale it is bumped and saved,
+//so most requests don't write to the store. A session that must be
+//stepped up is returned with sessions.ErrStepUpRequired, and one that
+//is waiting for a second factor with ErrTwoFactorRequired.
+func (ctx *Ctx) GetSessionState(r *http.Request, state *SessionState) (sessions.SessionID, error) {
+	sid, err := sessions.GetState(r, ctx.Key, ctx.SessionsStore, state)
+	if err != nil && err != sessions.ErrStepUpRequired {
+		return sid, err
+	}
+	if !state.Expires.IsZero() && time.Now().After(state.Expires) {
+		if state.PendingTwoFactor {
+			// pending sessions were never started, so they don't end either
+			ctx.SessionsStore.Delete(sid)
+			return sessions.InvalidSessionID, ErrSessionExpired
+		}
+		sessions.DeleteSession(ctx.SessionsStore, sid)
+		sessions.RemoveUserSession(ctx.SessionsStore, state.SessionOwner(), sid)
+		if state.Impersonator != nil {
+			ctx.auditSession(r, audit.EventImpersonationEnded, state, sid, "expired")
+		}
+		return sessions.InvalidSessionID, ErrSessionExpired
+	}
+	if err != nil {
+		return sid, err
+	}
+	if state.PendingTwoFactor {
+		return sid, ErrTwoFactorRequired
+	}
+	if err := ctx.checkVerified(state, false); err != nil {
+		return sessions.InvalidSessionID, err
+	}
+	if time.Since(state.LastSeen) > lastSeenResolution {
+		state.LastSeen = time.Now()
+		// not if the session ended since it was read
+		if err := sessions.UpdateState(ctx.SessionsStore, sid, state); err == sessions.ErrStateNotFound {
+			return sessions.InvalidSessionID, err
+		}
+	}
+	return sid, nil
+}
+
+//updateSessionUsers replaces the copy of the user kept in each of their
+//sessions, so changes to the user show up without signing in again
+func (ctx *Ctx) updateSessionUsers(u *users.User) {
+	userID := u.ID.Hex()
+	sids, err := sessions.UserSessions(ctx.SessionsStore, userID)
+	if err != nil {
+		return
+	}
+	for _, sid := range sids {
+		state := &SessionState{}
+		if err := ctx.SessionsStore.Get(sid, state); err != nil {
+			sessions.RemoveUserSession(ctx.SessionsStore, userID, sid)
+			continue
+		}
+		state.AuthenticatedUser = u
+		// a session that ended since it was read stays ended
+		if err := sessions.UpdateState(ctx.SessionsStore, sid, state); err == sessions.ErrStateNotFound {
+			sessions.RemoveUserSession(ctx.SessionsStore, userID, sid)
+		}
+	}
 }
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//emailThrottleKey returns the Throttle key counting failed
+//sign-ins for an email, whether or not a user has it
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+//ipThrottleKey returns the Throttle key counting failed
+//sign-ins from the request's IP address
+func ipThrottleKey(r *http.Request) string {
+	return "ip:" + sessions.ClientIP(r)
+}
+
+//allowSignIn counts a sign-in attempt with the email from the request's
+//IP address, then responds with 429 Too Many Requests and returns false
+//if too many failed recently. The attempt is counted before it is made,
+//so a burst of racing guesses can't all get in before the first fails,
+//and it stays counted as a failure unless succeedSignIn or
+//releaseSignIn takes it back. The response is the same whether or not
+//a user has the email.
+func (ctx *Ctx) allowSignIn(w http.ResponseWriter, r *http.Request, email string) bool {
+	checks := []struct {
+		throttle *sessions.Throttle
+		key      string
+	}{
+		{ctx.EmailThrottle, emailThrottleKey(email)},
+		{ctx.IPThrottle, ipThrottleKey(r)},
+	}
+	var wait time.Duration
+	for _, c := range checks {
+		if c.throttle == nil {
+			continue
+		}
+		d, err := c.throttle.Attempt(c.key)
+		if err != nil && err != sessions.ErrThrottled {
+			http.Error(w, fmt.Sprintf("error checking failed sign-ins: %v", err), http.StatusInternalServerError)
+			return false
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		ctx.audit(r, &audit.Event{Type: audit.EventSignInFailed, Email: email, Detail: "throttled"})
+		seconds := int((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		http.Error(w, fmt.Sprintf("%v", sessions.ErrThrottled), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+//failSignIn records a failed sign-in with the email from the request's
+//IP, which allowSignIn already counted. `userID` is the hex ID of the
+//user with the email, if any.
+func (ctx *Ctx) failSignIn(r *http.Request, email string, userID string) {
+	ctx.audit(r, &audit.Event{Type: audit.EventSignInFailed, Email: email, UserID: userID, Detail: "wrong password"})
+}
+
+//releaseSignIn takes back the attempt allowSignIn counted, for a
+//sign-in that got past the password but isn't finished, so it doesn't
+//count as a failure, without forgetting the failures before it
+func (ctx *Ctx) releaseSignIn(r *http.Request, email string) {
+	if ctx.EmailThrottle != nil {
+		ctx.EmailThrottle.Release(emailThrottleKey(email))
+	}
+	if ctx.IPThrottle != nil {
+		ctx.IPThrottle.Release(ipThrottleKey(r))
+	}
+}
+
+//succeedSignIn forgets the failed sign-ins with the email. Failures
+//from the IP address are kept, so signing in to one account doesn't
+//let an attacker keep guessing at others, but the attempt that
+//succeeded is taken back.
+func (ctx *Ctx) succeedSignIn(r *http.Request, email string) {
+	if ctx.EmailThrottle != nil {
+		ctx.EmailThrottle.Reset(emailThrottleKey(email))
+	}
+	if ctx.IPThrottle != nil {
+		ctx.IPThrottle.Release(ipThrottleKey(r))
+	}
+}
+
+//AdminLockoutsHandler lets a moderator unlock an account that was locked
+//out by failed sign-ins: DELETE /v1/admin/lockouts/{email} forgets the
+//failures for the email, and also for the IP address in the `ip` query
+//parameter, if given. It must be wrapped with RequirePermission.
+func (ctx *Ctx) AdminLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "DELETE":
+		email := strings.TrimPrefix(r.URL.Path, "/v1/admin/lockouts/")
+		if len(email) == 0 {
+			http.Error(w, fmt.Sprintf("error an email is required"), http.StatusBadRequest)
+			return
+		}
+		if ctx.EmailThrottle != nil {
+			if err := ctx.EmailThrottle.Reset(emailThrottleKey(email)); err != nil {
+				http.Error(w, fmt.Sprintf("error unlocking account: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if ip := r.URL.Query().Get("ip"); len(ip) > 0 && ctx.IPThrottle != nil {
+			if err := ctx.IPThrottle.Reset("ip:" + ip); err != nil {
+				http.Error(w, fmt.Sprintf("error unlocking IP address: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("only accepts DELETE"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+)
+
+func TestSignInThrottle(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, _ := signUp(t, ctx, "user1")
+
+	throttled := false
+	for i := 0; i < 5 && !throttled; i++ {
+		w := signIn(ctx, u.Email, "wrong password")
+		switch w.Code {
+		case http.StatusUnauthorized:
+		case http.StatusTooManyRequests:
+			throttled = true
+			if len(w.Header().Get("Retry-After")) == 0 {
+				t.Errorf("no Retry-After header when throttled")
+			}
+		default:
+			t.Fatalf("unexpected status %d for a wrong password", w.Code)
+		}
+	}
+	if !throttled {
+		t.Fatalf("repeated wrong passwords were not throttled")
+	}
+	//the right password has to wait too
+	if w := signIn(ctx, u.Email, testPassword); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected %d for the right password while throttled but got %d", http.StatusTooManyRequests, w.Code)
+	}
+	//as do emails without an account, so the response doesn't tell them apart
+	for i := 0; i < 5; i++ {
+		signIn(ctx, "nobody@example.com", "wrong password")
+	}
+	if w := signIn(ctx, "nobody@example.com", "wrong password"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected %d for an unknown email but got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestSignInThrottleRacingGuesses(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, _ := signUp(t, ctx, "user1")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			signIn(ctx, u.Email, "wrong password")
+		}()
+	}
+	wg.Wait()
+	events, err := ctx.AuditStore.Find(&audit.Query{Email: u.Email, Type: audit.EventSignInFailed})
+	if err != nil {
+		t.Fatalf("error finding audit events: %v", err)
+	}
+	checked := 0
+	for _, e := range events {
+		if e.Detail == "wrong password" {
+			checked++
+		}
+	}
+	//the free attempts, and the first one past them
+	if checked > 4 {
+		t.Errorf("expected at most 4 passwords to be checked but %d were", checked)
+	}
+}
+
+func TestSignInThrottleForgetsSuccesses(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, _ := signUp(t, ctx, "user1")
+	//successful sign-ins from the same IP don't count against it
+	for i := 0; i < 6; i++ {
+		if w := signIn(ctx, u.Email, testPassword); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("sign-in %d was throttled", i)
+		}
+	}
+}
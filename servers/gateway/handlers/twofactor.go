@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//pendingTwoFactorLifetime is how long a user has to enter their
+//second factor after entering their password
+const pendingTwoFactorLifetime = 5 * time.Minute
+
+//maxTwoFactorAttempts is how many codes a user may enter without a
+//right one before their pending session is ended, and no more are
+//accepted until pendingTwoFactorLifetime passes without one
+const maxTwoFactorAttempts = 5
+
+//twoFactorAttemptsKey returns the key counting the
+//user's codes in TwoFactorAttempts
+func twoFactorAttemptsKey(userID string) string {
+	return "totp:" + userID
+}
+
+//ErrTwoFactorRequired is returned, along with the SessionID, for a
+//session whose user entered their password but not yet their code
+var ErrTwoFactorRequired = errors.New("second factor required")
+
+//ErrTooManyCodes is returned when a user entered maxTwoFactorAttempts
+//codes without a right one
+var ErrTooManyCodes = errors.New("too many invalid codes, sign in again later")
+
+//TwoFactorChallenge is returned when signing in needs a second factor
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool      `json:"twoFactorRequired"`
+	Expires           time.Time `json:"expires"`
+}
+
+//TOTPEnrollment is returned when TOTP enrollment begins
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	//URI is the otpauth:// URI to show as a QR code
+	URI string `json:"uri"`
+}
+
+//TOTPRequest is the body of requests that confirm or remove TOTP, or
+//complete a sign-in. Either Code or RecoveryCode must be set.
+type TOTPRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+//RecoveryCodesResponse is returned when recovery codes are generated.
+//It is the only time the codes are shown.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+//verifySecondFactor checks the code or recovery code in the request
+//against the user's second factor and records its use in the store,
+//which only lets one request use it, so neither can be used twice
+func (ctx *Ctx) verifySecondFactor(u *users.User, tr *TOTPRequest) (bool, error) {
+	tf := &u.TwoFactor
+	var err error
+	if len(tr.RecoveryCode) > 0 {
+		hash, ok := tf.MatchRecoveryCode(tr.RecoveryCode)
+		if !ok {
+			return false, nil
+		}
+		err = ctx.UsersStore.UseRecoveryCode(u.ID, hash)
+	} else {
+		counter, ok := tf.MatchCode(tr.Code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		err = ctx.UsersStore.UseTOTPCounter(u.ID, counter)
+	}
+	if err == users.ErrCodeUsed {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//checkSecondFactor counts the code in the request against the user's
+//TwoFactorAttempts and then checks it with verifySecondFactor. It is
+//counted first, atomically, so racing requests can't each get
+//maxTwoFactorAttempts tries, and only a right code forgets the count.
+//It returns how many codes were counted, including this one, and
+//ErrTooManyCodes if this one was past the limit and wasn't checked.
+func (ctx *Ctx) checkSecondFactor(u *users.User, tr *TOTPRequest) (bool, int, error) {
+	attemptsKey := twoFactorAttemptsKey(u.ID.Hex())
+	attempts := 0
+	if ctx.TwoFactorAttempts != nil {
+		f, err := ctx.TwoFactorAttempts.Fail(attemptsKey, pendingTwoFactorLifetime)
+		if err != nil {
+			return false, 0, err
+		}
+		attempts = f.Count
+		if attempts > maxTwoFactorAttempts {
+			return false, attempts, ErrTooManyCodes
+		}
+	}
+	ok, err := ctx.verifySecondFactor(u, tr)
+	if err != nil || !ok {
+		return false, attempts, err
+	}
+	if ctx.TwoFactorAttempts != nil {
+		ctx.TwoFactorAttempts.Reset(attemptsKey)
+	}
+	return true, attempts, nil
+}
+
+//UsersMeTOTPHandler enrolls the signed in user in TOTP: POST begins
+//enrollment and returns the secret, PUT confirms it with a code and
+//returns recovery codes, and DELETE removes it
+func (ctx *Ctx) UsersMeTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	session := SessionState{}
+	if _, err := ctx.GetSessionState(r, &session); err != nil {
+		http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+		return
+	}
+	if session.IsScoped() || session.Impersonator != nil {
+		http.Error(w, fmt.Sprintf("only a full session can change two factor settings"), http.StatusForbidden)
+		return
+	}
+	if err := ctx.checkRecentAuth(&session); err != nil {
+		http.Error(w, fmt.Sprintf("%v", err), http.StatusForbidden)
+		return
+	}
+	// the session copy of the user has no second factor
+	u, err := ctx.UsersStore.GetByID(session.AuthenticatedUser.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error user not found"), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "POST":
+		if u.TwoFactor.Enabled {
+			http.Error(w, fmt.Sprintf("error two factor is already enabled"), http.StatusConflict)
+			return
+		}
+		secret, err := users.NewTOTPSecret()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		tf := &users.TwoFactor{Secret: secret}
+		if err := ctx.UsersStore.SetTwoFactor(u.ID, tf); err != nil {
+			http.Error(w, fmt.Sprintf("error saving two factor: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		enrollment := &TOTPEnrollment{
+			Secret: secret,
+			URI:    tf.ProvisioningURI(ctx.TOTPIssuer, u.Email),
+		}
+		if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+			http.Error(w, fmt.Sprintf("error returning enrollment json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "PUT":
+		if u.TwoFactor.Enabled || len(u.TwoFactor.Secret) == 0 {
+			http.Error(w, fmt.Sprintf("error begin enrollment first"), http.StatusConflict)
+			return
+		}
+		tr := &TOTPRequest{}
+		if err := json.NewDecoder(r.Body).Decode(tr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		tf := &u.TwoFactor
+		if !tf.Verify(tr.Code, time.Now()) {
+			http.Error(w, fmt.Sprintf("error invalid code"), http.StatusBadRequest)
+			return
+		}
+		codes, err := tf.NewRecoveryCodes()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		tf.Enabled = true
+		if err := ctx.UsersStore.SetTwoFactor(u.ID, tf); err != nil {
+			http.Error(w, fmt.Sprintf("error saving two factor: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(&RecoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+			http.Error(w, fmt.Sprintf("error returning recovery codes json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "DELETE":
+		if !u.TwoFactor.Enabled {
+			http.Error(w, fmt.Sprintf("error two factor is not enabled"), http.StatusConflict)
+			return
+		}
+		tr := &TOTPRequest{}
+		if err := json.NewDecoder(r.Body).Decode(tr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		ok, err := ctx.verifySecondFactor(u, tr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error saving two factor: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("error invalid code"), http.StatusBadRequest)
+			return
+		}
+		if err := ctx.UsersStore.SetTwoFactor(u.ID, &users.TwoFactor{}); err != nil {
+			http.Error(w, fmt.Sprintf("error saving two factor: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST, PUT and DELETE"), http.StatusMethodNotAllowed)
+	}
+}
+
+//SessionsTOTPHandler completes a sign-in that needs a second factor,
+//moving the pending session to a full one with a new SessionID
+func (ctx *Ctx) SessionsTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		session := SessionState{}
+		sessID, err := ctx.GetSessionState(r, &session)
+		if err != ErrTwoFactorRequired {
+			if err == nil {
+				err = fmt.Errorf("session is not waiting for a second factor")
+			}
+			http.Error(w, fmt.Sprintf("Could not get session state %v", err), http.StatusUnauthorized)
+			return
+		}
+		tr := &TOTPRequest{}
+		if err := json.NewDecoder(r.Body).Decode(tr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		u, err := ctx.UsersStore.GetByID(session.AuthenticatedUser.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error user not found"), http.StatusUnauthorized)
+			return
+		}
+		// guessing codes is throttled like guessing passwords
+		if !ctx.allowSignIn(w, r, u.Email) {
+			return
+		}
+		ok, attempts, err := ctx.checkSecondFactor(u, tr)
+		if err == ErrTooManyCodes {
+			ctx.SessionsStore.Delete(sessID)
+			http.Error(w, fmt.Sprintf("error %v", err), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error checking code: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			ctx.auditSession(r, audit.EventSignInFailed, &session, sessID, "wrong second factor")
+			if attempts >= maxTwoFactorAttempts {
+				ctx.SessionsStore.Delete(sessID)
+			}
+			http.Error(w, fmt.Sprintf("error invalid code"), http.StatusUnauthorized)
+			return
+		}
+		ctx.succeedSignIn(r, u.Email)
+		session.PendingTwoFactor = false
+		session.Expires = time.Time{}
+		newID, err := sessions.CompleteSession(ctx.Key, ctx.SessionsStore, sessID, &session, w)
+		if err == sessions.ErrTooManySessions {
+			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error starting new session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		method := "totp"
+		if len(tr.RecoveryCode) > 0 {
+			method = "recovery code"
+		}
+		ctx.auditSession(r, audit.EventSignIn, &session, newID, method)
+		if ctx.RefreshTokens != nil {
+			refreshToken, err := ctx.RefreshTokens.Issue(u.ID.Hex(), newID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("error issuing refresh token: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Add(headerRefreshToken, refreshToken.String())
+		}
+		if err := json.NewEncoder(w).Encode(session.AuthenticatedUser); err != nil {
+			http.Error(w, fmt.Sprintf("error returning user json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("only accepts POST"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//enableTwoFactor enrolls the user in TOTP and returns their recovery codes
+func enableTwoFactor(t *testing.T, ctx *Ctx, u *users.User) []string {
+	secret, err := users.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating secret: %v", err)
+	}
+	tf := &users.TwoFactor{Secret: secret, Enabled: true}
+	codes, err := tf.NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("error generating recovery codes: %v", err)
+	}
+	if err := ctx.UsersStore.SetTwoFactor(u.ID, tf); err != nil {
+		t.Fatalf("error saving two factor: %v", err)
+	}
+	return codes
+}
+
+func TestSessionsTOTPHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	defer func() { sessions.DefaultSessionLimit = sessions.SessionLimit{} }()
+	sessions.DefaultSessionLimit = sessions.SessionLimit{Max: 1, Policy: sessions.EvictOldest}
+	u, auth := signUp(t, ctx, "user1")
+	codes := enableTwoFactor(t, ctx, u)
+	failures := func() int {
+		f, _ := ctx.TwoFactorAttempts.Get(emailThrottleKey(u.Email))
+		return f.Count
+	}
+
+	signIn(ctx, u.Email, "wrong password")
+	w := signIn(ctx, u.Email, testPassword)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d signing in with a second factor but got %d", http.StatusAccepted, w.Code)
+	}
+	pending := w.Header().Get("Authorization")
+	//the password alone doesn't forget the failures
+	if failures() != 1 {
+		t.Errorf("expected 1 failure after the password but got %d", failures())
+	}
+	//the pending session can't be used, and doesn't evict the user's session
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, pending); w.Code == http.StatusOK {
+		t.Errorf("a pending session could be used")
+	}
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, auth); w.Code != http.StatusOK {
+		t.Errorf("the pending session evicted the user's session: %d", w.Code)
+	}
+
+	if w := do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{Code: "000000"}, pending); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for a wrong code but got %d", http.StatusUnauthorized, w.Code)
+	}
+	if failures() != 2 {
+		t.Errorf("expected the wrong code to count against the email but got %d failures", failures())
+	}
+	w = do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{RecoveryCode: codes[0]}, pending)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d with a recovery code but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if failures() != 0 {
+		t.Errorf("expected the failures to be forgotten after the second factor but got %d", failures())
+	}
+	//the full session counts against the limit like any other
+	full := w.Header().Get("Authorization")
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, full); w.Code != http.StatusOK {
+		t.Errorf("expected %d for the full session but got %d", http.StatusOK, w.Code)
+	}
+	for _, old := range []string{pending, auth} {
+		if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, old); w.Code == http.StatusOK {
+			t.Errorf("an old session still works after completing the sign-in")
+		}
+	}
+
+	//a recovery code can only be used once
+	pending = signIn(ctx, u.Email, testPassword).Header().Get("Authorization")
+	if w := do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{RecoveryCode: codes[0]}, pending); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d using a recovery code twice but got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{RecoveryCode: codes[1]}, pending); w.Code != http.StatusOK {
+		t.Errorf("expected %d with another recovery code but got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestSessionsTOTPHandlerLimitsAttempts(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	//leave the per-user count to end the session, not the throttles
+	ctx.EmailThrottle = nil
+	ctx.IPThrottle = nil
+	u, _ := signUp(t, ctx, "user1")
+	codes := enableTwoFactor(t, ctx, u)
+
+	pending := signIn(ctx, u.Email, testPassword).Header().Get("Authorization")
+	for i := 0; i < maxTwoFactorAttempts; i++ {
+		if w := do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{Code: "000000"}, pending); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected %d for a wrong code but got %d", http.StatusUnauthorized, w.Code)
+		}
+	}
+	if w := do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{RecoveryCode: codes[0]}, pending); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the pending session to end after %d wrong codes but got %d", maxTwoFactorAttempts, w.Code)
+	}
+	//signing in again doesn't give more attempts
+	pending = signIn(ctx, u.Email, testPassword).Header().Get("Authorization")
+	if w := do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{RecoveryCode: codes[0]}, pending); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for a new pending session of a locked out user but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+//slowAttempts is an Attempts that widens the gap between
+//reading a key's failures and acting on them
+type slowAttempts struct {
+	sessions.Attempts
+}
+
+func (sa *slowAttempts) Get(key string) (*sessions.Failures, error) {
+	time.Sleep(10 * time.Millisecond)
+	return sa.Attempts.Get(key)
+}
+
+func TestSessionsTOTPHandlerLimitsRacingAttempts(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	ctx.EmailThrottle = nil
+	ctx.IPThrottle = nil
+	ctx.TwoFactorAttempts = &slowAttempts{ctx.TwoFactorAttempts}
+	u, _ := signUp(t, ctx, "user1")
+	enableTwoFactor(t, ctx, u)
+	pending := signIn(ctx, u.Email, testPassword).Header().Get("Authorization")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4*maxTwoFactorAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(ctx.SessionsTOTPHandler, "POST", "/v1/sessions/totp", &TOTPRequest{Code: "000000"}, pending)
+		}()
+	}
+	wg.Wait()
+	events, err := ctx.AuditStore.Find(&audit.Query{UserID: u.ID.Hex(), Type: audit.EventSignInFailed})
+	if err != nil {
+		t.Fatalf("error finding audit events: %v", err)
+	}
+	if len(events) > maxTwoFactorAttempts {
+		t.Errorf("expected at most %d codes to be checked but %d were", maxTwoFactorAttempts, len(events))
+	}
+}
+
+func TestSessionsStepUpHandlerTwoFactor(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	codes := enableTwoFactor(t, ctx, u)
+
+	cases := []struct {
+		name     string
+		request  *StepUpRequest
+		expected int
+	}{
+		{"Password Only", &StepUpRequest{Password: testPassword}, http.StatusUnauthorized},
+		{"Wrong Code", &StepUpRequest{Password: testPassword, TOTPRequest: TOTPRequest{Code: "000000"}}, http.StatusUnauthorized},
+		{"Wrong Password", &StepUpRequest{Password: "wrong password", TOTPRequest: TOTPRequest{RecoveryCode: codes[0]}}, http.StatusUnauthorized},
+		{"Recovery Code", &StepUpRequest{Password: testPassword, TOTPRequest: TOTPRequest{RecoveryCode: codes[0]}}, http.StatusOK},
+	}
+	for _, c := range cases {
+		w := do(ctx.SessionsStepUpHandler, "POST", "/v1/sessions/stepup", c.request, auth)
+		if w.Code != c.expected {
+			t.Errorf("case %s: expected %d but got %d: %s", c.name, c.expected, w.Code, w.Body.String())
+		}
+		if newAuth := w.Header().Get("Authorization"); len(newAuth) > 0 {
+			auth = newAuth
+		}
+	}
+	//codes are counted like when signing in, and a right one forgets them
+	if f, _ := ctx.TwoFactorAttempts.Get(twoFactorAttemptsKey(u.ID.Hex())); f.Count != 0 {
+		t.Errorf("expected the right code to forget the wrong one but got %d", f.Count)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/mail"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//UnverifiedPolicy decides what users who haven't verified their email may do
+type UnverifiedPolicy int
+
+const (
+	//AllowUnverified lets unverified users do anything
+	AllowUnverified UnverifiedPolicy = iota
+	//ReadOnlyUnverified refuses requests that need a write scope,
+	//like profile changes and sending messages
+	ReadOnlyUnverified
+	//DenyUnverified refuses every request made with the session
+	DenyUnverified
+)
+
+//ErrUnverified is returned when the policy for unverified users
+//doesn't allow the request
+var ErrUnverified = errors.New("verify your email address first")
+
+//isWriteScope reports whether requests needing the scope change things
+func isWriteScope(scope string) bool {
+	return strings.HasSuffix(scope, ":write")
+}
+
+//checkVerified returns ErrUnverified if the session's user hasn't
+//verified their email and the policy refuses the request. `write` is
+//whether the request changes things, which ReadOnlyUnverified refuses.
+func (ctx *Ctx) checkVerified(ss *SessionState, write bool) error {
+	if ss.AuthenticatedUser == nil || ss.AuthenticatedUser.Verified {
+		return nil
+	}
+	switch ctx.UnverifiedPolicy {
+	case DenyUnverified:
+		return ErrUnverified
+	case ReadOnlyUnverified:
+		if write {
+			return ErrUnverified
+		}
+	}
+	return nil
+}
+
+//VerifyRequest is the body of a request to resend a verification email
+type VerifyRequest struct {
+	Email string `json:"email"`
+}
+
+//sendVerification issues a verification code for the user and emails
+//them a link to confirm their address with
+func (ctx *Ctx) sendVerification(u *users.User) error {
+	code, expires, err := ctx.VerifyCodes.Issue(u.ID.Hex())
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("id", u.ID.Hex())
+	query.Set("code", code.String())
+	return ctx.Mailer.Send(&mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome, %s! Open this link to verify your email address:\r\n\r\n%s?%s\r\n\r\n"+
+			"The link expires at %s.", u.UserName, ctx.VerifyURL, query.Encode(), expires.Format(time.RFC1123)),
+	})
+}
+
+//VerifyResponse is returned once a user's email is verified
+type VerifyResponse struct {
+	Verified bool `json:"verified"`
+}
+
+//UsersVerifyHandler verifies a user's email with the link that was
+//emailed to them (GET), or emails a new link (POST). Verifying always
+//needs the code and returns nothing about the user, and resending is
+//throttled and responds the same way whether or not the user exists,
+//so neither can be used to find out who has an account.
+func (ctx *Ctx) UsersVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		id := r.URL.Query().Get("id")
+		if !bson.IsObjectIdHex(id) {
+			http.Error(w, fmt.Sprintf("error invalid user ID"), http.StatusBadRequest)
+			return
+		}
+		if err := ctx.VerifyCodes.Redeem(r.URL.Query().Get("code"), id); err != nil {
+			http.Error(w, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+			return
+		}
+		u, err := ctx.UsersStore.GetByID(bson.ObjectIdHex(id))
+		if err != nil {
+			// the user was deleted after the code was sent
+			http.Error(w, fmt.Sprintf("%v", sessions.ErrInvalidCode), http.StatusUnauthorized)
+			return
+		}
+		if !u.Verified {
+			if err := ctx.UsersStore.SetVerified(u.ID); err != nil {
+				http.Error(w, fmt.Sprintf("error verifying user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			u.Verified = true
+			ctx.updateSessionUsers(u)
+		}
+		if err := json.NewEncoder(w).Encode(&VerifyResponse{Verified: true}); err != nil {
+			http.Error(w, fmt.Sprintf("error returning verify json: %v", err), http.StatusInternalServerError)
+			return
+		}
+	case "POST":
+		vr := &VerifyRequest{}
+		if err := json.NewDecoder(r.Body).Decode(vr); err != nil {
+			http.Error(w, fmt.Sprintf("error decoding received json: %v", err), http.StatusBadRequest)
+			return
+		}
+		// so it can't be used to flood someone's inbox, every resend
+		// counts against the email like a failed sign-in
+		if !ctx.allowSignIn(w, r, vr.Email) {
+			return
+		}
+		if u, err := ctx.UsersStore.GetByEmail(vr.Email); err == nil && !u.Verified {
+			if err := ctx.sendVerification(u); err != nil {
+				log.Printf("error sending verification email: %v", err)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, fmt.Sprintf("only accepts GET and POST"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestUsersVerifyHandler(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	link := emailedLink(t, ctx, u.Email)
+	verify := func(id string, code string) (int, string) {
+		w := do(ctx.UsersVerifyHandler, "GET", "/v1/users/verify?id="+id+"&code="+code, nil, "")
+		return w.Code, w.Body.String()
+	}
+
+	//a wrong code gets the same response whether or not the user exists
+	if status, body := verify(u.ID.Hex(), "wrong"); status != http.StatusUnauthorized || strings.Contains(body, u.Email) {
+		t.Errorf("expected %d without the user for a wrong code but got %d: %s", http.StatusUnauthorized, status, body)
+	}
+	if status, _ := verify(bson.NewObjectId().Hex(), "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("expected %d for an unknown user but got %d", http.StatusUnauthorized, status)
+	}
+	status, body := verify(link.Get("id"), link.Get("code"))
+	if status != http.StatusOK || strings.TrimSpace(body) != `{"verified":true}` {
+		t.Fatalf("expected %d verifying but got %d: %s", http.StatusOK, status, body)
+	}
+	if stored, _ := ctx.UsersStore.GetByID(u.ID); !stored.Verified {
+		t.Errorf("user was not verified")
+	}
+	if w := do(ctx.UsersMeHandler, "GET", "/v1/users/me", nil, auth); !strings.Contains(w.Body.String(), `"verified":true`) {
+		t.Errorf("the user's session was not updated: %s", w.Body.String())
+	}
+	//verified users still need a code, and never get the user back
+	if status, body := verify(link.Get("id"), link.Get("code")); status != http.StatusUnauthorized || strings.Contains(body, u.Email) {
+		t.Errorf("expected %d without the user reusing the code but got %d: %s", http.StatusUnauthorized, status, body)
+	}
+
+	//verified users aren't sent another link
+	box := ctx.Mailer.(*outbox)
+	sent := box.count(u.Email)
+	if w := do(ctx.UsersVerifyHandler, "POST", "/v1/users/verify", &VerifyRequest{Email: u.Email}, ""); w.Code != http.StatusAccepted {
+		t.Errorf("expected %d resending but got %d", http.StatusAccepted, w.Code)
+	}
+	if box.count(u.Email) != sent {
+		t.Errorf("a link was sent to a verified user")
+	}
+}
+
+func TestUsersVerifyHandlerThrottlesResends(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, _ := signUp(t, ctx, "user1")
+	throttled := false
+	for i := 0; i < 10 && !throttled; i++ {
+		w := do(ctx.UsersVerifyHandler, "POST", "/v1/users/verify", &VerifyRequest{Email: u.Email}, "")
+		throttled = w.Code == http.StatusTooManyRequests
+	}
+	if !throttled {
+		t.Errorf("resending was never throttled")
+	}
+	if sent := ctx.Mailer.(*outbox).count(u.Email); sent >= 10 {
+		t.Errorf("expected resends to stop once throttled, but %d links were sent", sent)
+	}
+}
+
+func TestUnverifiedPolicyTokens(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	_, auth := signUp(t, ctx, "user1")
+	cases := []struct {
+		policy   UnverifiedPolicy
+		expected int
+	}{
+		{AllowUnverified, http.StatusCreated},
+		{ReadOnlyUnverified, http.StatusForbidden},
+		{DenyUnverified, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		ctx.UnverifiedPolicy = c.policy
+		if w := do(ctx.SessionsTokensHandler, "POST", "/v1/sessions/tokens", nil, auth); w.Code != c.expected {
+			t.Errorf("policy %d: expected %d minting a token but got %d", c.policy, c.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//ImpersonatedUser is the X-User forwarded for impersonation sessions,
+//so the microservices can tell an admin is acting as the user
+type ImpersonatedUser struct {
+	*users.User
+	ImpersonatorID string `json:"impersonatorID"`
+}
+
+//XUser returns the JSON for the X-User header that is forwarded to
+//the microservices for an authenticated request. Requests carrying a
+//stateless token are authenticated without touching any store: the
+//user is built from the token's verified claims, in the same shape as
+//for sessions. Sessions forward their copy of the user, which is
+//updated when the user's roles change, and tokens minted before the
+//change are revoked, so neither forwards roles that were taken away.
+//Sessions that weren't granted `scope` get ErrInsufficientScope, and
+//either gets ErrUnverified if the UnverifiedPolicy refuses it.
+//The forwarded permissions include those the user's roles grant, so
+//the microservices don't need to know what each role means.
+func (ctx *Ctx) XUser(r *http.Request, scope string) (string, error) {
+	sessionState := &SessionState{}
+	claims, err := sessions.GetToken(r, ctx.Key, ctx.TokenDenylist)
+	if err != sessions.ErrNotToken {
+		if err != nil {
+			return "", err
+		}
+		if !bson.IsObjectIdHex(claims.UserID) {
+			return "", fmt.Errorf("token has an invalid user ID")
+		}
+		sessionState.AuthenticatedUser = &users.User{
+			ID:          bson.ObjectIdHex(claims.UserID),
+			Email:       claims.Email,
+			UserName:    claims.UserName,
+			FirstName:   claims.FirstName,
+			LastName:    claims.LastName,
+			PhotoURL:    claims.PhotoURL,
+			Verified:    claims.Verified,
+			Roles:       claims.Roles,
+			Permissions: claims.Permissions,
+		}
+	} else if _, err := ctx.GetScopedSessionState(r, sessionState, scope); err != nil {
+		return "", err
+	}
+	if sessionState.AuthenticatedUser == nil {
+		return "", fmt.Errorf("session has no authenticated user")
+	}
+	// tokens weren't checked against the policy yet
+	if err := ctx.checkVerified(sessionState, isWriteScope(scope)); err != nil {
+		return "", err
+	}
+	user := *sessionState.AuthenticatedUser
+	user.Permissions = user.AllPermissions()
+	var xUser interface{} = &user
+	if sessionState.Impersonator != nil {
+		xUser = &ImpersonatedUser{
+			User:           &user,
+			ImpersonatorID: sessionState.Impersonator.ID.Hex(),
+		}
+	}
+	jsonVal, err := json.Marshal(xUser)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling json: %v", err)
+	}
+	return string(jsonVal), nil
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+)
+
+func TestXUserToken(t *testing.T) {
+	ctx, cleanup := newTestCtx(t)
+	defer cleanup()
+	u, auth := signUp(t, ctx, "user1")
+	ctx.UsersStore.SetRoles(u.ID, &users.Roles{Roles: []string{users.RoleModerator}})
+	ctx.updateSessionUsers(u)
+
+	w := do(ctx.SessionsTokensHandler, "POST", "/v1/sessions/tokens", nil, auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d minting a token but got %d", http.StatusCreated, w.Code)
+	}
+	token := &TokenResponse{}
+	if err := json.NewDecoder(w.Body).Decode(token); err != nil {
+		t.Fatalf("error minting token: %d %v", w.Code, err)
+	}
+	xUser := func(auth string) *users.User {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", auth)
+		val, err := ctx.XUser(r, ScopeMessagesRead)
+		if err != nil {
+			t.Fatalf("error getting X-User: %v", err)
+		}
+		xu := &users.User{}
+		if err := json.Unmarshal([]byte(val), xu); err != nil {
+			t.Fatalf("error decoding X-User: %v", err)
+		}
+		return xu
+	}
+
+	//the token forwards the same user as the session it was minted by
+	fromSession := xUser(auth)
+	fromToken := xUser("Bearer " + token.Token)
+	if fromToken.ID != u.ID || fromToken.UserName != fromSession.UserName || fromToken.Email != fromSession.Email ||
+		len(fromToken.Permissions) != len(fromSession.Permissions) || !fromToken.Can(users.PermissionMessagesModerate) {
+		t.Errorf("token X-User %+v differs from session X-User %+v", fromToken, fromSession)
+	}
+
+	//and is built from its claims alone
+	ctx.UsersStore.Delete(u.ID)
+	if fromToken := xUser("Bearer " + token.Token); fromToken.UserName != "user1" {
+		t.Errorf("incorrect X-User from the token's claims: %+v", fromToken)
+	}
+
+	//until the user's password changes
+	ctx.revokeUserSessions(u)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token.Token)
+	if _, err := ctx.XUser(r, ScopeMessagesRead); err == nil {
+		t.Errorf("a token still works after the user's sessions were revoked")
+	}
+}
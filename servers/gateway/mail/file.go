@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//FileMailer writes each email to a file in an outbox directory
+//instead of sending it, for local testing
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+//NewFileMailer constructs a new FileMailer, creating the
+//outbox directory if it doesn't exist
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating outbox: %v", err)
+	}
+	return &FileMailer{
+		Dir:  dir,
+		From: from,
+	}, nil
+}
+
+//Send writes the message to a new .eml file in the outbox
+func (fm *FileMailer) Send(msg *Message) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("error generating file name: %v", err)
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), hex.EncodeToString(suffix))
+	if err := ioutil.WriteFile(filepath.Join(fm.Dir, name), msg.bytes(fm.From), 0600); err != nil {
+		return fmt.Errorf("error writing mail to outbox: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer, err := NewFileMailer(filepath.Join(dir, "outbox"), "noreply@example.com")
+	if err != nil {
+		t.Fatalf("error creating FileMailer: %v", err)
+	}
+	msg := &Message{
+		To:      "kyle@example.com",
+		Subject: "Reset your password",
+		Body:    "your code is 1234",
+	}
+	if err := mailer.Send(msg); err != nil {
+		t.Fatalf("error sending mail: %v", err)
+	}
+	mailer.Send(msg)
+
+	files, err := ioutil.ReadDir(mailer.Dir)
+	if err != nil {
+		t.Fatalf("error reading outbox: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files in the outbox but got %d", len(files))
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(mailer.Dir, files[0].Name()))
+	for _, expected := range []string{"To: kyle@example.com", "From: noreply@example.com", "Subject: Reset your password", "your code is 1234"} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("mail does not contain %q:\n%s", expected, contents)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"time"
+)
+
+//Message is an email to send
+type Message struct {
+	To      string
+	Subject string
+	//Body is plain text
+	Body string
+}
+
+//Mailer sends email. Implementations include an SMTPMailer for
+//production and a FileMailer for local testing.
+type Mailer interface {
+	//Send sends the message
+	Send(msg *Message) error
+}
+
+//bytes returns the message in RFC 5322 format
+func (msg *Message) bytes(from string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "\r\n%s\r\n", msg.Body)
+	return buf.Bytes()
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+//SMTPMailer sends email through an SMTP server
+type SMTPMailer struct {
+	//Addr is the host:port of the SMTP server
+	Addr string
+	//From is the sender address
+	From string
+	//Auth authenticates with the server, or is nil if it doesn't need it
+	Auth smtp.Auth
+}
+
+//NewSMTPMailer constructs a new SMTPMailer. If `username` is
+//non-empty, PLAIN authentication is used.
+func NewSMTPMailer(addr string, from string, username string, password string) *SMTPMailer {
+	mailer := &SMTPMailer{
+		Addr: addr,
+		From: from,
+	}
+	if len(username) > 0 {
+		host, _, _ := net.SplitHostPort(addr)
+		mailer.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+//Send sends the message
+func (sm *SMTPMailer) Send(msg *Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return fmt.Errorf("error invalid recipient %q", msg.To)
+	}
+	if err := smtp.SendMail(sm.Addr, sm.Auth, sm.From, []string{msg.To}, msg.bytes(sm.From)); err != nil {
+		return fmt.Errorf("error sending mail: %v", err)
+	}
+	return nil
+}
@@ -1,45 +1,38 @@
 package main
 
 import (
-	"encoding/json"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/audit"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/blobs"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/handlers"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/indexes"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/mail"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/models/users"
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/oidc"
 	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
 	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
-func ServiceProxy(addrs []string, ctx *handlers.Ctx) *httputil.ReverseProxy {
+//ServiceProxy forwards requests to the microservices at `addrs` in turn,
+//adding the X-User header for authenticated requests. Sessions must have
+//`readScope` for GET requests and `writeScope` for any other method.
+func ServiceProxy(addrs []string, ctx *handlers.Ctx, readScope string, writeScope string) http.Handler {
 	nextIndex := 0
 	mx := sync.Mutex{}
-	return &httputil.ReverseProxy{
+	proxy := &httputil.ReverseProxy{
 		Director: func(r *http.Request) {
-			sess, errSess := sessions.GetSessionID(r, ctx.Key)
-			r.Header.Del("X-User")
-			if errSess == nil {
-				sessionState := &handlers.SessionState{}
-				ctx.SessionsStore.Get(sess, sessionState)
-				if sessionState.AuthenticatedUser != nil {
-					jsonVal, err := json.Marshal(sessionState.AuthenticatedUser)
-					if err == nil {
-						r.Header.Add("X-User", string(jsonVal))
-					} else {
-						fmt.Printf("error marshalling json: %v\n", err)
-					}
-				}
-			} else {
-				fmt.Printf("error when trying to set xuser: %v\n", errSess)
-			}
 			mx.Lock()
 			r.URL.Host = addrs[nextIndex%len(addrs)]
 			nextIndex++
@@ -47,6 +40,44 @@ func ServiceProxy(addrs []string, ctx *handlers.Ctx) *httputil.ReverseProxy {
 			r.URL.Scheme = "http"
 		},
 	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := writeScope
+		if r.Method == "GET" || r.Method == "HEAD" {
+			scope = readScope
+		}
+		xUser, errXUser := ctx.XUser(r, scope)
+		r.Header.Del("X-User")
+		if errXUser == handlers.ErrInsufficientScope || errXUser == handlers.ErrUnverified {
+			http.Error(w, fmt.Sprintf("error %v", errXUser), http.StatusForbidden)
+			return
+		}
+		if errXUser == nil {
+			r.Header.Add("X-User", xUser)
+		} else {
+			fmt.Printf("error when trying to set xuser: %v\n", errXUser)
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+//NewRedisClient creates a redis client for a single server, for the
+//master monitored by Sentinel under `sentinelMaster`, or for a Cluster
+func NewRedisClient(addrs []string, sentinelMaster string, cluster bool) redis.UniversalClient {
+	switch {
+	case len(sentinelMaster) > 0:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    sentinelMaster,
+			SentinelAddrs: addrs,
+		})
+	case cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs: addrs,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr: addrs[0],
+		})
+	}
 }
 
 //main is the main entry point for the server
@@ -71,21 +102,107 @@ func main() {
 		sessionkey = "8b3f95a3bb29d578eb4544607856e4de"
 	}
 	// REDISADDR is the address at which exists our redis server?
+	// For Sentinel or Cluster this is a comma separated list of addresses
+	// of the sentinels or the cluster nodes.
 	redisaddr := os.Getenv("REDISADDR")
 	if len(redisaddr) == 0 {
 		// default key is hash of "kyle is cool"
 		redisaddr = "127.0.0.1:6379"
 	}
+	// REDISSENTINELMASTER is the name of the master the sentinels
+	// at REDISADDR monitor. Set REDISCLUSTER=true to use Cluster mode.
+	redisSentinelMaster := os.Getenv("REDISSENTINELMASTER")
+	redisCluster := os.Getenv("REDISCLUSTER") == "true"
+	// SESSIONTRANSPORT selects how the SessionID reaches the client:
+	// "header" (Authorization: Bearer, the default) or "cookie".
+	// CSRFMODE picks the CSRF protection for cookies, "doublesubmit"
+	// (the default) or "origin", and ALLOWEDORIGINS is a comma separated
+	// list of browser origins allowed to send credentialed requests.
+	var allowedOrigins []string
+	if origins := os.Getenv("ALLOWEDORIGINS"); len(origins) > 0 {
+		allowedOrigins = strings.Split(origins, ",")
+	}
+	if os.Getenv("SESSIONTRANSPORT") == "cookie" {
+		csrfMode := sessions.CSRFDoubleSubmit
+		if os.Getenv("CSRFMODE") == "origin" {
+			csrfMode = sessions.CSRFOrigin
+		}
+		sessions.DefaultTransport = sessions.NewCookieTransport(csrfMode, allowedOrigins)
+	}
+	// MAXSESSIONS caps how many sessions each user may have at once.
+	// When a user goes over it, SESSIONLIMITPOLICY decides whether their
+	// oldest session is ended ("evict", the default) or the sign-in is
+	// refused ("reject").
+	if maxSessions, err := strconv.Atoi(os.Getenv("MAXSESSIONS")); err == nil && maxSessions > 0 {
+		sessions.DefaultSessionLimit.Max = maxSessions
+		if os.Getenv("SESSIONLIMITPOLICY") == "reject" {
+			sessions.DefaultSessionLimit.Policy = sessions.RejectNew
+		}
+	}
+	// SESSIONBINDIPV4 and SESSIONBINDIPV6 bind sessions to the subnet they
+	// were begun from, given as a prefix length (e.g. 24 and 64), and
+	// SESSIONBINDUA=true binds them to the browser family. Requests from
+	// elsewhere are refused, or with SESSIONBINDACTION=stepup the user is
+	// asked to re-enter their password at /v1/sessions/stepup.
+	if prefix, err := strconv.Atoi(os.Getenv("SESSIONBINDIPV4")); err == nil {
+		if prefix < 0 || prefix > 32 {
+			log.Fatalf("SESSIONBINDIPV4 must be between 0 and 32")
+		}
+		sessions.DefaultBinding.IPv4Prefix = prefix
+	}
+	if prefix, err := strconv.Atoi(os.Getenv("SESSIONBINDIPV6")); err == nil {
+		if prefix < 0 || prefix > 128 {
+			log.Fatalf("SESSIONBINDIPV6 must be between 0 and 128")
+		}
+		sessions.DefaultBinding.IPv6Prefix = prefix
+	}
+	sessions.DefaultBinding.UserAgentFamily = os.Getenv("SESSIONBINDUA") == "true"
+	if os.Getenv("SESSIONBINDACTION") == "stepup" {
+		sessions.DefaultBinding.Action = sessions.RequireStepUp
+	}
 	// DBADDR is the address at which exists our redis server?
 	dbaddr := os.Getenv("DBADDR")
 	if len(dbaddr) == 0 {
 		// default key is hash of "kyle is cool"
 		dbaddr = "127.0.0.1:27017"
 	}
-	redisClientInstance := redis.NewClient(&redis.Options{
-		Addr: redisaddr,
-	})
-	sessionStoreInstance := sessions.NewRedisStore(redisClientInstance, time.Hour)
+	redisClientInstance := NewRedisClient(strings.Split(redisaddr, ","), redisSentinelMaster, redisCluster)
+	var sessionStoreInstance sessions.Store = sessions.NewRedisStore(redisClientInstance, time.Hour)
+	// SESSIONCACHESIZE turns on an in-process cache of that many sessions
+	// in front of redis, each kept for SESSIONCACHETTL (default 5s).
+	// Changes are broadcast over redis pub/sub to the other gateways.
+	if cacheSize, err := strconv.Atoi(os.Getenv("SESSIONCACHESIZE")); err == nil && cacheSize > 0 {
+		cacheTTL := 5 * time.Second
+		if ttl, err := time.ParseDuration(os.Getenv("SESSIONCACHETTL")); err == nil {
+			cacheTTL = ttl
+		}
+		sessionStoreInstance = sessions.NewCachedStore(sessionStoreInstance, redisClientInstance, cacheSize, cacheTTL)
+	}
+	// SESSIONENCKEYS is a comma separated list of base64 encoded AES keys
+	// used to encrypt session state at rest. The first key encrypts, the
+	// rest are older keys that are still accepted while rotating.
+	sessionEncKeys := os.Getenv("SESSIONENCKEYS")
+	if len(sessionEncKeys) > 0 {
+		keys := [][]byte{}
+		for _, encodedKey := range strings.Split(sessionEncKeys, ",") {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+			if err != nil {
+				log.Fatalf("error decoding SESSIONENCKEYS: %v", err)
+			}
+			keys = append(keys, key)
+		}
+		encryptedStore, err := sessions.NewEncryptedStore(sessionStoreInstance, keys...)
+		if err != nil {
+			log.Fatalf("error creating encrypted session store: %v", err)
+		}
+		sessionStoreInstance = encryptedStore
+	}
+	sessions.NewSessionState = func() interface{} { return &handlers.SessionState{} }
+	// SESSIONEVENTS names a redis pub/sub channel to publish
+	// session.started and session.ended events to.
+	if sessionEvents := os.Getenv("SESSIONEVENTS"); len(sessionEvents) > 0 {
+		sessions.AddHook(sessions.NewRedisEventHook(redisClientInstance, sessionEvents))
+	}
 	sess, err := mgo.Dial(dbaddr)
 	if err != nil {
 		fmt.Printf("error connecting to db : %v\n", err)
@@ -104,25 +221,281 @@ func main() {
 	splitMessageSvcAddr := strings.Split(messageSvcAddr, ",")
 
 	usersStoreInstance := users.NewMongoStore(sess, "website", "user")
+	if err := usersStoreInstance.EnsureIndexes(); err != nil {
+		log.Fatalf("error creating user indexes: %v", err)
+	}
 	rootTrieNode := indexes.NewTrieNode(0, nil)
 	usersStoreInstance.LoadExistingUsers(rootTrieNode)
+	// TICKETLIFETIME is how long a one-time ticket, which stands in for a
+	// SessionID in query strings, stays valid. Set it to 0 to turn tickets
+	// off and accept raw SessionIDs in query strings instead.
+	ticketLifetime, err := time.ParseDuration(os.Getenv("TICKETLIFETIME"))
+	if err != nil {
+		ticketLifetime = 30 * time.Second
+	}
+	if ticketLifetime > 0 {
+		ticketStore := sessions.NewRedisStore(redisClientInstance, ticketLifetime)
+		sessions.DefaultTickets = sessions.NewTickets(sessionkey, ticketStore, ticketLifetime)
+	}
+	// TOKENLIFETIME is how long stateless tokens are valid, at most 15m
+	tokenLifetime, err := time.ParseDuration(os.Getenv("TOKENLIFETIME"))
+	if err != nil {
+		tokenLifetime = 5 * time.Minute
+	}
+	if tokenLifetime <= 0 || tokenLifetime > sessions.MaxTokenLifetime {
+		log.Fatalf("TOKENLIFETIME must be between 0 and %v", sessions.MaxTokenLifetime)
+	}
+	// REFRESHDURATION is how long an unused refresh token stays valid.
+	// Set it to 0 to turn refresh tokens off.
+	refreshDuration, err := time.ParseDuration(os.Getenv("REFRESHDURATION"))
+	if err != nil {
+		refreshDuration = 30 * 24 * time.Hour
+	}
+	var refreshTokens *sessions.RefreshTokens
+	if refreshDuration > 0 {
+		refreshStore := sessions.NewRedisStore(redisClientInstance, refreshDuration)
+		refreshTokens = sessions.NewRefreshTokens(sessionkey, refreshStore, sessionStoreInstance)
+	}
+	// ADMINUSERS is a comma separated list of the IDs of users given the
+	// admin role at startup, so there is always someone who can grant roles.
+	// IMPERSONATIONDURATION is how long an admin may act as another user.
+	for _, adminID := range strings.Split(os.Getenv("ADMINUSERS"), ",") {
+		adminID = strings.TrimSpace(adminID)
+		if !bson.IsObjectIdHex(adminID) {
+			continue
+		}
+		admin, err := usersStoreInstance.GetByID(bson.ObjectIdHex(adminID))
+		if err != nil {
+			log.Printf("error admin user %s not found: %v", adminID, err)
+			continue
+		}
+		if !admin.HasRole(users.RoleAdmin) {
+			roles := &users.Roles{Roles: append(admin.Roles, users.RoleAdmin), Permissions: admin.Permissions}
+			if err := usersStoreInstance.SetRoles(admin.ID, roles); err != nil {
+				log.Printf("error making %s an admin: %v", adminID, err)
+			}
+		}
+	}
+	impersonationDuration, err := time.ParseDuration(os.Getenv("IMPERSONATIONDURATION"))
+	if err != nil {
+		impersonationDuration = 15 * time.Minute
+	}
+	// REMEMBERMEDURATION is how long sessions begun with "rememberMe" last
+	// when unused (default 30 days, 0 turns it off). REAUTHWINDOW is how
+	// long after signing in such a session may make sensitive changes
+	// before the password has to be entered again.
+	rememberMeDuration := 30 * 24 * time.Hour
+	if d, err := time.ParseDuration(os.Getenv("REMEMBERMEDURATION")); err == nil {
+		rememberMeDuration = d
+	}
+	reauthWindow, err := time.ParseDuration(os.Getenv("REAUTHWINDOW"))
+	if err != nil {
+		reauthWindow = 10 * time.Minute
+	}
+	// MAILFROM is the address email to users is sent from. Mail goes
+	// through the SMTP server at SMTPADDR, authenticating as SMTPUSER
+	// with SMTPPASS if set, unless MAILOUTBOX names a directory to
+	// write it to instead, for local testing.
+	mailFrom := os.Getenv("MAILFROM")
+	if len(mailFrom) == 0 {
+		mailFrom = "noreply@localhost"
+	}
+	var mailer mail.Mailer
+	if outbox := os.Getenv("MAILOUTBOX"); len(outbox) > 0 {
+		mailer, err = mail.NewFileMailer(outbox, mailFrom)
+		if err != nil {
+			log.Fatalf("error creating mail outbox: %v", err)
+		}
+	} else {
+		smtpAddr := os.Getenv("SMTPADDR")
+		if len(smtpAddr) == 0 {
+			smtpAddr = "localhost:25"
+		}
+		mailer = mail.NewSMTPMailer(smtpAddr, mailFrom, os.Getenv("SMTPUSER"), os.Getenv("SMTPPASS"))
+	}
+	// RESETLIFETIME is how long an emailed password reset code stays valid
+	resetLifetime, err := time.ParseDuration(os.Getenv("RESETLIFETIME"))
+	if err != nil {
+		resetLifetime = time.Hour
+	}
+	resetCodes := sessions.NewCodes(sessionkey, "reset", sessions.NewRedisStore(redisClientInstance, resetLifetime), resetLifetime)
+	// VERIFYURL is the public URL of /v1/users/verify, which email
+	// verification links point to, and VERIFYLIFETIME is how long they
+	// stay valid. UNVERIFIEDPOLICY decides what users who haven't verified
+	// their email may do: "allow" anything (the default), "readonly" or "deny".
+	verifyURL := os.Getenv("VERIFYURL")
+	if len(verifyURL) == 0 {
+		verifyURL = "https://localhost/v1/users/verify"
+	}
+	verifyLifetime, err := time.ParseDuration(os.Getenv("VERIFYLIFETIME"))
+	if err != nil {
+		verifyLifetime = 72 * time.Hour
+	}
+	verifyCodes := sessions.NewCodes(sessionkey, "verify", sessions.NewRedisStore(redisClientInstance, verifyLifetime), verifyLifetime)
+	// EMAILCHANGELIFETIME is how long the codes confirming an email change stay valid
+	emailChangeLifetime, err := time.ParseDuration(os.Getenv("EMAILCHANGELIFETIME"))
+	if err != nil {
+		emailChangeLifetime = time.Hour
+	}
+	emailChangeStore := sessions.NewRedisStore(redisClientInstance, emailChangeLifetime)
+	currentEmailCodes := sessions.NewCodes(sessionkey, "email:current", emailChangeStore, emailChangeLifetime)
+	newEmailCodes := sessions.NewCodes(sessionkey, "email:new", emailChangeStore, emailChangeLifetime)
+	unverifiedPolicy := handlers.AllowUnverified
+	switch os.Getenv("UNVERIFIEDPOLICY") {
+	case "readonly":
+		unverifiedPolicy = handlers.ReadOnlyUnverified
+	case "deny":
+		unverifiedPolicy = handlers.DenyUnverified
+	}
+	// TOTPISSUER names the service in users' authenticator apps
+	totpIssuer := os.Getenv("TOTPISSUER")
+	if len(totpIssuer) == 0 {
+		totpIssuer = "Gateway"
+	}
+	// SIGNINLOCKOUT failed sign-ins with an email lock it out for
+	// SIGNINLOCKOUTDURATION (default 10 and 15m). After SIGNINFREEATTEMPTS
+	// failures (default 3) each further one doubles the wait before the next
+	// attempt, from 1s up to 5m. The same applies per IP address, with
+	// SIGNINIPLOCKOUT (default 100) since many users may share an address.
+	signInLockout, err := strconv.Atoi(os.Getenv("SIGNINLOCKOUT"))
+	if err != nil {
+		signInLockout = 10
+	}
+	signInIPLockout, err := strconv.Atoi(os.Getenv("SIGNINIPLOCKOUT"))
+	if err != nil {
+		signInIPLockout = 100
+	}
+	signInFreeAttempts, err := strconv.Atoi(os.Getenv("SIGNINFREEATTEMPTS"))
+	if err != nil {
+		signInFreeAttempts = 3
+	}
+	signInLockoutDuration, err := time.ParseDuration(os.Getenv("SIGNINLOCKOUTDURATION"))
+	if err != nil {
+		signInLockoutDuration = 15 * time.Minute
+	}
+	signInPolicy := sessions.ThrottlePolicy{
+		FreeAttempts:    signInFreeAttempts,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    signInLockout,
+		LockoutDuration: signInLockoutDuration,
+	}
+	signInIPPolicy := signInPolicy
+	signInIPPolicy.FreeAttempts = signInIPLockout / 2
+	signInIPPolicy.LockoutAfter = signInIPLockout
+	signInAttempts := sessions.NewRedisAttempts(redisClientInstance)
+	// AUDITLOG names a file to append the audit log to, for local testing;
+	// otherwise it is kept in the "audit" collection in mongo
+	var auditStore audit.Store
+	if auditLog := os.Getenv("AUDITLOG"); len(auditLog) > 0 {
+		auditStore, err = audit.NewFileStore(auditLog)
+		if err != nil {
+			log.Fatalf("error opening audit log: %v", err)
+		}
+	} else {
+		auditStore = audit.NewMongoStore(sess, "website", "audit")
+	}
+	// OIDCISSUER turns on single sign-on with that OpenID Provider, as
+	// the client OIDCCLIENTID with secret OIDCCLIENTSECRET. OIDCREDIRECTURL
+	// is the public URL of /v1/sessions/oidc/callback registered with it.
+	// It needs SESSIONTRANSPORT=cookie, since the browser arrives at the
+	// callback by a redirect and can't read a SessionID from a header.
+	var oidcClient *oidc.Client
+	if oidcIssuer := os.Getenv("OIDCISSUER"); len(oidcIssuer) > 0 {
+		if _, ok := sessions.DefaultTransport.(*sessions.CookieTransport); !ok {
+			log.Fatalf("error OIDCISSUER needs SESSIONTRANSPORT=cookie")
+		}
+		oidcConfig := oidc.Config{
+			Issuer:       oidcIssuer,
+			ClientID:     os.Getenv("OIDCCLIENTID"),
+			ClientSecret: os.Getenv("OIDCCLIENTSECRET"),
+			RedirectURL:  os.Getenv("OIDCREDIRECTURL"),
+		}
+		oidcStore := sessions.NewRedisStore(redisClientInstance, 10*time.Minute)
+		oidcClient, err = oidc.NewClient(oidcConfig, oidcStore, sessionkey)
+		if err != nil {
+			log.Fatalf("error setting up single sign-on: %v", err)
+		}
+	}
+	// PHOTOSDIR is the directory uploaded photos are kept in, and PHOTOSURL
+	// the public URL they're served from, if not /v1/photos/ on this server
+	photosDir := os.Getenv("PHOTOSDIR")
+	if len(photosDir) == 0 {
+		photosDir = "photos"
+	}
+	photosStore, err := blobs.NewFSStore(photosDir)
+	if err != nil {
+		log.Fatalf("error opening photos directory: %v", err)
+	}
+	photosURL := os.Getenv("PHOTOSURL")
+	if len(photosURL) == 0 {
+		photosURL = "/v1/photos/"
+	}
+	if !strings.HasSuffix(photosURL, "/") {
+		photosURL += "/"
+	}
 	handlerMux := &handlers.Ctx{
-		Key:           sessionkey,
-		SessionsStore: sessionStoreInstance,
-		UsersStore:    usersStoreInstance,
-		RootTrieNode:  rootTrieNode,
+		Key:                   sessionkey,
+		SessionsStore:         sessionStoreInstance,
+		UsersStore:            usersStoreInstance,
+		RootTrieNode:          rootTrieNode,
+		TokenDenylist:         sessions.NewRedisDenylist(redisClientInstance),
+		TokenLifetime:         tokenLifetime,
+		RefreshTokens:         refreshTokens,
+		ImpersonationDuration: impersonationDuration,
+		RememberMeDuration:    rememberMeDuration,
+		ReauthWindow:          reauthWindow,
+		Mailer:                mailer,
+		ResetCodes:            resetCodes,
+		VerifyCodes:           verifyCodes,
+		CurrentEmailCodes:     currentEmailCodes,
+		NewEmailCodes:         newEmailCodes,
+		VerifyURL:             verifyURL,
+		UnverifiedPolicy:      unverifiedPolicy,
+		TOTPIssuer:            totpIssuer,
+		EmailThrottle:         sessions.NewThrottle(signInAttempts, signInPolicy),
+		IPThrottle:            sessions.NewThrottle(signInAttempts, signInIPPolicy),
+		TwoFactorAttempts:     signInAttempts,
+		AuditStore:            auditStore,
+		PhotosStore:           photosStore,
+		PhotosURL:             photosURL,
+		OIDCClient:            oidcClient,
 	}
 
 	masterMux := http.NewServeMux()
 	masterMux.HandleFunc("/v1/users", handlerMux.UsersHandler)
 	masterMux.HandleFunc("/v1/users/me", handlerMux.UsersMeHandler)
+	masterMux.HandleFunc("/v1/users/verify", handlerMux.UsersVerifyHandler)
+	masterMux.HandleFunc("/v1/users/me/totp", handlerMux.UsersMeTOTPHandler)
+	masterMux.HandleFunc("/v1/users/me/sessions", handlerMux.UsersMeSessionsHandler)
+	masterMux.HandleFunc("/v1/users/me/activity", handlerMux.UsersMeActivityHandler)
+	masterMux.HandleFunc("/v1/users/me/password", handlerMux.UsersMePasswordHandler)
+	masterMux.HandleFunc("/v1/users/me/email", handlerMux.UsersMeEmailHandler)
+	masterMux.HandleFunc("/v1/users/me/photo", handlerMux.UsersMePhotoHandler)
+	masterMux.HandleFunc("/v1/photos/", handlerMux.PhotosHandler)
 	masterMux.HandleFunc("/v1/sessions", handlerMux.SessionsHandler)
+	masterMux.HandleFunc("/v1/sessions/totp", handlerMux.SessionsTOTPHandler)
 	masterMux.HandleFunc("/v1/sessions/mine", handlerMux.SessionsMineHandler)
-	masterMux.Handle("/v1/summary", ServiceProxy(splitSummarySvcAddr, handlerMux))
-	masterMux.Handle("/v1/messages/", ServiceProxy(splitMessageSvcAddr, handlerMux))
-	masterMux.Handle("/v1/channels/", ServiceProxy(splitMessageSvcAddr, handlerMux))
+	masterMux.HandleFunc("/v1/sessions/refresh", handlerMux.SessionsRefreshHandler)
+	masterMux.HandleFunc("/v1/sessions/tokens", handlerMux.SessionsTokensHandler)
+	masterMux.HandleFunc("/v1/sessions/tickets", handlerMux.SessionsTicketsHandler)
+	masterMux.HandleFunc("/v1/sessions/scoped", handlerMux.SessionsScopedHandler)
+	masterMux.HandleFunc("/v1/sessions/stepup", handlerMux.SessionsStepUpHandler)
+	masterMux.HandleFunc("/v1/sessions/oidc", handlerMux.SessionsOIDCHandler)
+	masterMux.HandleFunc("/v1/sessions/oidc/callback", handlerMux.SessionsOIDCCallbackHandler)
+	masterMux.HandleFunc("/v1/resets/codes", handlerMux.ResetsCodesHandler)
+	masterMux.HandleFunc("/v1/passwords/", handlerMux.PasswordsHandler)
+	masterMux.HandleFunc("/v1/admin/impersonations", handlerMux.RequirePermission(users.PermissionUsersImpersonate, handlerMux.ImpersonationHandler))
+	masterMux.HandleFunc("/v1/admin/lockouts/", handlerMux.RequirePermission(users.PermissionUsersUnlock, handlerMux.AdminLockoutsHandler))
+	masterMux.HandleFunc("/v1/admin/audit", handlerMux.RequirePermission(users.PermissionAuditRead, handlerMux.AdminAuditHandler))
+	masterMux.HandleFunc("/v1/admin/roles", handlerMux.RequirePermission(users.PermissionRolesManage, handlerMux.AdminRolesHandler))
+	masterMux.HandleFunc("/v1/admin/roles/", handlerMux.RequirePermission(users.PermissionRolesManage, handlerMux.AdminRolesHandler))
+	masterMux.Handle("/v1/summary", ServiceProxy(splitSummarySvcAddr, handlerMux, "", ""))
+	masterMux.Handle("/v1/messages/", ServiceProxy(splitMessageSvcAddr, handlerMux, handlers.ScopeMessagesRead, handlers.ScopeMessagesWrite))
+	masterMux.Handle("/v1/channels/", ServiceProxy(splitMessageSvcAddr, handlerMux, handlers.ScopeMessagesRead, handlers.ScopeMessagesWrite))
 	masterMuxCORS := &handlers.CORS{
-		Handler: masterMux,
+		Handler:        masterMux,
+		AllowedOrigins: allowedOrigins,
 	}
 
 	log.Printf("Server is started and listening for port %s!", addr)
@@ -110,6 +110,142 @@ func TestUpdate(t *testing.T) {
 	if err := store.Update(a.ID, up); err == nil {
 		t.Errorf("error expected but none occured")
 	}
+	if err := store.Update(a.ID, &Updates{UserName: b.UserName}); err != ErrUserNameTaken {
+		t.Errorf("expected %v but got %v", ErrUserNameTaken, err)
+	}
+	if err := store.Update(a.ID, &Updates{UserName: a.UserName}); err != nil {
+		t.Errorf("error keeping the same username: %v", err)
+	}
+}
+
+func TestSetPassHash(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	if err := store.SetPassHash(b.ID, []byte("hash")); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	if string(b.PassHash) != "hash" {
+		t.Errorf("error pass hash was not set")
+	}
+	if err := store.SetPassHash(bson.NewObjectId(), []byte("hash")); err != ErrUserNotFound {
+		t.Errorf("error expected %v but got %v", ErrUserNotFound, err)
+	}
+}
+
+func TestSetEmail(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	if err := store.SetEmail(c.ID, "new@example.com"); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	u, err := store.GetByEmail("new@example.com")
+	if err != nil || u.ID != c.ID || !u.Verified {
+		t.Errorf("error email was not changed and verified")
+	}
+	if err := store.SetEmail(c.ID, b.Email); err != ErrEmailTaken {
+		t.Errorf("expected %v taking another user's email but got %v", ErrEmailTaken, err)
+	}
+}
+
+func TestSetPhotoURL(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	if err := store.SetPhotoURL(b.ID, "/v1/photos/abc-256.jpg"); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	u, err := store.GetByID(b.ID)
+	if err != nil || u.PhotoURL != "/v1/photos/abc-256.jpg" {
+		t.Errorf("error photo url was not changed")
+	}
+	if err := store.SetPhotoURL(bson.NewObjectId(), "/v1/photos/abc-256.jpg"); err == nil {
+		t.Errorf("expected an error for a missing user")
+	}
+}
+
+func TestSetRoles(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	roles := &Roles{Roles: []string{RoleModerator}, Permissions: []string{PermissionAuditRead}}
+	if err := store.SetRoles(a.ID, roles); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	u, err := store.GetByID(a.ID)
+	if err != nil || !u.HasRole(RoleModerator) || !u.Can(PermissionAuditRead) {
+		t.Errorf("error roles were not changed")
+	}
+	if err := store.SetRoles(a.ID, &Roles{}); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	if len(u.AllPermissions()) != 0 {
+		t.Errorf("error roles were not removed")
+	}
+}
+
+func TestSetVerified(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	if err := store.SetVerified(c.ID); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	if !c.Verified {
+		t.Errorf("error user was not verified")
+	}
+	if a.Verified {
+		t.Errorf("error another user was verified")
+	}
+}
+
+func TestSetTwoFactor(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	tf := &TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true}
+	if err := store.SetTwoFactor(a.ID, tf); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	if a.TwoFactor.Secret != tf.Secret || !a.TwoFactor.Enabled {
+		t.Errorf("error two factor was not set")
+	}
+}
+
+func TestUseSecondFactor(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	u := &User{ID: bson.NewObjectId(), TwoFactor: TwoFactor{RecoveryCodes: []string{"abc", "def"}, LastCounter: 10}}
+	store.entries = []*User{u}
+	if err := store.UseTOTPCounter(u.ID, 10); err != ErrCodeUsed {
+		t.Errorf("expected %v for a used time step but got %v", ErrCodeUsed, err)
+	}
+	if err := store.UseTOTPCounter(u.ID, 11); err != nil || u.TwoFactor.LastCounter != 11 {
+		t.Errorf("error recording a new time step: %v", err)
+	}
+	if err := store.UseRecoveryCode(u.ID, "abc"); err != nil {
+		t.Errorf("error using a recovery code: %v", err)
+	}
+	if err := store.UseRecoveryCode(u.ID, "abc"); err != ErrCodeUsed {
+		t.Errorf("expected %v using a recovery code twice but got %v", ErrCodeUsed, err)
+	}
+	if len(u.TwoFactor.RecoveryCodes) != 1 || u.TwoFactor.RecoveryCodes[0] != "def" {
+		t.Errorf("incorrect recovery codes left: %v", u.TwoFactor.RecoveryCodes)
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	store := NewMemeStore(time.Hour, time.Minute)
+	store.entries = []*User{a, b, c}
+	identity := Identity{Issuer: "https://idp.example.com", Subject: "248289761001"}
+	if _, err := store.GetByIdentity(identity); err != ErrUserNotFound {
+		t.Errorf("expected %v for an unlinked identity but got %v", ErrUserNotFound, err)
+	}
+	if err := store.AddIdentity(b.ID, identity); err != nil {
+		t.Errorf("error when none was expected: %v", err)
+	}
+	u, err := store.GetByIdentity(identity)
+	if err != nil || u.ID != b.ID {
+		t.Errorf("error identity was not linked to the user")
+	}
+	other := Identity{Issuer: "https://other.example.com", Subject: identity.Subject}
+	if _, err := store.GetByIdentity(other); err != ErrUserNotFound {
+		t.Errorf("expected the same subject at another issuer not to match")
+	}
 }
 
 func TestDelete(t *testing.T) {
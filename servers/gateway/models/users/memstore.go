@@ -46,6 +46,18 @@ func (m *MemeStore) GetByUserName(username string) (*User, error) {
 	return InvalidUser, ErrUserNotFound
 }
 
+//GetByIdentity returns the User linked to the given external Identity
+func (m *MemeStore) GetByIdentity(identity Identity) (*User, error) {
+	for _, u := range m.entries {
+		for _, id := range u.Identities {
+			if id == identity {
+				return u, nil
+			}
+		}
+	}
+	return InvalidUser, ErrUserNotFound
+}
+
 //Insert converts the NewUser to a User, inserts
 //it into the database, and returns it
 func (m *MemeStore) Insert(newUser *NewUser) (*User, error) {
@@ -63,12 +75,128 @@ func (m *MemeStore) Update(userID bson.ObjectId, updates *Updates) error {
 	if err != nil {
 		return err
 	}
+	if len(updates.UserName) > 0 {
+		if other, err := m.GetByUserName(updates.UserName); err == nil && other.ID != userID {
+			return ErrUserNameTaken
+		}
+	}
 	if err := u.ApplyUpdates(updates); err != nil {
 		return err
 	}
 	return nil
 }
 
+//SetPassHash replaces the password hash of the given user ID
+func (m *MemeStore) SetPassHash(userID bson.ObjectId, passHash []byte) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	u.PassHash = passHash
+	return nil
+}
+
+//SetEmail replaces the email of the given user ID with one they
+//confirmed they own, so it is also marked verified
+func (m *MemeStore) SetEmail(userID bson.ObjectId, email string) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if other, err := m.GetByEmail(email); err == nil && other.ID != userID {
+		return ErrEmailTaken
+	}
+	u.Email = email
+	u.Verified = true
+	return nil
+}
+
+//SetPhotoURL replaces the photo URL of the given user ID
+func (m *MemeStore) SetPhotoURL(userID bson.ObjectId, photoURL string) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	u.PhotoURL = photoURL
+	return nil
+}
+
+//SetRoles replaces the roles and directly granted permissions of the given user ID
+func (m *MemeStore) SetRoles(userID bson.ObjectId, roles *Roles) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	u.Roles = roles.Roles
+	u.Permissions = roles.Permissions
+	return nil
+}
+
+//SetVerified marks the given user ID as having verified their email
+func (m *MemeStore) SetVerified(userID bson.ObjectId) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	u.Verified = true
+	return nil
+}
+
+//SetTwoFactor replaces the second factor of the given user ID
+func (m *MemeStore) SetTwoFactor(userID bson.ObjectId, twoFactor *TwoFactor) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	u.TwoFactor = *twoFactor
+	return nil
+}
+
+//UseTOTPCounter records that the given user ID used the TOTP code of
+//time step `counter`, returning ErrCodeUsed unless it is newer than
+//the last one they used
+func (m *MemeStore) UseTOTPCounter(userID bson.ObjectId, counter int64) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if counter <= u.TwoFactor.LastCounter {
+		return ErrCodeUsed
+	}
+	u.TwoFactor.LastCounter = counter
+	return nil
+}
+
+//UseRecoveryCode removes the recovery code with the stored hash from
+//the given user ID, returning ErrCodeUsed if they don't have it
+func (m *MemeStore) UseRecoveryCode(userID bson.ObjectId, hash string) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	remaining := []string{}
+	for _, stored := range u.TwoFactor.RecoveryCodes {
+		if stored != hash {
+			remaining = append(remaining, stored)
+		}
+	}
+	if len(remaining) == len(u.TwoFactor.RecoveryCodes) {
+		return ErrCodeUsed
+	}
+	u.TwoFactor.RecoveryCodes = remaining
+	return nil
+}
+
+//AddIdentity links an external Identity to the given user ID
+func (m *MemeStore) AddIdentity(userID bson.ObjectId, identity Identity) error {
+	u, err := m.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	u.Identities = append(u.Identities, identity)
+	return nil
+}
+
 //Delete deletes the user with the given ID
 func (m *MemeStore) Delete(userID bson.ObjectId) error {
 	for index, u := range m.entries {
@@ -79,3 +207,14 @@ func (m *MemeStore) Delete(userID bson.ObjectId) error {
 	}
 	return ErrUserNotFound
 }
+
+//ObjectIdsToUsers returns the users with the given IDs, skipping any not found
+func (m *MemeStore) ObjectIdsToUsers(ids []bson.ObjectId) []*User {
+	users := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		if u, err := m.GetByID(id); err == nil {
+			users = append(users, u)
+		}
+	}
+	return users
+}
@@ -85,6 +85,20 @@ func (ms *MongoStore) GetByUserName(username string) (*User, error) {
 	return result, nil
 }
 
+//GetByIdentity returns the User linked to the given external Identity
+func (ms *MongoStore) GetByIdentity(identity Identity) (*User, error) {
+	result := &User{}
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	query := bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": identity.Issuer, "subject": identity.Subject}}}
+	if err := col.Find(query).One(&result); err != nil {
+		if err == mgo.ErrNotFound {
+			return InvalidUser, ErrUserNotFound
+		}
+		return InvalidUser, err
+	}
+	return result, nil
+}
+
 //Insert converts the NewUser to a User, inserts
 //it into the database, and returns it
 func (ms *MongoStore) Insert(newUser *NewUser) (*User, error) {
@@ -99,25 +113,142 @@ func (ms *MongoStore) Insert(newUser *NewUser) (*User, error) {
 	return u, nil
 }
 
-//Update applies UserUpdates to the given user ID
+//EnsureIndexes creates unique indexes on email and username, so two
+//users can't end up with the same one, even when they sign up or change
+//it at the same time
+func (ms *MongoStore) EnsureIndexes() error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	for _, key := range []string{"email", "username"} {
+		if err := col.EnsureIndex(mgo.Index{Key: []string{key}, Unique: true}); err != nil {
+			return fmt.Errorf("error creating %s index: %v", key, err)
+		}
+	}
+	return nil
+}
+
+//Update applies UserUpdates to the given user ID. Only the fields the
+//updates change are written, so it can't undo changes other requests
+//make to the rest of the user at the same time.
 func (ms *MongoStore) Update(userID bson.ObjectId, updates *Updates) error {
-	updatedUser, err := ms.GetByID(userID)
+	// validate the updates the same way ApplyUpdates does
+	if err := (&User{}).ApplyUpdates(updates); err != nil {
+		return fmt.Errorf("error applying updates retrieved user: %v", err)
+	}
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	err := col.UpdateId(userID, bson.M{"$set": updates.changes()})
+	if mgo.IsDup(err) {
+		return ErrUserNameTaken
+	}
+	if err == mgo.ErrNotFound {
+		return ErrUserNotFound
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("error updating record: %v", err)
 	}
-	errUp := updatedUser.ApplyUpdates(updates)
-	if errUp != nil {
-		return fmt.Errorf("error applying updates retrieved user: %v", errUp)
+	return nil
+}
+
+//SetPassHash replaces the password hash of the given user ID
+func (ms *MongoStore) SetPassHash(userID bson.ObjectId, passHash []byte) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.UpdateId(userID, bson.M{"$set": bson.M{"passhash": passHash}}); err != nil {
+		return fmt.Errorf("error updating password: %v", err)
 	}
+	return nil
+}
 
-	change := mgo.Change{
-		Update:    bson.M{"$set": updatedUser},
-		ReturnNew: true,
+//SetEmail replaces the email of the given user ID with one they
+//confirmed they own, so it is also marked verified. The unique index
+//from EnsureIndexes keeps another user from having it at the same time.
+func (ms *MongoStore) SetEmail(userID bson.ObjectId, email string) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	err := col.UpdateId(userID, bson.M{"$set": bson.M{"email": email, "verified": true}})
+	if mgo.IsDup(err) {
+		return ErrEmailTaken
 	}
-	result := &User{}
+	if err != nil {
+		return fmt.Errorf("error updating email: %v", err)
+	}
+	return nil
+}
+
+//SetPhotoURL replaces the photo URL of the given user ID
+func (ms *MongoStore) SetPhotoURL(userID bson.ObjectId, photoURL string) error {
 	col := ms.session.DB(ms.dbname).C(ms.colname)
-	if _, err := col.FindId(userID).Apply(change, result); err != nil {
-		return fmt.Errorf("error updating record: %v", err)
+	if err := col.UpdateId(userID, bson.M{"$set": bson.M{"photourl": photoURL}}); err != nil {
+		return fmt.Errorf("error updating photo url: %v", err)
+	}
+	return nil
+}
+
+//SetRoles replaces the roles and directly granted permissions of the given user ID
+func (ms *MongoStore) SetRoles(userID bson.ObjectId, roles *Roles) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.UpdateId(userID, bson.M{"$set": bson.M{"roles": roles.Roles, "permissions": roles.Permissions}}); err != nil {
+		return fmt.Errorf("error updating roles: %v", err)
+	}
+	return nil
+}
+
+//SetVerified marks the given user ID as having verified their email
+func (ms *MongoStore) SetVerified(userID bson.ObjectId) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.UpdateId(userID, bson.M{"$set": bson.M{"verified": true}}); err != nil {
+		return fmt.Errorf("error updating verified: %v", err)
+	}
+	return nil
+}
+
+//SetTwoFactor replaces the second factor of the given user ID
+func (ms *MongoStore) SetTwoFactor(userID bson.ObjectId, twoFactor *TwoFactor) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.UpdateId(userID, bson.M{"$set": bson.M{"twofactor": twoFactor}}); err != nil {
+		return fmt.Errorf("error updating two factor: %v", err)
+	}
+	return nil
+}
+
+//UseTOTPCounter records that the given user ID used the TOTP code of
+//time step `counter`, returning ErrCodeUsed unless it is newer than
+//the last one they used. Only one of any racing requests matches.
+func (ms *MongoStore) UseTOTPCounter(userID bson.ObjectId, counter int64) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	err := col.Update(
+		bson.M{"_id": userID, "twofactor.lastcounter": bson.M{"$lt": counter}},
+		bson.M{"$set": bson.M{"twofactor.lastcounter": counter}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrCodeUsed
+	}
+	if err != nil {
+		return fmt.Errorf("error updating two factor: %v", err)
+	}
+	return nil
+}
+
+//UseRecoveryCode removes the recovery code with the stored hash from
+//the given user ID, returning ErrCodeUsed if they don't have it. Only
+//one of any racing requests matches.
+func (ms *MongoStore) UseRecoveryCode(userID bson.ObjectId, hash string) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	err := col.Update(
+		bson.M{"_id": userID, "twofactor.recoverycodes": hash},
+		bson.M{"$pull": bson.M{"twofactor.recoverycodes": hash}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrCodeUsed
+	}
+	if err != nil {
+		return fmt.Errorf("error updating two factor: %v", err)
+	}
+	return nil
+}
+
+//AddIdentity links an external Identity to the given user ID
+func (ms *MongoStore) AddIdentity(userID bson.ObjectId, identity Identity) error {
+	col := ms.session.DB(ms.dbname).C(ms.colname)
+	if err := col.UpdateId(userID, bson.M{"$addToSet": bson.M{"identities": identity}}); err != nil {
+		return fmt.Errorf("error adding identity: %v", err)
 	}
 	return nil
 }
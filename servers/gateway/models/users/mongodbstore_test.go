@@ -154,6 +154,235 @@ func TestMongoUpdate(t *testing.T) {
 	}
 }
 
+func TestMongoUpdateOnlyChangedFields(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	if err := ms.EnsureIndexes(); err != nil {
+		t.Fatalf("error creating indexes: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	user2, _ := ms.Insert(nuTest2)
+	// written after user1 was read, as by a concurrent request
+	if err := ms.SetRoles(user1.ID, &Roles{Roles: []string{RoleAdmin}}); err != nil {
+		t.Fatalf("error setting roles: %v", err)
+	}
+	if err := ms.Update(user1.ID, &Updates{FirstName: "Kyle2"}); err != nil {
+		t.Errorf("error updating user: %v", err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if user.FirstName != "Kyle2" || user.LastName != nuTest1.LastName || !user.HasRole(RoleAdmin) {
+		t.Errorf("error update changed more than the first name: %+v", user)
+	}
+	if err := ms.Update(user1.ID, &Updates{UserName: user2.UserName}); err != ErrUserNameTaken {
+		t.Errorf("expected %v but got %v", ErrUserNameTaken, err)
+	}
+}
+
+func TestMongoSetPassHash(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	if err := user1.SetPassword("new password"); err != nil {
+		t.Fatalf("error setting password: %v", err)
+	}
+	if err := ms.SetPassHash(user1.ID, user1.PassHash); err != nil {
+		t.Errorf("error setting pass hash: %v", err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if err := user.Authenticate("new password"); err != nil {
+		t.Errorf("error pass hash was not updated properly")
+	}
+}
+
+func TestMongoSetEmail(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	if err := ms.EnsureIndexes(); err != nil {
+		t.Fatalf("error creating indexes: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	ms.Insert(nuTest2)
+	if err := ms.SetEmail(user1.ID, nuTest2.Email); err != ErrEmailTaken {
+		t.Errorf("expected %v taking another user's email but got %v", ErrEmailTaken, err)
+	}
+	if err := ms.SetEmail(user1.ID, "new@example.com"); err != nil {
+		t.Errorf("error setting email: %v", err)
+	}
+	user, err := ms.GetByEmail("new@example.com")
+	if err != nil {
+		t.Fatalf("error getting user by new email: %v", err)
+	}
+	if user.ID != user1.ID || !user.Verified {
+		t.Errorf("error email was not updated properly")
+	}
+}
+
+func TestMongoSetPhotoURL(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	if err := ms.SetPhotoURL(user1.ID, "/v1/photos/abc-256.jpg"); err != nil {
+		t.Errorf("error setting photo url: %v", err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if user.PhotoURL != "/v1/photos/abc-256.jpg" {
+		t.Errorf("error photo url was not updated properly")
+	}
+}
+
+func TestMongoSetRoles(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	roles := &Roles{Roles: []string{RoleAdmin}, Permissions: []string{PermissionAuditRead}}
+	if err := ms.SetRoles(user1.ID, roles); err != nil {
+		t.Errorf("error setting roles: %v", err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if !user.HasRole(RoleAdmin) || len(user.Permissions) != 1 {
+		t.Errorf("error roles were not updated properly")
+	}
+}
+
+func TestMongoSetVerified(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	if user1.Verified {
+		t.Errorf("error new users should not be verified")
+	}
+	if err := ms.SetVerified(user1.ID); err != nil {
+		t.Errorf("error setting verified: %v", err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if !user.Verified {
+		t.Errorf("error verified was not updated properly")
+	}
+}
+
+func TestMongoSetTwoFactor(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	tf := &TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, RecoveryCodes: []string{"abc"}}
+	if err := ms.SetTwoFactor(user1.ID, tf); err != nil {
+		t.Errorf("error setting two factor: %v", err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if user.TwoFactor.Secret != tf.Secret || !user.TwoFactor.Enabled || len(user.TwoFactor.RecoveryCodes) != 1 {
+		t.Errorf("error two factor was not updated properly")
+	}
+}
+
+func TestMongoUseSecondFactor(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	ms.SetTwoFactor(user1.ID, &TwoFactor{Enabled: true, RecoveryCodes: []string{"abc", "def"}, LastCounter: 10})
+	if err := ms.UseTOTPCounter(user1.ID, 10); err != ErrCodeUsed {
+		t.Errorf("expected %v for a used time step but got %v", ErrCodeUsed, err)
+	}
+	if err := ms.UseTOTPCounter(user1.ID, 11); err != nil {
+		t.Errorf("error recording a new time step: %v", err)
+	}
+	if err := ms.UseRecoveryCode(user1.ID, "abc"); err != nil {
+		t.Errorf("error using a recovery code: %v", err)
+	}
+	if err := ms.UseRecoveryCode(user1.ID, "abc"); err != ErrCodeUsed {
+		t.Errorf("expected %v using a recovery code twice but got %v", ErrCodeUsed, err)
+	}
+	user, err := ms.GetByID(user1.ID)
+	if err != nil {
+		t.Fatalf("error getting user: %v", err)
+	}
+	if user.TwoFactor.LastCounter != 11 || len(user.TwoFactor.RecoveryCodes) != 1 || !user.TwoFactor.Enabled {
+		t.Errorf("error two factor was not updated properly: %+v", user.TwoFactor)
+	}
+}
+
+func TestMongoIdentities(t *testing.T) {
+	ms, err := GetNewMongoStore()
+	if err != nil {
+		t.Fatalf("error connecting to db: %v", err)
+	}
+	if err := ClearCollection(ms); err != nil {
+		t.Errorf("error clearing database before test: %v", err)
+	}
+	user1, _ := ms.Insert(nuTest1)
+	identity := Identity{Issuer: "https://idp.example.com", Subject: "248289761001"}
+	if _, err := ms.GetByIdentity(identity); err != ErrUserNotFound {
+		t.Errorf("expected %v for an unlinked identity but got %v", ErrUserNotFound, err)
+	}
+	if err := ms.AddIdentity(user1.ID, identity); err != nil {
+		t.Errorf("error adding identity: %v", err)
+	}
+	user, err := ms.GetByIdentity(identity)
+	if err != nil {
+		t.Fatalf("error getting user by identity: %v", err)
+	}
+	if user.ID != user1.ID {
+		t.Errorf("error got the wrong user for the identity")
+	}
+}
+
 func TestMongoDelete(t *testing.T) {
 	ms, err := GetNewMongoStore()
 	if err != nil {
@@ -0,0 +1,112 @@
+package users
+
+import (
+	"fmt"
+	"sort"
+)
+
+//roles a user may be given
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+)
+
+//permissions checked by the handlers and, through the X-User
+//header, by the microservices
+const (
+	PermissionRolesManage      = "roles:manage"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionUsersUnlock      = "users:unlock"
+	PermissionAuditRead        = "audit:read"
+	PermissionMessagesModerate = "messages:moderate"
+)
+
+//RolePermissions maps each role to the permissions it grants
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermissionRolesManage,
+		PermissionUsersImpersonate,
+		PermissionUsersUnlock,
+		PermissionAuditRead,
+		PermissionMessagesModerate,
+	},
+	RoleModerator: {
+		PermissionUsersUnlock,
+		PermissionMessagesModerate,
+	},
+}
+
+//Roles represents the roles and the permissions granted to a user
+//directly, on top of those their roles grant
+type Roles struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+//Validate returns an error if any of the roles or permissions are unknown
+func (rs *Roles) Validate() error {
+	known := map[string]bool{}
+	for _, perms := range RolePermissions {
+		for _, perm := range perms {
+			known[perm] = true
+		}
+	}
+	for _, role := range rs.Roles {
+		if _, ok := RolePermissions[role]; !ok {
+			return fmt.Errorf("error unknown role %q", role)
+		}
+	}
+	for _, perm := range rs.Permissions {
+		if !known[perm] {
+			return fmt.Errorf("error unknown permission %q", perm)
+		}
+	}
+	return nil
+}
+
+//HasRole reports whether the user has the role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//Can reports whether the user has the permission, either
+//through one of their roles or granted directly
+func (u *User) Can(permission string) bool {
+	for _, perm := range u.AllPermissions() {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+//AllPermissions returns the sorted permissions of the user's
+//roles and those granted to them directly
+func (u *User) AllPermissions() []string {
+	return PermissionsFor(u.Roles, u.Permissions)
+}
+
+//PermissionsFor returns the sorted permissions the roles grant,
+//together with the permissions granted directly
+func PermissionsFor(roles []string, permissions []string) []string {
+	set := map[string]bool{}
+	for _, role := range roles {
+		for _, perm := range RolePermissions[role] {
+			set[perm] = true
+		}
+	}
+	for _, perm := range permissions {
+		set[perm] = true
+	}
+	all := []string{}
+	for perm := range set {
+		all = append(all, perm)
+	}
+	sort.Strings(all)
+	return all
+}
@@ -0,0 +1,47 @@
+package users
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRolesValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		roles   *Roles
+		wantErr bool
+	}{
+		{"none", &Roles{}, false},
+		{"known", &Roles{Roles: []string{RoleAdmin, RoleModerator}, Permissions: []string{PermissionAuditRead}}, false},
+		{"unknown role", &Roles{Roles: []string{"superuser"}}, true},
+		{"unknown permission", &Roles{Permissions: []string{"everything"}}, true},
+	}
+	for _, c := range cases {
+		if err := c.roles.Validate(); (err != nil) != c.wantErr {
+			t.Errorf("%s: expected error %v but got %v", c.name, c.wantErr, err)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	u := &User{
+		Roles:       []string{RoleModerator},
+		Permissions: []string{PermissionAuditRead, PermissionUsersUnlock},
+	}
+	want := []string{PermissionAuditRead, PermissionMessagesModerate, PermissionUsersUnlock}
+	if got := u.AllPermissions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected permissions %v but got %v", want, got)
+	}
+	if !u.HasRole(RoleModerator) || u.HasRole(RoleAdmin) {
+		t.Errorf("error HasRole gave the wrong answer")
+	}
+	if !u.Can(PermissionAuditRead) || !u.Can(PermissionMessagesModerate) {
+		t.Errorf("expected the user to have the permissions of their role and those granted directly")
+	}
+	if u.Can(PermissionRolesManage) {
+		t.Errorf("expected the user not to have %s", PermissionRolesManage)
+	}
+	if len((&User{}).AllPermissions()) != 0 {
+		t.Errorf("expected a user with no roles to have no permissions")
+	}
+}
@@ -8,6 +8,18 @@ import (
 
 //ErrUserNotFound is returned when the user can't be found
 var ErrUserNotFound = errors.New("user not found")
+
+//ErrUserNameTaken is returned when updating a user to a username
+//another user already has
+var ErrUserNameTaken = errors.New("username already exists")
+
+//ErrEmailTaken is returned when changing a user's email to one
+//another user already has
+var ErrEmailTaken = errors.New("email already exists")
+
+//ErrCodeUsed is returned when recording the use of a second factor
+//code that was already used, e.g. by a request racing this one
+var ErrCodeUsed = errors.New("code was already used")
 var InvalidUser *User = nil
 
 //Store represents a store for Users
@@ -21,6 +33,9 @@ type Store interface {
 	//GetByUserName returns the User with the given Username
 	GetByUserName(username string) (*User, error)
 
+	//GetByIdentity returns the User linked to the given external Identity
+	GetByIdentity(identity Identity) (*User, error)
+
 	//Insert converts the NewUser to a User, inserts
 	//it into the database, and returns it
 	Insert(newUser *NewUser) (*User, error)
@@ -28,6 +43,38 @@ type Store interface {
 	//Update applies UserUpdates to the given user ID
 	Update(userID bson.ObjectId, updates *Updates) error
 
+	//SetPassHash replaces the password hash of the given user ID
+	SetPassHash(userID bson.ObjectId, passHash []byte) error
+
+	//SetEmail replaces the email of the given user ID with one they
+	//confirmed they own, so it is also marked verified. It returns
+	//ErrEmailTaken if another user has the email.
+	SetEmail(userID bson.ObjectId, email string) error
+
+	//SetPhotoURL replaces the photo URL of the given user ID
+	SetPhotoURL(userID bson.ObjectId, photoURL string) error
+
+	//SetRoles replaces the roles and directly granted permissions of the given user ID
+	SetRoles(userID bson.ObjectId, roles *Roles) error
+
+	//SetVerified marks the given user ID as having verified their email
+	SetVerified(userID bson.ObjectId) error
+
+	//SetTwoFactor replaces the second factor of the given user ID
+	SetTwoFactor(userID bson.ObjectId, twoFactor *TwoFactor) error
+
+	//UseTOTPCounter records that the given user ID used the TOTP code of
+	//time step `counter`, returning ErrCodeUsed unless it is newer than
+	//the last one they used
+	UseTOTPCounter(userID bson.ObjectId, counter int64) error
+
+	//UseRecoveryCode removes the recovery code with the stored hash from
+	//the given user ID, returning ErrCodeUsed if they don't have it
+	UseRecoveryCode(userID bson.ObjectId, hash string) error
+
+	//AddIdentity links an external Identity to the given user ID
+	AddIdentity(userID bson.ObjectId, identity Identity) error
+
 	//Delete deletes the user with the given ID
 	Delete(userID bson.ObjectId) error
 
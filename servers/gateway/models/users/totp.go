@@ -0,0 +1,168 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//totpPeriod is the time step of TOTP codes
+const totpPeriod = 30 * time.Second
+
+//totpDigits is the number of digits in a TOTP code
+const totpDigits = 6
+
+//totpSkew is how many time steps a code may be early or late,
+//to allow for clock drift
+const totpSkew = 1
+
+//recoveryCodeCount is how many recovery codes are generated at once
+const recoveryCodeCount = 10
+
+//TwoFactor holds a user's TOTP (RFC 6238) second factor
+type TwoFactor struct {
+	//Secret is the base32 encoded shared secret, set once enrollment begins
+	Secret string
+	//Enabled is set once the user confirms enrollment with a valid code
+	Enabled bool
+	//RecoveryCodes are hex encoded SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string
+	//LastCounter is the time step of the last accepted code,
+	//so a code can't be used twice
+	LastCounter int64
+}
+
+//NewTOTPSecret generates a random base32 encoded TOTP secret
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating secret: %v", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+//ProvisioningURI returns the otpauth:// URI that authenticator apps
+//read, usually from a QR code, to add the account
+func (tf *TwoFactor) ProvisioningURI(issuer string, account string) string {
+	query := url.Values{}
+	query.Set("secret", tf.Secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("period", fmt.Sprintf("%d", int(totpPeriod/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+//Verify reports whether `code` is valid at time `now`, and if so records
+//it in LastCounter so it can't be used again. The caller must save the
+//TwoFactor afterwards.
+func (tf *TwoFactor) Verify(code string, now time.Time) bool {
+	counter, ok := tf.MatchCode(code, now)
+	if ok {
+		tf.LastCounter = counter
+	}
+	return ok
+}
+
+//MatchCode returns the time step of `code` if it is valid at time `now`
+//and newer than LastCounter, without recording it. Recording it with
+//Store.UseTOTPCounter makes sure only one request can use it.
+func (tf *TwoFactor) MatchCode(code string, now time.Time) (int64, bool) {
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(tf.Secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	counter := now.Unix() / int64(totpPeriod/time.Second)
+	for c := counter - totpSkew; c <= counter+totpSkew; c++ {
+		if c <= tf.LastCounter {
+			continue
+		}
+		expected := hotp(secret, c, totpDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+//hotp returns the RFC 4226 HOTP code for the counter
+func hotp(secret []byte, counter int64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+//NewRecoveryCodes replaces the recovery codes with new ones, keeping
+//only their hashes, and returns the codes to show to the user once
+func (tf *TwoFactor) NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+		encoded := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	tf.RecoveryCodes = hashes
+	return codes, nil
+}
+
+//UseRecoveryCode reports whether `code` is an unused recovery code,
+//and if so removes it. The caller must save the TwoFactor afterwards.
+func (tf *TwoFactor) UseRecoveryCode(code string) bool {
+	hash, ok := tf.MatchRecoveryCode(code)
+	if !ok {
+		return false
+	}
+	remaining := make([]string, 0, len(tf.RecoveryCodes))
+	for _, stored := range tf.RecoveryCodes {
+		if stored != hash {
+			remaining = append(remaining, stored)
+		}
+	}
+	tf.RecoveryCodes = remaining
+	return true
+}
+
+//MatchRecoveryCode returns the stored hash of `code` if it is an unused
+//recovery code, without removing it. Removing it with
+//Store.UseRecoveryCode makes sure only one request can use it.
+func (tf *TwoFactor) MatchRecoveryCode(code string) (string, bool) {
+	hash := hashRecoveryCode(code)
+	for _, stored := range tf.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			return stored, true
+		}
+	}
+	return "", false
+}
+
+//hashRecoveryCode hashes a recovery code, ignoring case and dashes.
+//Recovery codes are random, so a fast hash is enough.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
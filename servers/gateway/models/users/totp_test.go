@@ -0,0 +1,95 @@
+package users
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	//test vectors from RFC 6238 appendix B, for SHA1
+	secret := []byte("12345678901234567890")
+	cases := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, c := range cases {
+		if code := hotp(secret, c.time/30, 8); code != c.code {
+			t.Errorf("incorrect code at time %d: expected %s but got %s", c.time, c.code, code)
+		}
+	}
+}
+
+func TestTwoFactorVerify(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating secret: %v", err)
+	}
+	tf := &TwoFactor{Secret: secret}
+	rawSecret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	now := time.Unix(1500000000, 0)
+	code := hotp(rawSecret, now.Unix()/30, totpDigits)
+
+	if code != "000000" && tf.Verify("000000", now) {
+		t.Error("incorrect code was accepted")
+	}
+	if !tf.Verify(code, now.Add(-20*time.Second)) {
+		t.Error("code from the next time step was not accepted")
+	}
+	if tf.Verify(code, now) {
+		t.Error("code was accepted twice")
+	}
+	later := hotp(rawSecret, now.Unix()/30+5, totpDigits)
+	if tf.Verify(later, now) {
+		t.Error("code from too far in the future was accepted")
+	}
+}
+
+func TestTwoFactorProvisioningURI(t *testing.T) {
+	tf := &TwoFactor{Secret: "JBSWY3DPEHPK3PXP"}
+	uri := tf.ProvisioningURI("Gateway", "kyle@gmail.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/Gateway:kyle@gmail.com?") {
+		t.Errorf("incorrect URI prefix: %s", uri)
+	}
+	for _, param := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=Gateway", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("URI %s does not contain %s", uri, param)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	tf := &TwoFactor{}
+	codes, err := tf.NewRecoveryCodes()
+	if err != nil {
+		t.Fatalf("error generating recovery codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(tf.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes but got %d", recoveryCodeCount, len(codes))
+	}
+	for i, code := range codes {
+		if tf.RecoveryCodes[i] == code {
+			t.Error("recovery codes must be stored hashed")
+		}
+	}
+	if tf.UseRecoveryCode("nope-nope") {
+		t.Error("unknown recovery code was accepted")
+	}
+	if !tf.UseRecoveryCode(strings.ToUpper(codes[3])) {
+		t.Error("recovery code was not accepted")
+	}
+	if tf.UseRecoveryCode(codes[3]) {
+		t.Error("recovery code was accepted twice")
+	}
+	if len(tf.RecoveryCodes) != recoveryCodeCount-1 {
+		t.Errorf("expected %d recovery codes left but got %d", recoveryCodeCount-1, len(tf.RecoveryCodes))
+	}
+}
@@ -2,10 +2,12 @@ package users
 
 import (
 	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/mail"
 	"strings"
+	"sync"
 
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/mgo.v2/bson"
@@ -24,12 +26,46 @@ type User struct {
 	FirstName string        `json:"firstName"`
 	LastName  string        `json:"lastName"`
 	PhotoURL  string        `json:"photoURL"`
+	//Verified is set once the user confirms they own Email
+	Verified bool `json:"verified"`
+	//TwoFactor is the user's TOTP second factor, if they enrolled one
+	TwoFactor TwoFactor `json:"-"`
+	//Identities are the external accounts the user can sign in with
+	Identities []Identity `json:"-"`
+	//Roles are the user's roles, and Permissions those granted
+	//to them directly on top of what their roles grant
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+//Identity is an account at an external OpenID Provider,
+//identified by the provider's issuer and the subject there
+type Identity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
 }
 
 //Credentials represents user sign-in credentials
 type Credentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	//RememberMe asks for a session that lasts longer when unused
+	RememberMe bool `json:"rememberMe"`
+}
+
+//PasswordReset represents a request to set a new password
+//using a reset code that was emailed to the user
+type PasswordReset struct {
+	ResetCode    string `json:"resetCode"`
+	Password     string `json:"password"`
+	PasswordConf string `json:"passwordConf"`
+}
+
+//PasswordChange represents a signed in user choosing a new password
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	Password        string `json:"password"`
+	PasswordConf    string `json:"passwordConf"`
 }
 
 //NewUser represents a new user signing up for an account
@@ -42,10 +78,20 @@ type NewUser struct {
 	LastName     string `json:"lastName"`
 }
 
-//Updates represents allowed updates to a user profile
+//Updates represents allowed updates to a user profile.
+//Fields left empty are not changed.
 type Updates struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
+	UserName  string `json:"userName"`
+}
+
+//EmailChange represents a request to change a user's email, and
+//to confirm it with the codes sent to the current and new addresses
+type EmailChange struct {
+	Email            string `json:"email"`
+	CurrentEmailCode string `json:"currentEmailCode"`
+	NewEmailCode     string `json:"newEmailCode"`
 }
 
 const ErrPassShort = "error password is too short"
@@ -55,20 +101,37 @@ const ErrUsernameEmpty = "error username should not be empty"
 //Validate validates the new user and returns an error if
 //any of the validation rules fail, or nil if its valid
 func (nu *NewUser) Validate() error {
-	if _, err := mail.ParseAddress(nu.Email); err != nil {
+	if err := ValidateEmail(nu.Email); err != nil {
+		return err
+	}
+	if err := ValidatePassword(nu.Password, nu.PasswordConf); err != nil {
+		return err
+	}
+	if len(nu.UserName) < 1 {
+		return fmt.Errorf(ErrUsernameEmpty)
+	}
+
+	return nil
+}
+
+//ValidateEmail returns an error if the email isn't a valid address
+func ValidateEmail(email string) error {
+	if _, err := mail.ParseAddress(email); err != nil {
 		return fmt.Errorf("error parsing email: %v", err)
 	}
+	return nil
+}
+
+//ValidatePassword returns an error if the password is too
+//short or doesn't match its confirmation
+func ValidatePassword(password string, passwordConf string) error {
 	//- Password must be at least 6 characters
-	if len(nu.Password) < 6 {
+	if len(password) < 6 {
 		return fmt.Errorf(ErrPassShort)
 	}
-	if strings.Compare(nu.Password, nu.PasswordConf) != 0 {
+	if strings.Compare(password, passwordConf) != 0 {
 		return fmt.Errorf(ErrPassMatch)
 	}
-	if len(nu.UserName) < 1 {
-		return fmt.Errorf(ErrUsernameEmpty)
-	}
-
 	return nil
 }
 
@@ -81,11 +144,7 @@ func (nu *NewUser) ToUser() (*User, error) {
 		LastName:  nu.LastName,
 		UserName:  nu.UserName,
 	}
-	md5Hasher := md5.New()
-	email := strings.ToLower(strings.Trim(nu.Email, " "))
-	io.WriteString(md5Hasher, email)
-	gravatarURL := gravatarBasePhotoURL + string(md5Hasher.Sum(nil))
-	user.PhotoURL = gravatarURL
+	user.PhotoURL = GravatarURL(nu.Email)
 	user.ID = bson.NewObjectId()
 	err := user.SetPassword(nu.Password)
 	if err != nil {
@@ -94,6 +153,14 @@ func (nu *NewUser) ToUser() (*User, error) {
 	return user, nil
 }
 
+//GravatarURL returns the URL of the Gravatar photo for the email,
+//which is the hex md5 hash of the trimmed, lowercased address
+func GravatarURL(email string) string {
+	md5Hasher := md5.New()
+	io.WriteString(md5Hasher, strings.ToLower(strings.Trim(email, " ")))
+	return gravatarBasePhotoURL + hex.EncodeToString(md5Hasher.Sum(nil))
+}
+
 //FullName returns the user's full name, in the form:
 // "<FirstName> <LastName>"
 //If either first or last name is an empty string, no
@@ -128,16 +195,52 @@ func (u *User) Authenticate(password string) error {
 	return nil
 }
 
+//unknownUserHash is compared against when there's no user to
+//authenticate, generated once it's first needed
+var unknownUserHash []byte
+var unknownUserHashOnce sync.Once
+
+//AuthenticateUnknown takes as long as Authenticate but always fails.
+//Call it when no user has the email given at sign-in, so the response
+//time doesn't reveal whether the email exists.
+func AuthenticateUnknown(password string) error {
+	unknownUserHashOnce.Do(func() {
+		unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcryptCost)
+	})
+	bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+	return fmt.Errorf("error password incorrect")
+}
+
+//changes returns the fields the updates change, keyed by their name
+//in the database, so only those fields are written
+func (updates *Updates) changes() bson.M {
+	changes := bson.M{}
+	if len(updates.FirstName) > 0 {
+		changes["firstname"] = updates.FirstName
+	}
+	if len(updates.LastName) > 0 {
+		changes["lastname"] = updates.LastName
+	}
+	if len(updates.UserName) > 0 {
+		changes["username"] = updates.UserName
+	}
+	return changes
+}
+
 //ApplyUpdates applies the updates to the user. An error
 //is returned if the updates are invalid
 func (u *User) ApplyUpdates(updates *Updates) error {
-	if len(updates.FirstName) < 1 {
-		return fmt.Errorf("error first name must not be empty")
+	if len(updates.FirstName) < 1 && len(updates.LastName) < 1 && len(updates.UserName) < 1 {
+		return fmt.Errorf("error no updates given")
+	}
+	if len(updates.FirstName) > 0 {
+		u.FirstName = updates.FirstName
+	}
+	if len(updates.LastName) > 0 {
+		u.LastName = updates.LastName
 	}
-	if len(updates.LastName) < 1 {
-		return fmt.Errorf("error last name must not be empty")
+	if len(updates.UserName) > 0 {
+		u.UserName = updates.UserName
 	}
-	u.FirstName = updates.FirstName
-	u.LastName = updates.LastName
 	return nil
 }
@@ -2,6 +2,7 @@ package users
 
 import (
 	"crypto/md5"
+	"encoding/hex"
 	"io"
 	"strings"
 	"testing"
@@ -118,7 +119,7 @@ func TestToUser(t *testing.T) {
 	transformedEmail := strings.ToLower(strings.Trim(nu.Email, " "))
 	md5Hasher := md5.New()
 	io.WriteString(md5Hasher, transformedEmail)
-	gravatarURL := "https://www.gravatar.com/avatar/" + string(md5Hasher.Sum(nil))
+	gravatarURL := "https://www.gravatar.com/avatar/" + hex.EncodeToString(md5Hasher.Sum(nil))
 	if user.PhotoURL != gravatarURL {
 		t.Errorf("error when calling ToUser on NewUser instance. Gravatar photo is not being set correctly. Make sure you always force lower case.")
 	}
@@ -185,35 +186,53 @@ func TestAutenticate(t *testing.T) {
 	}
 }
 
+func TestAuthenticateUnknown(t *testing.T) {
+	if err := AuthenticateUnknown("unknown user"); err == nil {
+		t.Errorf("error authenticating an unknown user succeeded")
+	}
+}
+
 func TestApplyUpdates(t *testing.T) {
 	cases := []struct {
 		CaseName      string
 		FirstName     string
 		LastName      string
+		UserName      string
 		expectedError bool
 	}{
 		{
 			"valid update",
 			"Kyle",
 			"Williams-Smith",
+			"",
 			false,
 		},
 		{
-			"invalid firstname",
+			"only lastname",
 			"",
 			"Williams-Smith",
-			true,
+			"",
+			false,
 		},
 		{
-			"invalid lastname",
+			"only firstname",
 			"Kyle",
 			"",
-			true,
+			"",
+			false,
+		},
+		{
+			"only username",
+			"",
+			"",
+			"kylews",
+			false,
 		},
 		{
 			"no input",
 			"",
 			"",
+			"",
 			true,
 		},
 	}
@@ -221,10 +240,12 @@ func TestApplyUpdates(t *testing.T) {
 		user := &User{
 			FirstName: "Kyleee",
 			LastName:  "WS",
+			UserName:  "kyle",
 		}
 		up := &Updates{
 			FirstName: c.FirstName,
 			LastName:  c.LastName,
+			UserName:  c.UserName,
 		}
 		err := user.ApplyUpdates(up)
 		if err != nil && !c.expectedError {
@@ -233,6 +254,13 @@ func TestApplyUpdates(t *testing.T) {
 		if err == nil && c.expectedError {
 			t.Errorf("no error when one was expected. Case: %s", c.CaseName)
 		}
+		// fields left empty are not changed
+		if len(c.FirstName) == 0 && user.FirstName != "Kyleee" {
+			t.Errorf("error first name changed when it wasn't given. Case: %s", c.CaseName)
+		}
+		if len(c.UserName) > 0 && user.UserName != c.UserName {
+			t.Errorf("error username was not updated. Case: %s", c.CaseName)
+		}
 	}
 
 }
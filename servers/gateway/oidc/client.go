@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+//ErrInvalidState is returned from Exchange when the state is unknown,
+//expired or was already used
+var ErrInvalidState = errors.New("invalid or expired login state")
+
+//Config configures a Client for one OpenID Provider
+type Config struct {
+	//Issuer is the provider's issuer URL; its discovery document is
+	//at Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	//RedirectURL is where the provider sends the user back to with a code
+	RedirectURL string
+	//Scopes requested in addition to "openid"; defaults to email and profile
+	Scopes []string
+	//LoginTimeout is how long the user has to log in at the provider
+	LoginTimeout time.Duration
+}
+
+//Identity is the user the provider authenticated, from the ID token
+type Identity struct {
+	Issuer            string `json:"iss"`
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+//discovery is the part of the provider's discovery document the Client uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//loginState is saved between sending the user to the
+//provider and them coming back with a code
+type loginState struct {
+	Nonce    string
+	Verifier string
+	Expires  time.Time
+}
+
+//Client signs users in with an OpenID Provider using the
+//authorization code flow with PKCE (RFC 7636)
+type Client struct {
+	config     Config
+	discovery  discovery
+	httpClient *http.Client
+	//store keeps loginStates, keyed by the signed state parameter
+	store      sessions.Store
+	signingKey string
+
+	//keys are the provider's signing keys, by key ID
+	keys   map[string]*rsa.PublicKey
+	keysMx sync.Mutex
+}
+
+//NewClient fetches the provider's discovery document and returns a
+//Client for it. The `store` only needs to keep state for the
+//LoginTimeout. State parameters are signed with a key derived from
+//`signingKey`.
+func NewClient(config Config, store sessions.Store, signingKey string) (*Client, error) {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"email", "profile"}
+	}
+	if config.LoginTimeout <= 0 {
+		config.LoginTimeout = 10 * time.Minute
+	}
+	c := &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		store:      store,
+		signingKey: signingKey + ":oidc",
+		keys:       make(map[string]*rsa.PublicKey),
+	}
+	issuer := strings.TrimSuffix(config.Issuer, "/")
+	if err := c.getJSON(issuer+"/.well-known/openid-configuration", &c.discovery); err != nil {
+		return nil, fmt.Errorf("error fetching discovery document: %v", err)
+	}
+	if c.discovery.Issuer != config.Issuer {
+		return nil, fmt.Errorf("error discovery document is for issuer %q, not %q", c.discovery.Issuer, config.Issuer)
+	}
+	return c, nil
+}
+
+//AuthCodeURL begins a login and returns the provider URL to redirect
+//the user to, and the state the provider will send back with them
+func (c *Client) AuthCodeURL() (string, string, error) {
+	state, err := sessions.NewSessionID(c.signingKey)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	ls := &loginState{
+		Nonce:    nonce,
+		Verifier: verifier,
+		Expires:  time.Now().Add(c.config.LoginTimeout),
+	}
+	if err := c.store.Save(state, ls); err != nil {
+		return "", "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.config.ClientID)
+	query.Set("redirect_uri", c.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, c.config.Scopes...), " "))
+	query.Set("state", state.String())
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(c.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.discovery.AuthorizationEndpoint + sep + query.Encode(), state.String(), nil
+}
+
+//tokenResponse is the provider's response from the token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+//Exchange completes a login: it checks the `state` the provider sent the
+//user back with, redeems the `code` for an ID token, verifies the token
+//and returns the Identity in it. Each state can only be used once.
+func (c *Client) Exchange(state string, code string) (*Identity, error) {
+	stateID, err := sessions.ValidateID(state, c.signingKey)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	ls := &loginState{}
+	if err := c.store.Get(stateID, ls); err != nil {
+		return nil, ErrInvalidState
+	}
+	c.store.Delete(stateID)
+	if time.Now().After(ls.Expires) {
+		return nil, ErrInvalidState
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("client_id", c.config.ClientID)
+	form.Set("code_verifier", ls.Verifier)
+	req, err := http.NewRequest("POST", c.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(c.config.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error redeeming code: %v", err)
+	}
+	defer resp.Body.Close()
+	tr := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(tr); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || len(tr.Error) > 0 {
+		return nil, fmt.Errorf("error redeeming code: %s %s", tr.Error, tr.ErrorDescription)
+	}
+	return c.verifyIDToken(tr.IDToken, ls.Nonce)
+}
+
+//getJSON gets `url` and decodes the JSON response into `v`
+func (c *Client) getJSON(url string, v interface{}) error {
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//randomString returns 32 random bytes, base64url encoded
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("error generating random string: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+//codeChallenge returns the S256 PKCE challenge for the verifier
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,263 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/info344-a17/challenges-KyleIWS/servers/gateway/sessions"
+)
+
+const testClientID = "gateway"
+const testRedirectURL = "https://gateway.example.com/v1/sessions/oidc/callback"
+
+//authorization is what the stub IdP remembers about a code it issued
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+//stubIdP is a minimal OpenID Provider for testing
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	//claims, if set, may change the claims of issued ID tokens
+	claims func(claims map[string]interface{})
+	//signingKey, if set, signs ID tokens instead of key
+	signingKey *rsa.PrivateKey
+
+	codes   map[string]*authorization
+	codesMx sync.Mutex
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	idp := &stubIdP{key: key, codes: make(map[string]*authorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+//authorize signs in the user straight away and sends them back with a code
+func (idp *stubIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	code, _ := randomString()
+	idp.codesMx.Lock()
+	idp.codes[code] = &authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	idp.codesMx.Unlock()
+	redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+//token redeems a code for an ID token if the PKCE verifier matches
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.codesMx.Lock()
+	auth := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	idp.codesMx.Unlock()
+	hash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if auth == nil || base64.RawURLEncoding.EncodeToString(hash[:]) != auth.challenge ||
+		r.FormValue("redirect_uri") != testRedirectURL {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "248289761001",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          auth.nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+	}
+	if idp.claims != nil {
+		idp.claims(claims)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(claims)})
+}
+
+//sign returns an RS256 JWT of the claims
+func (idp *stubIdP) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	key := idp.key
+	if idp.signingKey != nil {
+		key = idp.signingKey
+	}
+	hash := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+//login follows the client's auth URL to the stub IdP
+//and returns the state and code it redirects back with
+func login(t *testing.T, c *Client) (string, string) {
+	authURL, state, err := c.AuthCodeURL()
+	if err != nil {
+		t.Fatalf("error getting auth code URL: %v", err)
+	}
+	noRedirects := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := noRedirects.Get(authURL)
+	if err != nil {
+		t.Fatalf("error visiting auth code URL: %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || err != nil {
+		t.Fatalf("expected a redirect back from the IdP but got status %d", resp.StatusCode)
+	}
+	if location.Query().Get("state") != state {
+		t.Errorf("expected the IdP to send back state %s but got %s", state, location.Query().Get("state"))
+	}
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func newTestClient(t *testing.T, idp *stubIdP) *Client {
+	config := Config{
+		Issuer:      idp.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+	}
+	c, err := NewClient(config, sessions.NewMemStore(time.Hour, time.Minute), "test key")
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	return c
+}
+
+func TestExchange(t *testing.T) {
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+	c := newTestClient(t, idp)
+
+	state, code := login(t, c)
+	identity, err := c.Exchange(state, code)
+	if err != nil {
+		t.Fatalf("unexpected error exchanging code: %v", err)
+	}
+	if identity.Issuer != idp.server.URL || identity.Subject != "248289761001" ||
+		identity.Email != "jane@example.com" || !identity.EmailVerified || identity.GivenName != "Jane" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	//states can only be used once
+	if _, err := c.Exchange(state, code); err != ErrInvalidState {
+		t.Errorf("expected %v reusing a state but got %v", ErrInvalidState, err)
+	}
+	//and must be signed by the client
+	forged, _ := sessions.NewSessionID("other key")
+	if _, err := c.Exchange(forged.String(), code); err != ErrInvalidState {
+		t.Errorf("expected %v for a forged state but got %v", ErrInvalidState, err)
+	}
+}
+
+func TestExchangePKCE(t *testing.T) {
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+	c := newTestClient(t, idp)
+
+	//a code intercepted from another login can't be
+	//redeemed without that login's verifier
+	state, _ := login(t, c)
+	_, stolenCode := login(t, c)
+	if _, err := c.Exchange(state, stolenCode); err == nil {
+		t.Error("expected an error redeeming a code with the wrong verifier")
+	}
+}
+
+func TestExchangeInvalidIDToken(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cases := []struct {
+		name       string
+		claims     func(claims map[string]interface{})
+		signingKey *rsa.PrivateKey
+	}{
+		{"Wrong Issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, nil},
+		{"Wrong Audience", func(c map[string]interface{}) { c["aud"] = "someone-else" }, nil},
+		{"Wrong Nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }, nil},
+		{"Expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, nil},
+		{"Multiple Audiences Without AZP", func(c map[string]interface{}) { c["aud"] = []string{testClientID, "other"} }, nil},
+		{"Wrong Signing Key", nil, otherKey},
+	}
+	for _, c := range cases {
+		idp := newStubIdP(t)
+		idp.claims = c.claims
+		idp.signingKey = c.signingKey
+		client := newTestClient(t, idp)
+		state, code := login(t, client)
+		_, err := client.Exchange(state, code)
+		if err == nil || !strings.HasPrefix(err.Error(), ErrInvalidIDToken.Error()) {
+			t.Errorf("case %s: expected %v but got %v", c.name, ErrInvalidIDToken, err)
+		}
+		idp.server.Close()
+	}
+
+	//a list of audiences is fine when we're the authorized party
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+	idp.claims = func(c map[string]interface{}) {
+		c["aud"] = []string{testClientID, "other"}
+		c["azp"] = testClientID
+	}
+	client := newTestClient(t, idp)
+	state, code := login(t, client)
+	if _, err := client.Exchange(state, code); err != nil {
+		t.Errorf("unexpected error with multiple audiences: %v", err)
+	}
+}
+
+func TestNewClientWrongIssuer(t *testing.T) {
+	idp := newStubIdP(t)
+	defer idp.server.Close()
+	config := Config{Issuer: idp.server.URL + "/", ClientID: testClientID, RedirectURL: testRedirectURL}
+	if _, err := NewClient(config, sessions.NewMemStore(time.Hour, time.Minute), "test key"); err == nil {
+		t.Error("expected an error when the discovery document is for another issuer")
+	}
+}
@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//ErrInvalidIDToken is returned from Exchange when the provider's ID token
+//is malformed, not signed by the provider, or not meant for this client
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+//clockSkew is how far the provider's clock may be off from ours
+const clockSkew = 2 * time.Minute
+
+//idTokenHeader is the JOSE header of an ID token
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+//idTokenClaims are the claims checked in an ID token, in addition
+//to the Identity
+type idTokenClaims struct {
+	Identity
+	Audience audience `json:"aud"`
+	AZP      string   `json:"azp"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+}
+
+//audience is the aud claim, which may be a string or an array of strings
+type audience []string
+
+//UnmarshalJSON decodes either form of the aud claim
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = audience(multiple)
+	return nil
+}
+
+//contains reports whether `clientID` is one of the audiences
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+//jwks is the provider's JSON Web Key Set
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+//verifyIDToken checks the signature and claims of an RS256 ID token
+//and returns the Identity in it
+func (c *Client) verifyIDToken(token string, nonce string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+	header := &idTokenHeader{}
+	if err := decodeSegment(parts[0], header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%v: unsupported algorithm %q", ErrInvalidIDToken, header.Alg)
+	}
+	key, err := c.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := &idTokenClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != c.discovery.Issuer:
+		return nil, fmt.Errorf("%v: wrong issuer", ErrInvalidIDToken)
+	case !claims.Audience.contains(c.config.ClientID):
+		return nil, fmt.Errorf("%v: wrong audience", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AZP != c.config.ClientID:
+		return nil, fmt.Errorf("%v: wrong authorized party", ErrInvalidIDToken)
+	case now.Add(-clockSkew).After(time.Unix(claims.Expiry, 0)):
+		return nil, fmt.Errorf("%v: expired", ErrInvalidIDToken)
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%v: issued in the future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%v: wrong nonce", ErrInvalidIDToken)
+	case len(claims.Subject) == 0:
+		return nil, fmt.Errorf("%v: no subject", ErrInvalidIDToken)
+	}
+	return &claims.Identity, nil
+}
+
+//key returns the provider's signing key with the key ID `kid`,
+//fetching the provider's keys again if it's unknown, since
+//providers rotate their keys
+func (c *Client) key(kid string) (*rsa.PublicKey, error) {
+	c.keysMx.Lock()
+	defer c.keysMx.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	set := &jwks{}
+	if err := c.getJSON(c.discovery.JWKSURI, set); err != nil {
+		return nil, fmt.Errorf("error fetching provider keys: %v", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (len(k.Use) > 0 && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	c.keys = keys
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%v: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+//decodeSegment decodes a base64url JSON segment of a token into `v`
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,112 @@
+package photos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" //register the gif decoder
+	"image/jpeg"
+	_ "image/png" //register the png decoder
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+//Sizes are the widths, in pixels, of the square photos made from an upload
+var Sizes = []int{64, 128, 256}
+
+//MaxBytes is the largest upload accepted
+const MaxBytes = 5 << 20
+
+//MaxPixels is the most pixels an upload may decode to, so a small
+//file can't expand to an image too big to hold in memory
+const MaxPixels = 25000000
+
+//jpegQuality is the quality resized photos are encoded at
+const jpegQuality = 85
+
+//ErrUnsupportedType is returned for uploads that aren't a JPEG, PNG or GIF
+var ErrUnsupportedType = errors.New("photo must be a JPEG, PNG or GIF image")
+
+//ErrTooLarge is returned for uploads with too many bytes or pixels
+var ErrTooLarge = errors.New("photo is too large")
+
+//contentTypes are the types of image accepted, as sniffed from the data
+var contentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+//Photo is an uploaded image resized to each of Sizes
+type Photo struct {
+	//ID is derived from the uploaded data, so the same upload
+	//always gets the same ID and a new upload a new one
+	ID string
+	//Sizes holds the JPEG encoded photo for each of Sizes
+	Sizes map[int][]byte
+}
+
+//Name returns the file name of the photo with the ID at the size
+func Name(id string, size int) string {
+	return fmt.Sprintf("%s-%d.jpg", id, size)
+}
+
+//Process checks that the data is a supported image of an acceptable size,
+//crops it to a square in the center, and resizes it to each of Sizes
+func Process(data []byte) (*Photo, error) {
+	if len(data) > MaxBytes {
+		return nil, ErrTooLarge
+	}
+	if !contentTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedType
+	}
+	// check the dimensions before decoding the whole image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width > MaxPixels/config.Height {
+		return nil, ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding photo: %v", err)
+	}
+	square := centerSquare(src.Bounds())
+	sum := sha256.Sum256(data)
+	photo := &Photo{
+		ID:    hex.EncodeToString(sum[:16]),
+		Sizes: map[int][]byte{},
+	}
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		// JPEG has no transparency, so transparent areas become white
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Over, nil)
+		buf := &bytes.Buffer{}
+		if err := jpeg.Encode(buf, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, fmt.Errorf("error encoding photo: %v", err)
+		}
+		photo.Sizes[size] = buf.Bytes()
+	}
+	return photo, nil
+}
+
+//centerSquare returns the largest square in the center of the bounds
+func centerSquare(b image.Rectangle) image.Rectangle {
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
@@ -0,0 +1,101 @@
+package photos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("error encoding png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	gifBuf := &bytes.Buffer{}
+	if err := gif.Encode(gifBuf, image.NewPaletted(image.Rect(0, 0, 40, 30), []color.Color{color.Black, color.White}), nil); err != nil {
+		t.Fatalf("error encoding gif: %v", err)
+	}
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"wide png", encodePNG(t, 300, 200)},
+		{"tall png", encodePNG(t, 50, 400)},
+		{"small gif", gifBuf.Bytes()},
+	}
+	for _, c := range cases {
+		photo, err := Process(c.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if len(photo.ID) != 32 {
+			t.Errorf("%s: expected a 32 character ID but got %q", c.name, photo.ID)
+		}
+		for _, size := range Sizes {
+			img, err := jpeg.Decode(bytes.NewReader(photo.Sizes[size]))
+			if err != nil {
+				t.Errorf("%s: size %d is not a jpeg: %v", c.name, size, err)
+				continue
+			}
+			if img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+				t.Errorf("%s: expected %dx%d but got %v", c.name, size, size, img.Bounds())
+			}
+		}
+	}
+
+	data := encodePNG(t, 20, 20)
+	first, _ := Process(data)
+	second, _ := Process(data)
+	other, _ := Process(encodePNG(t, 21, 20))
+	if first.ID != second.ID {
+		t.Errorf("expected the same upload to get the same ID")
+	}
+	if first.ID == other.ID {
+		t.Errorf("expected a different upload to get a different ID")
+	}
+	if name := Name(first.ID, 64); name != first.ID+"-64.jpg" {
+		t.Errorf("unexpected name %q", name)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	// a valid png header claiming far more pixels than MaxPixels
+	huge := encodePNG(t, 1, 1)
+	binary.BigEndian.PutUint32(huge[16:], 10000) // IHDR width
+	binary.BigEndian.PutUint32(huge[20:], 10000) // IHDR height
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"text", []byte("this is not an image"), ErrUnsupportedType},
+		{"empty", []byte{}, ErrUnsupportedType},
+		{"truncated png", encodePNG(t, 10, 10)[:20], ErrUnsupportedType},
+		{"too many bytes", append(encodePNG(t, 1, 1), make([]byte, MaxBytes)...), ErrTooLarge},
+		{"too many pixels", huge, ErrTooLarge},
+	}
+	for _, c := range cases {
+		if _, err := Process(c.data); err != c.err {
+			t.Errorf("%s: expected %v but got %v", c.name, c.err, err)
+		}
+	}
+}
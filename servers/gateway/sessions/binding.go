@@ -0,0 +1,145 @@
+package sessions
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//ErrClientMismatch is returned from GetState when the request comes from
+//a different client than the session was begun from and the
+//DefaultBinding denies such requests
+var ErrClientMismatch = errors.New("request does not match the client the session was begun from")
+
+//ErrStepUpRequired is returned from GetState, along with the SessionID,
+//when the request comes from a different client than the session was
+//begun from and the user must re-authenticate to keep using it
+var ErrStepUpRequired = errors.New("re-authentication required: request comes from a different client")
+
+//Client is implemented by session states that record the client
+//the session was begun from
+type Client interface {
+	SessionClient() (ip string, userAgent string)
+}
+
+//BindingAction decides what happens to a request from a different
+//client than its session was begun from
+type BindingAction int
+
+const (
+	//Deny refuses the request with ErrClientMismatch
+	Deny BindingAction = iota
+	//RequireStepUp refuses the request with ErrStepUpRequired until
+	//the user re-authenticates and the session is bound to the new client
+	RequireStepUp
+)
+
+//BindingPolicy binds sessions to characteristics of the client
+//they were begun from
+type BindingPolicy struct {
+	//IPv4Prefix is how many leading bits of an IPv4 address must match,
+	//e.g. 24 for the same /24 subnet; 0 doesn't check IPv4 addresses
+	IPv4Prefix int
+	//IPv6Prefix is the same for IPv6 addresses, e.g. 64
+	IPv6Prefix int
+	//UserAgentFamily requires the same browser family, e.g. Firefox,
+	//so browser updates don't end the session but a stolen SessionID
+	//replayed with curl does
+	UserAgentFamily bool
+	Action          BindingAction
+}
+
+//DefaultBinding is checked by GetState for session states that are a
+//Client. The zero value binds nothing. Set it once during startup.
+var DefaultBinding BindingPolicy
+
+//check returns an error if the request `r` doesn't come from the client
+//recorded in `sessionState`, according to the policy
+func (bp BindingPolicy) check(r *http.Request, sessionState interface{}) error {
+	client, ok := sessionState.(Client)
+	if !ok {
+		return nil
+	}
+	ip, userAgent := client.SessionClient()
+	if bp.matchIP(ip, ClientIP(r)) && bp.matchUserAgent(userAgent, r.UserAgent()) {
+		return nil
+	}
+	if bp.Action == RequireStepUp {
+		return ErrStepUpRequired
+	}
+	return ErrClientMismatch
+}
+
+//matchIP reports whether `ip` and `otherIP` are in the same subnet
+func (bp BindingPolicy) matchIP(ip string, otherIP string) bool {
+	if bp.IPv4Prefix <= 0 && bp.IPv6Prefix <= 0 {
+		return true
+	}
+	parsed, otherParsed := net.ParseIP(ip), net.ParseIP(otherIP)
+	if parsed == nil || otherParsed == nil {
+		return ip == otherIP
+	}
+	if v4, otherV4 := parsed.To4(), otherParsed.To4(); v4 != nil || otherV4 != nil {
+		if v4 == nil || otherV4 == nil {
+			return false
+		}
+		if bp.IPv4Prefix <= 0 {
+			return true
+		}
+		mask := net.CIDRMask(bp.IPv4Prefix, 8*net.IPv4len)
+		return v4.Mask(mask).Equal(otherV4.Mask(mask))
+	}
+	if bp.IPv6Prefix <= 0 {
+		return true
+	}
+	mask := net.CIDRMask(bp.IPv6Prefix, 8*net.IPv6len)
+	return parsed.Mask(mask).Equal(otherParsed.Mask(mask))
+}
+
+//matchUserAgent reports whether both user agents are the same family
+func (bp BindingPolicy) matchUserAgent(userAgent string, otherUserAgent string) bool {
+	if !bp.UserAgentFamily {
+		return true
+	}
+	return UserAgentFamily(userAgent) == UserAgentFamily(otherUserAgent)
+}
+
+//userAgentFamilies are checked in order, since most browsers
+//also claim to be the ones they're based on
+var userAgentFamilies = []struct {
+	token  string
+	family string
+}{
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"CriOS/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"MSIE ", "Internet Explorer"},
+	{"Trident/", "Internet Explorer"},
+}
+
+//UserAgentFamily returns the browser family of a User-Agent header,
+//e.g. "Firefox", or the name of the first product for other clients,
+//e.g. "curl"
+func UserAgentFamily(userAgent string) string {
+	for _, f := range userAgentFamilies {
+		if strings.Contains(userAgent, f.token) {
+			return f.family
+		}
+	}
+	product := strings.SplitN(userAgent, " ", 2)[0]
+	return strings.SplitN(product, "/", 2)[0]
+}
+
+//ClientIP returns the IP address the request came from
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
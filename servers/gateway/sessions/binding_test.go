@@ -0,0 +1,129 @@
+package sessions
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type clientState struct {
+	IP        string
+	UserAgent string
+}
+
+func (cs *clientState) SessionClient() (string, string) {
+	return cs.IP, cs.UserAgent
+}
+
+const (
+	uaFirefox   = "Mozilla/5.0 (X11; Linux x86_64; rv:57.0) Gecko/20100101 Firefox/57.0"
+	uaFirefox58 = "Mozilla/5.0 (X11; Linux x86_64; rv:58.0) Gecko/20100101 Firefox/58.0"
+	uaChrome    = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/62.0.3202.94 Safari/537.36"
+	uaCurl      = "curl/7.55.1"
+)
+
+func TestUserAgentFamily(t *testing.T) {
+	cases := []struct {
+		userAgent string
+		family    string
+	}{
+		{uaFirefox, "Firefox"},
+		{uaChrome, "Chrome"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_1) AppleWebKit/604.3.5 (KHTML, like Gecko) Version/11.0.1 Safari/604.3.5", "Safari"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/62.0.3202.94 Safari/537.36 Edge/16.16299", "Edge"},
+		{uaCurl, "curl"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if family := UserAgentFamily(c.userAgent); family != c.family {
+			t.Errorf("incorrect family for %q: expected %q but got %q", c.userAgent, c.family, family)
+		}
+	}
+}
+
+func TestBindingPolicy(t *testing.T) {
+	state := &clientState{IP: "10.0.1.20", UserAgent: uaFirefox}
+	policy := BindingPolicy{IPv4Prefix: 24, IPv6Prefix: 64, UserAgentFamily: true}
+
+	cases := []struct {
+		name      string
+		ip        string
+		userAgent string
+		match     bool
+	}{
+		{"same client", "10.0.1.20", uaFirefox, true},
+		{"same subnet, browser updated", "10.0.1.99", uaFirefox58, true},
+		{"other subnet", "10.0.2.20", uaFirefox, false},
+		{"other browser", "10.0.1.20", uaChrome, false},
+		{"curl", "10.0.1.20", uaCurl, false},
+		{"IPv6", "2001:db8::1", uaFirefox, false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = net.JoinHostPort(c.ip, "1234")
+		req.Header.Set("User-Agent", c.userAgent)
+		err := policy.check(req, state)
+		if c.match && err != nil {
+			t.Errorf("case %s: unexpected error: %v", c.name, err)
+		}
+		if !c.match && err != ErrClientMismatch {
+			t.Errorf("case %s: incorrect error: expected %v but got %v", c.name, ErrClientMismatch, err)
+		}
+	}
+
+	//IPv6 subnets
+	state6 := &clientState{IP: "2001:db8:0:1::5", UserAgent: uaFirefox}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", uaFirefox)
+	req.RemoteAddr = "[2001:db8:0:1::99]:1234"
+	if err := policy.check(req, state6); err != nil {
+		t.Errorf("unexpected error for the same IPv6 subnet: %v", err)
+	}
+	req.RemoteAddr = "[2001:db8:0:2::5]:1234"
+	if err := policy.check(req, state6); err != ErrClientMismatch {
+		t.Errorf("incorrect error for another IPv6 subnet: expected %v but got %v", ErrClientMismatch, err)
+	}
+
+	//the zero value binds nothing
+	req.RemoteAddr = "192.168.0.1:1234"
+	req.Header.Set("User-Agent", uaCurl)
+	if err := (BindingPolicy{}).check(req, state); err != nil {
+		t.Errorf("unexpected error from the zero value policy: %v", err)
+	}
+}
+
+func TestGetStateBinding(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	defer func() { DefaultBinding = BindingPolicy{} }()
+	DefaultBinding = BindingPolicy{IPv4Prefix: 24, UserAgentFamily: true}
+
+	w := httptest.NewRecorder()
+	sid, _ := BeginSession(key, store, &clientState{IP: "10.0.1.20", UserAgent: uaFirefox}, w)
+	newRequest := func(ip string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("User-Agent", uaFirefox)
+		req.Header.Set(headerAuthorization, w.Header().Get(headerAuthorization))
+		return req
+	}
+
+	if _, err := GetState(newRequest("10.0.1.20"), key, store, &clientState{}); err != nil {
+		t.Errorf("unexpected error getting state from the same client: %v", err)
+	}
+	if _, err := GetState(newRequest("10.9.9.9"), key, store, &clientState{}); err != ErrClientMismatch {
+		t.Errorf("incorrect error getting state from another client: expected %v but got %v", ErrClientMismatch, err)
+	}
+
+	DefaultBinding.Action = RequireStepUp
+	state := &clientState{}
+	sidRet, err := GetState(newRequest("10.9.9.9"), key, store, state)
+	if err != ErrStepUpRequired {
+		t.Errorf("incorrect error getting state from another client: expected %v but got %v", ErrStepUpRequired, err)
+	}
+	if sidRet != sid || state.IP != "10.0.1.20" {
+		t.Error("the SessionID and state should be returned when step-up is required")
+	}
+}
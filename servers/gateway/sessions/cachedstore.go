@@ -0,0 +1,152 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//invalidationChannel is the redis pub/sub channel CachedStores use
+//to tell each other a session changed
+const invalidationChannel = "sessions:invalidate"
+
+//CachedStore is a Store decorator that keeps recently used session
+//state in an in-process LRU cache in front of another Store (usually
+//a RedisStore), so most requests don't need a round trip to redis.
+//Entries only live for a short TTL. When a redis client is given,
+//Save and Delete publish the SessionID on a pub/sub channel so the
+//CachedStores in other gateway instances evict their copies.
+//
+//Cache hits don't reach the wrapped store, so they don't reset its
+//session expiry; keep the TTL much shorter than the session duration.
+type CachedStore struct {
+	store      Store
+	cache      *lruCache
+	client     redis.UniversalClient
+	pubsub     *redis.PubSub
+	instanceID string
+}
+
+//NewCachedStore constructs a new CachedStore wrapping `store`, caching
+//at most `maxEntries` sessions for `ttl` each. If `client` is non-nil
+//it is used to publish and subscribe to invalidations.
+func NewCachedStore(store Store, client redis.UniversalClient, maxEntries int, ttl time.Duration) *CachedStore {
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	cs := &CachedStore{
+		store:      store,
+		cache:      newLRUCache(maxEntries, ttl),
+		client:     client,
+		instanceID: hex.EncodeToString(idBytes),
+	}
+	if client != nil {
+		cs.pubsub = client.Subscribe(invalidationChannel)
+		go cs.listen()
+	}
+	return cs
+}
+
+//Store implementation
+
+//Save saves the provided `sessionState` to the wrapped store and the
+//cache, and tells other instances to drop their copies
+func (cs *CachedStore) Save(sid SessionID, sessionState interface{}) error {
+	jsonVal, err := json.Marshal(sessionState)
+	if err != nil {
+		return err
+	}
+	if err := cs.store.Save(sid, withDurationOf(sessionState, json.RawMessage(jsonVal))); err != nil {
+		return err
+	}
+	cs.cache.set(sid.String(), jsonVal)
+	cs.publish(sid)
+	return nil
+}
+
+//Get populates `sessionState` from the cache, or from the
+//wrapped store if it isn't cached
+func (cs *CachedStore) Get(sid SessionID, sessionState interface{}) error {
+	jsonVal, found := cs.cache.get(sid.String())
+	if !found {
+		var raw json.RawMessage
+		if err := cs.store.Get(sid, &raw); err != nil {
+			return err
+		}
+		jsonVal = raw
+		cs.cache.set(sid.String(), jsonVal)
+	}
+	return json.Unmarshal(jsonVal, sessionState)
+}
+
+//Update saves the provided `sessionState` to the wrapped store and the
+//cache only if state is already saved for the SessionID, returning
+//ErrStateNotFound if it isn't, and tells other instances to drop their
+//copies either way
+func (cs *CachedStore) Update(sid SessionID, sessionState interface{}) error {
+	jsonVal, err := json.Marshal(sessionState)
+	if err != nil {
+		return err
+	}
+	if err := UpdateState(cs.store, sid, withDurationOf(sessionState, json.RawMessage(jsonVal))); err != nil {
+		cs.cache.remove(sid.String())
+		cs.publish(sid)
+		return err
+	}
+	cs.cache.set(sid.String(), jsonVal)
+	cs.publish(sid)
+	return nil
+}
+
+//Delete deletes the session from the wrapped store and the cache,
+//and tells other instances to drop their copies
+func (cs *CachedStore) Delete(sid SessionID) error {
+	cs.cache.remove(sid.String())
+	err := cs.store.Delete(sid)
+	cs.publish(sid)
+	return err
+}
+
+//Unwrap returns the wrapped store
+func (cs *CachedStore) Unwrap() Store {
+	return cs.store
+}
+
+//Close stops listening for invalidations from other instances
+func (cs *CachedStore) Close() error {
+	if cs.pubsub == nil {
+		return nil
+	}
+	return cs.pubsub.Close()
+}
+
+//publish announces that `sid` changed on this instance
+func (cs *CachedStore) publish(sid SessionID) {
+	if cs.client == nil {
+		return
+	}
+	if err := cs.client.Publish(invalidationChannel, cs.instanceID+":"+sid.String()).Err(); err != nil {
+		log.Printf("error publishing session invalidation: %v", err)
+	}
+}
+
+//listen evicts sessions that other instances announce have changed
+func (cs *CachedStore) listen() {
+	for msg := range cs.pubsub.Channel() {
+		cs.invalidate(msg.Payload)
+	}
+}
+
+//invalidate handles an invalidation message of the form
+//"<instance ID>:<SessionID>", ignoring this instance's own messages
+func (cs *CachedStore) invalidate(payload string) {
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) != 2 || parts[0] == cs.instanceID {
+		return
+	}
+	cs.cache.remove(parts[1])
+}
@@ -0,0 +1,103 @@
+package sessions
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache(2, time.Hour)
+	cache.set("a", []byte("1"))
+	cache.set("b", []byte("2"))
+	//touch "a" so that "b" is the least recently used
+	if _, found := cache.get("a"); !found {
+		t.Error("expected to find a")
+	}
+	cache.set("c", []byte("3"))
+	if _, found := cache.get("b"); found {
+		t.Error("least recently used entry was not evicted")
+	}
+	if v, found := cache.get("a"); !found || string(v) != "1" {
+		t.Errorf("expected a=1 but got %s, %v", v, found)
+	}
+	cache.remove("a")
+	if _, found := cache.get("a"); found {
+		t.Error("removed entry is still cached")
+	}
+
+	expiring := newLRUCache(2, time.Millisecond)
+	expiring.set("a", []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+	if _, found := expiring.get("a"); found {
+		t.Error("expired entry is still cached")
+	}
+}
+
+func TestCachedStore(t *testing.T) {
+	type sessionState struct {
+		Sval string
+		Ival int
+	}
+	state := &sessionState{
+		Sval: "testing",
+		Ival: 99,
+	}
+
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	inner := NewMemStore(time.Hour, time.Minute)
+	store := NewCachedStore(inner, nil, 10, time.Hour)
+
+	if err := store.Get(sid, &sessionState{}); err != ErrStateNotFound {
+		t.Errorf("incorrect error when getting state that was never stored: expected %v but got %v", ErrStateNotFound, err)
+	}
+	if err := store.Save(sid, state); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+
+	//the state is written through to the wrapped store
+	stateRet := &sessionState{}
+	if err := inner.Get(sid, stateRet); err != nil {
+		t.Fatalf("error getting state from wrapped store: %v", err)
+	}
+	if !reflect.DeepEqual(state, stateRet) {
+		t.Errorf("incorrect state in wrapped store: expected %v but got %v", state, stateRet)
+	}
+
+	//and served from the cache even if the wrapped store loses it
+	inner.Delete(sid)
+	stateRet = &sessionState{}
+	if err := store.Get(sid, stateRet); err != nil {
+		t.Fatalf("error getting cached state: %v", err)
+	}
+	if !reflect.DeepEqual(state, stateRet) {
+		t.Errorf("incorrect cached state: expected %v but got %v", state, stateRet)
+	}
+
+	//an invalidation from this instance is ignored...
+	store.invalidate(store.instanceID + ":" + sid.String())
+	if _, found := store.cache.get(sid.String()); !found {
+		t.Error("cache entry was evicted by this instance's own invalidation")
+	}
+	//...but one from another instance evicts the entry
+	store.invalidate("otherinstance:" + sid.String())
+	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
+		t.Errorf("incorrect error after invalidation: expected %v but got %v", ErrStateNotFound, err)
+	}
+
+	//misses are loaded from the wrapped store
+	inner.Save(sid, state)
+	if err := store.Get(sid, stateRet); err != nil {
+		t.Errorf("error getting state that is only in the wrapped store: %v", err)
+	}
+
+	if err := store.Delete(sid); err != nil {
+		t.Errorf("error deleting state: %v", err)
+	}
+	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
+		t.Errorf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
+	}
+}
@@ -0,0 +1,97 @@
+package sessions
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrInvalidCode is returned when a code is malformed, unknown,
+//already used, expired, or was issued for a different subject
+var ErrInvalidCode = errors.New("invalid or expired code")
+
+//code is the state saved for each code
+type code struct {
+	Subject string
+	Expires time.Time
+}
+
+//Codes issues and redeems single-use, expiring codes that are sent to
+//users out of band, e.g. password reset codes sent by email. Each code
+//is bound to a subject, like a user ID, and is signed with a key derived
+//from the session signing key and the purpose of the codes, so codes
+//for one purpose can't be used for another or in place of a SessionID.
+type Codes struct {
+	signingKey string
+	store      Store
+	lifetime   time.Duration
+}
+
+//NewCodes constructs a new Codes for `purpose`, e.g. "reset", issuing
+//codes valid for `lifetime`. The `store` only needs to keep state for that long.
+func NewCodes(signingKey string, purpose string, store Store, lifetime time.Duration) *Codes {
+	return &Codes{
+		signingKey: signingKey + ":" + purpose,
+		store:      store,
+		lifetime:   lifetime,
+	}
+}
+
+//Issue creates a code for `subject` and returns it with its expiry time
+func (cs *Codes) Issue(subject string) (SessionID, time.Time, error) {
+	codeID, err := NewSessionID(cs.signingKey)
+	if err != nil {
+		return InvalidSessionID, time.Time{}, err
+	}
+	state := &code{
+		Subject: subject,
+		Expires: time.Now().Add(cs.lifetime),
+	}
+	if err := cs.store.Save(codeID, state); err != nil {
+		return InvalidSessionID, time.Time{}, err
+	}
+	return codeID, state.Expires, nil
+}
+
+//Redeem uses up the code, returning ErrInvalidCode unless
+//it is valid and was issued for `subject`. The code is taken from the
+//store atomically, so of several racing requests only one redeems it.
+func (cs *Codes) Redeem(codeID string, subject string) error {
+	sid, err := cs.lookup(codeID, subject)
+	if err != nil {
+		return err
+	}
+	if err := take(cs.store, sid, &code{}); err != nil {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+//Check returns ErrInvalidCode unless the code is valid and was issued
+//for `subject`, without using it up, e.g. to check every code a request
+//needs before redeeming any of them
+func (cs *Codes) Check(codeID string, subject string) error {
+	_, err := cs.lookup(codeID, subject)
+	return err
+}
+
+//lookup returns the ID of the code if it is valid and was issued for
+//`subject`. Expired codes are deleted.
+func (cs *Codes) lookup(codeID string, subject string) (SessionID, error) {
+	sid, err := ValidateID(codeID, cs.signingKey)
+	if err != nil {
+		return InvalidSessionID, ErrInvalidCode
+	}
+	state := &code{}
+	if err := cs.store.Get(sid, state); err != nil {
+		return InvalidSessionID, ErrInvalidCode
+	}
+	if state.Subject != subject {
+		//don't use it up, so guessing subjects can't burn someone's code
+		return InvalidSessionID, ErrInvalidCode
+	}
+	if time.Now().After(state.Expires) {
+		cs.store.Delete(sid)
+		return InvalidSessionID, ErrInvalidCode
+	}
+	return sid, nil
+}
@@ -0,0 +1,62 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCodes(t *testing.T) {
+	key := "test key"
+	codes := NewCodes(key, "reset", NewMemStore(time.Minute, time.Minute), time.Minute)
+
+	codeID, expires, err := codes.Issue("user1")
+	if err != nil {
+		t.Fatalf("error issuing code: %v", err)
+	}
+	if time.Until(expires) > time.Minute {
+		t.Errorf("code expires too late: %v", expires)
+	}
+	if _, err := ValidateID(codeID.String(), key); err == nil {
+		t.Error("code validated as a SessionID")
+	}
+	other := NewCodes(key, "verify", NewMemStore(time.Minute, time.Minute), time.Minute)
+	if err := other.Redeem(codeID.String(), "user1"); err != ErrInvalidCode {
+		t.Errorf("incorrect error redeeming a code for another purpose: expected %v but got %v", ErrInvalidCode, err)
+	}
+	if err := codes.Redeem(codeID.String(), "user2"); err != ErrInvalidCode {
+		t.Errorf("incorrect error redeeming a code for another subject: expected %v but got %v", ErrInvalidCode, err)
+	}
+	if err := codes.Check(codeID.String(), "user2"); err != ErrInvalidCode {
+		t.Errorf("incorrect error checking a code for another subject: expected %v but got %v", ErrInvalidCode, err)
+	}
+	//checking doesn't use the code up
+	for i := 0; i < 2; i++ {
+		if err := codes.Check(codeID.String(), "user1"); err != nil {
+			t.Errorf("error checking code: %v", err)
+		}
+	}
+	if err := codes.Redeem(codeID.String(), "user1"); err != nil {
+		t.Errorf("error redeeming code: %v", err)
+	}
+	if err := codes.Redeem(codeID.String(), "user1"); err != ErrInvalidCode {
+		t.Errorf("incorrect error redeeming a code twice: expected %v but got %v", ErrInvalidCode, err)
+	}
+
+	expiring := NewCodes(key, "reset", NewMemStore(time.Minute, time.Minute), time.Millisecond)
+	codeID, _, _ = expiring.Issue("user1")
+	time.Sleep(5 * time.Millisecond)
+	if err := expiring.Redeem(codeID.String(), "user1"); err != ErrInvalidCode {
+		t.Errorf("incorrect error redeeming an expired code: expected %v but got %v", ErrInvalidCode, err)
+	}
+}
+
+func TestCodesRedeemOnce(t *testing.T) {
+	codes := NewCodes("test key", "reset", NewMemStore(time.Minute, time.Minute), time.Minute)
+	codeID, _, _ := codes.Issue("user1")
+	redeemed := succeedConcurrently(20, func() error {
+		return codes.Redeem(codeID.String(), "user1")
+	})
+	if redeemed != 1 {
+		t.Errorf("expected a code to be redeemed once but it was redeemed %d times", redeemed)
+	}
+}
@@ -0,0 +1,124 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/patrickmn/go-cache"
+)
+
+//Denylist keeps track of stateless tokens that were revoked before
+//they expired. Entries only need to outlive the token they revoke.
+type Denylist interface {
+	//Revoke adds the token ID to the denylist until `expires`
+	Revoke(tokenID string, expires time.Time) error
+
+	//IsRevoked reports whether the token ID is on the denylist
+	IsRevoked(tokenID string) (bool, error)
+
+	//RevokeUser revokes every token issued to the user so far, e.g.
+	//when their roles change and the tokens' claims no longer hold
+	RevokeUser(userID string) error
+
+	//UserRevokedAt returns when the user's tokens were last revoked,
+	//or the zero time if none of their live tokens were
+	UserRevokedAt(userID string) (time.Time, error)
+}
+
+//userEntry returns the denylist entry revoking the user's tokens. Token
+//IDs are base64 URL encoded, so they can't contain a ":".
+func userEntry(userID string) string {
+	return "user:" + userID
+}
+
+//MemDenylist is an in-process Denylist, for testing and prototyping
+type MemDenylist struct {
+	entries *cache.Cache
+}
+
+//NewMemDenylist constructs and returns a new MemDenylist
+func NewMemDenylist() *MemDenylist {
+	return &MemDenylist{
+		entries: cache.New(MaxTokenLifetime, time.Minute),
+	}
+}
+
+//Revoke adds the token ID to the denylist until `expires`
+func (md *MemDenylist) Revoke(tokenID string, expires time.Time) error {
+	md.entries.Set(tokenID, true, time.Until(expires))
+	return nil
+}
+
+//IsRevoked reports whether the token ID is on the denylist
+func (md *MemDenylist) IsRevoked(tokenID string) (bool, error) {
+	_, found := md.entries.Get(tokenID)
+	return found, nil
+}
+
+//RevokeUser revokes every token issued to the user so far
+func (md *MemDenylist) RevokeUser(userID string) error {
+	md.entries.Set(userEntry(userID), time.Now(), MaxTokenLifetime)
+	return nil
+}
+
+//UserRevokedAt returns when the user's tokens were last revoked
+func (md *MemDenylist) UserRevokedAt(userID string) (time.Time, error) {
+	revokedAt, found := md.entries.Get(userEntry(userID))
+	if !found {
+		return time.Time{}, nil
+	}
+	return revokedAt.(time.Time), nil
+}
+
+//RedisDenylist is a Denylist backed by redis, shared by all gateways
+type RedisDenylist struct {
+	Client redis.UniversalClient
+}
+
+//NewRedisDenylist constructs a new RedisDenylist
+func NewRedisDenylist(client redis.UniversalClient) *RedisDenylist {
+	return &RedisDenylist{
+		Client: client,
+	}
+}
+
+//Revoke adds the token ID to the denylist until `expires`
+func (rd *RedisDenylist) Revoke(tokenID string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		//already expired, so there's nothing to deny
+		return nil
+	}
+	return rd.Client.Set(getRevokedRedisKey(tokenID), 1, ttl).Err()
+}
+
+//IsRevoked reports whether the token ID is on the denylist
+func (rd *RedisDenylist) IsRevoked(tokenID string) (bool, error) {
+	n, err := rd.Client.Exists(getRevokedRedisKey(tokenID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+//RevokeUser revokes every token issued to the user so far
+func (rd *RedisDenylist) RevokeUser(userID string) error {
+	return rd.Client.Set(getRevokedRedisKey(userEntry(userID)), time.Now().UnixNano(), MaxTokenLifetime).Err()
+}
+
+//UserRevokedAt returns when the user's tokens were last revoked
+func (rd *RedisDenylist) UserRevokedAt(userID string) (time.Time, error) {
+	nanos, err := rd.Client.Get(getRevokedRedisKey(userEntry(userID))).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nanos), nil
+}
+
+//getRevokedRedisKey returns the redis key marking a token ID as revoked
+func getRevokedRedisKey(tokenID string) string {
+	return "revoked:" + tokenID
+}
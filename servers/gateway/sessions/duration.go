@@ -0,0 +1,51 @@
+package sessions
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//Durable is implemented by session states that should last longer
+//(or shorter) than the store's session duration when not used, e.g.
+//sessions begun with "remember me". Stores never shorten a session
+//when getting it, so the duration sticks until the state is saved again.
+type Durable interface {
+	//SessionDuration returns how long the session lasts without being
+	//saved, or 0 for the store's session duration
+	SessionDuration() time.Duration
+}
+
+//durationOf returns the duration of `sessionState` if it is Durable,
+//or `defaultDuration` otherwise
+func durationOf(sessionState interface{}, defaultDuration time.Duration) time.Duration {
+	if durable, ok := sessionState.(Durable); ok && durable.SessionDuration() > 0 {
+		return durable.SessionDuration()
+	}
+	return defaultDuration
+}
+
+//durableValue carries the duration of a session state through store
+//decorators that encode the state before saving it to the wrapped store
+type durableValue struct {
+	value    interface{}
+	duration time.Duration
+}
+
+//MarshalJSON encodes the wrapped value
+func (dv *durableValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dv.value)
+}
+
+//SessionDuration returns the duration of the original session state
+func (dv *durableValue) SessionDuration() time.Duration {
+	return dv.duration
+}
+
+//withDurationOf returns `value`, wrapped so it has the same duration
+//as `sessionState` if that is Durable
+func withDurationOf(sessionState interface{}, value interface{}) interface{} {
+	if d := durationOf(sessionState, 0); d > 0 {
+		return &durableValue{value: value, duration: d}
+	}
+	return value
+}
@@ -0,0 +1,55 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+)
+
+type durableState struct {
+	Value    string
+	Duration time.Duration
+}
+
+func (ds *durableState) SessionDuration() time.Duration {
+	return ds.Duration
+}
+
+func TestDurableState(t *testing.T) {
+	key := "test key"
+	mem := NewMemStore(20*time.Millisecond, time.Millisecond)
+	encrypted, _ := NewEncryptedStore(NewMemStore(20*time.Millisecond, time.Millisecond), testEncKey)
+	cached := NewCachedStore(NewMemStore(20*time.Millisecond, time.Millisecond), nil, 10, time.Millisecond)
+
+	cases := []struct {
+		name  string
+		store Store
+	}{
+		{"MemStore", mem},
+		{"EncryptedStore Wrapping MemStore", encrypted},
+		{"CachedStore Wrapping MemStore", cached},
+	}
+	for _, c := range cases {
+		durableID, _ := NewSessionID(key)
+		defaultID, _ := NewSessionID(key)
+		if err := c.store.Save(durableID, &durableState{"durable", time.Hour}); err != nil {
+			t.Fatalf("case %s: error saving durable state: %v", c.name, err)
+		}
+		c.store.Save(defaultID, &durableState{"default", 0})
+		//getting a durable session must not shorten it
+		if err := c.store.Get(durableID, &durableState{}); err != nil {
+			t.Fatalf("case %s: error getting durable state: %v", c.name, err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		stateRet := &durableState{}
+		if err := c.store.Get(durableID, stateRet); err != nil {
+			t.Errorf("case %s: durable state expired with the store's duration: %v", c.name, err)
+		}
+		if stateRet.Value != "durable" {
+			t.Errorf("case %s: incorrect durable state: %v", c.name, stateRet)
+		}
+		if err := c.store.Get(defaultID, &durableState{}); err != ErrStateNotFound {
+			t.Errorf("case %s: state without a duration should have expired", c.name)
+		}
+	}
+}
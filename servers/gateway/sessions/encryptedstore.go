@@ -0,0 +1,178 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//encryptedVersion is the first byte of every encrypted payload so
+//the format can change later without breaking stored sessions
+const encryptedVersion byte = 1
+
+//keyIDLength is the number of bytes used to identify which key
+//encrypted a payload
+const keyIDLength = 4
+
+//ErrDecryptState is returned when a stored payload can't be decrypted
+//with any of the keys the EncryptedStore knows about
+var ErrDecryptState = errors.New("session state could not be decrypted")
+
+//encryptionKey pairs an AEAD cipher with the short ID written
+//in front of every payload it encrypts
+type encryptionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+//EncryptedStore is a Store decorator that encrypts session state with
+//AES-GCM before handing it to another Store, so any backend (redis,
+//memory) only ever sees ciphertext. The payload layout is:
+//+--------------------------------------------------------+
+//|version|key ID (4 bytes)|nonce|ciphertext + GCM tag      |
+//+--------------------------------------------------------+
+//The SessionID is used as additional data, so a payload copied to
+//another session key will fail to decrypt.
+type EncryptedStore struct {
+	store Store
+	//keys[0] encrypts new payloads, the rest are only used to decrypt
+	keys []*encryptionKey
+}
+
+//NewEncryptedStore constructs a new EncryptedStore wrapping `store`.
+//The first key is used to encrypt; any additional keys are older keys
+//that are still accepted for decryption, which allows keys to be
+//rotated without ending everyone's sessions. Payloads read with an
+//older key are re-encrypted with the current key. Each key must be
+//16, 24 or 32 bytes long.
+func NewEncryptedStore(store Store, keys ...[]byte) (*EncryptedStore, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("error at least one encryption key is required")
+	}
+	es := &EncryptedStore{
+		store: store,
+		keys:  make([]*encryptionKey, 0, len(keys)),
+	}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("error creating cipher: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("error creating GCM: %v", err)
+		}
+		hash := sha256.Sum256(key)
+		es.keys = append(es.keys, &encryptionKey{
+			id:   hash[:keyIDLength],
+			aead: aead,
+		})
+	}
+	return es, nil
+}
+
+//Store implementation
+
+//Save encrypts the provided `sessionState` with the current key and
+//saves it to the wrapped store under the given SessionID.
+func (es *EncryptedStore) Save(sid SessionID, sessionState interface{}) error {
+	jsonVal, err := json.Marshal(sessionState)
+	if err != nil {
+		return err
+	}
+	payload, err := es.encrypt(sid, jsonVal)
+	if err != nil {
+		return err
+	}
+	return es.store.Save(sid, withDurationOf(sessionState, payload))
+}
+
+//Get decrypts the data previously saved for the given SessionID
+//and populates `sessionState` with it
+func (es *EncryptedStore) Get(sid SessionID, sessionState interface{}) error {
+	var payload []byte
+	if err := es.store.Get(sid, &payload); err != nil {
+		return err
+	}
+	jsonVal, key, err := es.decrypt(sid, payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(jsonVal, sessionState); err != nil {
+		return err
+	}
+	if key != es.keys[0] {
+		//written with an old key, so upgrade it to the current one
+		if upgraded, err := es.encrypt(sid, jsonVal); err == nil {
+			es.store.Save(sid, withDurationOf(sessionState, upgraded))
+		}
+	}
+	return nil
+}
+
+//Update encrypts the provided `sessionState` with the current key and
+//saves it to the wrapped store only if state is already saved for the
+//SessionID, returning ErrStateNotFound if it isn't
+func (es *EncryptedStore) Update(sid SessionID, sessionState interface{}) error {
+	jsonVal, err := json.Marshal(sessionState)
+	if err != nil {
+		return err
+	}
+	payload, err := es.encrypt(sid, jsonVal)
+	if err != nil {
+		return err
+	}
+	return UpdateState(es.store, sid, withDurationOf(sessionState, payload))
+}
+
+//Delete deletes all state data associated with the SessionID from the wrapped store.
+func (es *EncryptedStore) Delete(sid SessionID) error {
+	return es.store.Delete(sid)
+}
+
+//Unwrap returns the wrapped store
+func (es *EncryptedStore) Unwrap() Store {
+	return es.store
+}
+
+//encrypt seals `plaintext` with the current key
+func (es *EncryptedStore) encrypt(sid SessionID, plaintext []byte) ([]byte, error) {
+	key := es.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	header := append([]byte{encryptedVersion}, key.id...)
+	payload := append(header, nonce...)
+	return key.aead.Seal(payload, nonce, plaintext, []byte(sid)), nil
+}
+
+//decrypt opens `payload` with whichever key encrypted it, and returns
+//the plaintext along with that key
+func (es *EncryptedStore) decrypt(sid SessionID, payload []byte) ([]byte, *encryptionKey, error) {
+	if len(payload) < 1+keyIDLength || payload[0] != encryptedVersion {
+		return nil, nil, ErrDecryptState
+	}
+	keyID := payload[1 : 1+keyIDLength]
+	for _, key := range es.keys {
+		if !bytes.Equal(key.id, keyID) {
+			continue
+		}
+		rest := payload[1+keyIDLength:]
+		if len(rest) < key.aead.NonceSize() {
+			return nil, nil, ErrDecryptState
+		}
+		nonce, ciphertext := rest[:key.aead.NonceSize()], rest[key.aead.NonceSize():]
+		plaintext, err := key.aead.Open(nil, nonce, ciphertext, []byte(sid))
+		if err != nil {
+			return nil, nil, ErrDecryptState
+		}
+		return plaintext, key, nil
+	}
+	return nil, nil, ErrDecryptState
+}
@@ -0,0 +1,126 @@
+package sessions
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testEncKey = []byte("0123456789abcdef0123456789abcdef")
+var testOldEncKey = []byte("fedcba9876543210fedcba9876543210")
+
+func TestNewEncryptedStore(t *testing.T) {
+	cases := []struct {
+		name        string
+		keys        [][]byte
+		expectError bool
+	}{
+		{"No Keys", nil, true},
+		{"Bad Key Length", [][]byte{[]byte("short")}, true},
+		{"Valid Key", [][]byte{testEncKey}, false},
+		{"Valid Key Plus Old Key", [][]byte{testEncKey, testOldEncKey}, false},
+	}
+	for _, c := range cases {
+		_, err := NewEncryptedStore(NewMemStore(time.Hour, time.Minute), c.keys...)
+		if err != nil && !c.expectError {
+			t.Errorf("case %s: unexpected error: %v", c.name, err)
+		}
+		if err == nil && c.expectError {
+			t.Errorf("case %s: expected error but didn't get one", c.name)
+		}
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	type sessionState struct {
+		Sval string
+		Ival int
+	}
+	state := &sessionState{
+		Sval: "testing",
+		Ival: 99,
+	}
+
+	sid, err := NewSessionID("test key")
+	if err != nil {
+		t.Fatalf("error generating new SessionID: %v", err)
+	}
+	inner := NewMemStore(time.Hour, time.Minute)
+	store, err := NewEncryptedStore(inner, testEncKey)
+	if err != nil {
+		t.Fatalf("error creating encrypted store: %v", err)
+	}
+
+	if err := store.Get(sid, &sessionState{}); err != ErrStateNotFound {
+		t.Errorf("incorrect error when getting state that was never stored: expected %v but got %v", ErrStateNotFound, err)
+	}
+	if err := store.Save(sid, state); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+
+	//the wrapped store should never see the plaintext
+	var raw []byte
+	if err := inner.Get(sid, &raw); err != nil {
+		t.Fatalf("error getting raw payload: %v", err)
+	}
+	if bytes.Contains(raw, []byte(state.Sval)) {
+		t.Error("wrapped store contains plaintext session state")
+	}
+
+	stateRet := &sessionState{}
+	if err := store.Get(sid, stateRet); err != nil {
+		t.Fatalf("error getting state: %v", err)
+	}
+	if !reflect.DeepEqual(state, stateRet) {
+		t.Errorf("incorrect state retrieved: expected %v but got %v", state, stateRet)
+	}
+
+	//a payload moved to a different SessionID must not decrypt
+	sid2, _ := NewSessionID("test key")
+	inner.Save(sid2, raw)
+	if err := store.Get(sid2, &sessionState{}); err != ErrDecryptState {
+		t.Errorf("incorrect error when getting a payload copied from another session: expected %v but got %v", ErrDecryptState, err)
+	}
+
+	//a store with a different key can't read it
+	other, _ := NewEncryptedStore(inner, testOldEncKey)
+	if err := other.Get(sid, &sessionState{}); err != ErrDecryptState {
+		t.Errorf("incorrect error when decrypting with the wrong key: expected %v but got %v", ErrDecryptState, err)
+	}
+
+	if err := store.Delete(sid); err != nil {
+		t.Errorf("error deleting state: %v", err)
+	}
+	if err := store.Get(sid, stateRet); err != ErrStateNotFound {
+		t.Errorf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
+	}
+}
+
+func TestEncryptedStoreKeyRotation(t *testing.T) {
+	state := map[string]string{"Sval": "testing"}
+	sid, _ := NewSessionID("test key")
+	inner := NewMemStore(time.Hour, time.Minute)
+
+	oldStore, _ := NewEncryptedStore(inner, testOldEncKey)
+	if err := oldStore.Save(sid, state); err != nil {
+		t.Fatalf("error saving state: %v", err)
+	}
+
+	//after rotation the new key is first and the old key is still accepted
+	rotated, _ := NewEncryptedStore(inner, testEncKey, testOldEncKey)
+	stateRet := map[string]string{}
+	if err := rotated.Get(sid, &stateRet); err != nil {
+		t.Fatalf("error getting state saved with the old key: %v", err)
+	}
+	if !reflect.DeepEqual(state, stateRet) {
+		t.Errorf("incorrect state retrieved: expected %v but got %v", state, stateRet)
+	}
+
+	//reading it should have re-encrypted it with the new key,
+	//so the old key is no longer needed
+	newOnly, _ := NewEncryptedStore(inner, testEncKey)
+	if err := newOnly.Get(sid, &stateRet); err != nil {
+		t.Errorf("state was not re-encrypted with the current key: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package sessions
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	//EventStarted is the type of Event fired when a session begins
+	EventStarted = "session.started"
+	//EventEnded is the type of Event fired when a session ends
+	EventEnded = "session.ended"
+)
+
+//Event describes a change in a session's lifecycle
+type Event struct {
+	Type string `json:"type"`
+	//Session is the session's Handle, not the SessionID itself
+	Session string    `json:"session"`
+	UserID  string    `json:"userID,omitempty"`
+	Time    time.Time `json:"time"`
+	//State is the session state, when it is known. It is not
+	//included when the event is encoded.
+	State interface{} `json:"-"`
+}
+
+//Hook is called with every session Event. Hooks run synchronously
+//on the request goroutine, so they should be quick.
+type Hook func(e *Event)
+
+var hooks []Hook
+var hooksMx sync.RWMutex
+
+//AddHook registers a Hook to be called whenever a session begins or ends
+func AddHook(hook Hook) {
+	hooksMx.Lock()
+	defer hooksMx.Unlock()
+	hooks = append(hooks, hook)
+}
+
+//NewSessionState, if set, returns a pointer to an empty session state,
+//so a session's state can be read back before it's ended. That lets
+//ended events carry the state and user ID, and lets EndSession remove
+//the session from the store's UserIndex. Set it once during startup.
+var NewSessionState func() interface{}
+
+//fire calls every registered Hook with a new Event
+func fire(eventType string, sid SessionID, sessionState interface{}) {
+	hooksMx.RLock()
+	defer hooksMx.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	e := &Event{
+		Type:    eventType,
+		Session: sid.Handle(),
+		Time:    time.Now(),
+		State:   sessionState,
+	}
+	if owner, ok := sessionState.(Owner); ok {
+		e.UserID = owner.SessionOwner()
+	}
+	for _, hook := range hooks {
+		hook(e)
+	}
+}
+
+//DeleteSession ends the session `sid` without a request: it deletes the
+//session from the store and its owner's UserIndex, and fires an EventEnded
+func DeleteSession(store Store, sid SessionID) error {
+	var sessionState interface{}
+	if NewSessionState != nil {
+		sessionState = NewSessionState()
+		if err := store.Get(sid, sessionState); err != nil {
+			sessionState = nil
+		}
+	}
+	if err := store.Delete(sid); err != nil {
+		return err
+	}
+	if owner, ok := sessionState.(Owner); ok {
+		RemoveUserSession(store, owner.SessionOwner(), sid)
+	}
+	fire(EventEnded, sid, sessionState)
+	return nil
+}
+
+//NewRedisEventHook returns a Hook that publishes each Event as JSON
+//to a redis pub/sub channel, so other services can follow sign-ins
+//and sign-outs, e.g. for presence
+func NewRedisEventHook(client redis.UniversalClient, channel string) Hook {
+	return func(e *Event) {
+		jsonVal, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("error encoding session event: %v", err)
+			return
+		}
+		if err := client.Publish(channel, jsonVal).Err(); err != nil {
+			log.Printf("error publishing session event: %v", err)
+		}
+	}
+}
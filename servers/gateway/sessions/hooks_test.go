@@ -0,0 +1,60 @@
+package sessions
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	defer func() {
+		hooks = nil
+		NewSessionState = nil
+	}()
+	NewSessionState = func() interface{} { return &ownedState{} }
+	events := []*Event{}
+	AddHook(func(e *Event) { events = append(events, e) })
+
+	w := httptest.NewRecorder()
+	sid, _ := BeginSession(key, store, &ownedState{"user1"}, w)
+	if len(events) != 1 || events[0].Type != EventStarted {
+		t.Fatalf("expected a %s event but got %v", EventStarted, events)
+	}
+	if events[0].UserID != "user1" || events[0].Session != sid.Handle() {
+		t.Errorf("incorrect started event: %+v", events[0])
+	}
+
+	req := httptest.NewRequest("DELETE", "/", nil)
+	req.Header.Set(headerAuthorization, w.Header().Get(headerAuthorization))
+	if _, err := EndSession(req, key, store); err != nil {
+		t.Fatalf("error ending session: %v", err)
+	}
+	if len(events) != 2 || events[1].Type != EventEnded {
+		t.Fatalf("expected a %s event but got %v", EventEnded, events)
+	}
+	if events[1].UserID != "user1" || events[1].Session != sid.Handle() {
+		t.Errorf("incorrect ended event: %+v", events[1])
+	}
+	if sids, _ := UserSessions(store, "user1"); len(sids) != 0 {
+		t.Errorf("ended session is still indexed: %v", sids)
+	}
+
+	//rotating a session starts the new SessionID and ends the old one
+	events = events[:0]
+	oldID, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	newID, err := RotateSession(key, store, oldID, &ownedState{"user1"}, httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("error rotating session: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events but got %v", events)
+	}
+	if events[1].Type != EventStarted || events[1].Session != newID.Handle() {
+		t.Errorf("expected a %s event for the new session but got %+v", EventStarted, events[1])
+	}
+	if events[2].Type != EventEnded || events[2].Session != oldID.Handle() || events[2].UserID != "user1" {
+		t.Errorf("expected a %s event for the old session but got %+v", EventEnded, events[2])
+	}
+}
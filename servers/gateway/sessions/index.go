@@ -0,0 +1,124 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+//ErrNoUserIndex is returned when the store can't track sessions per user
+var ErrNoUserIndex = errors.New("session store does not index sessions by user")
+
+//UserIndex is implemented by stores that can keep track of
+//which sessions belong to which user
+type UserIndex interface {
+	//AddUserSession records that `sid` belongs to `userID`
+	AddUserSession(userID string, sid SessionID) error
+
+	//RemoveUserSession forgets that `sid` belongs to `userID`
+	RemoveUserSession(userID string, sid SessionID) error
+
+	//UserSessions returns the SessionIDs recorded for `userID`, oldest
+	//first. Sessions that have expired since they were added are left out
+	//and removed from the index, without extending the live ones.
+	UserSessions(userID string) ([]SessionID, error)
+
+	//AddLimitedUserSession records that `sid` belongs to `userID` like
+	//AddUserSession, and if they then have more than `limit.Max` sessions
+	//recorded, either removes their oldest ones and returns them to be
+	//ended, or, with RejectNew, removes `sid` again and returns
+	//ErrTooManySessions. It is atomic, so racing calls can't both get in.
+	//Sessions that expired since UserSessions last removed them count.
+	AddLimitedUserSession(userID string, sid SessionID, limit SessionLimit) ([]SessionID, error)
+}
+
+//Owner is implemented by session states that belong to a user.
+//BeginSession uses it to add new sessions to the store's UserIndex.
+type Owner interface {
+	SessionOwner() string
+}
+
+//wrapper is implemented by Store decorators, like EncryptedStore,
+//so the store they wrap can be reached
+type wrapper interface {
+	Unwrap() Store
+}
+
+//userIndexOf returns the UserIndex of `store`, looking through
+//any decorators wrapped around it
+func userIndexOf(store Store) (UserIndex, error) {
+	for store != nil {
+		if index, ok := store.(UserIndex); ok {
+			return index, nil
+		}
+		w, ok := store.(wrapper)
+		if !ok {
+			break
+		}
+		store = w.Unwrap()
+	}
+	return nil, ErrNoUserIndex
+}
+
+//UserSessions returns the SessionIDs recorded for `userID` in the store
+func UserSessions(store Store, userID string) ([]SessionID, error) {
+	index, err := userIndexOf(store)
+	if err != nil {
+		return nil, err
+	}
+	return index.UserSessions(userID)
+}
+
+//RemoveUserSession removes `sid` from the sessions recorded for `userID`
+func RemoveUserSession(store Store, userID string, sid SessionID) error {
+	index, err := userIndexOf(store)
+	if err != nil {
+		return err
+	}
+	return index.RemoveUserSession(userID, sid)
+}
+
+//EndUserSessions ends every session recorded for `userID`, except
+//those listed in `keep`, e.g. the session making the request
+func EndUserSessions(store Store, userID string, keep ...SessionID) error {
+	sids, err := UserSessions(store, userID)
+	if err != nil {
+		return err
+	}
+	kept := make(map[SessionID]bool, len(keep))
+	for _, sid := range keep {
+		kept[sid] = true
+	}
+	for _, sid := range sids {
+		if kept[sid] {
+			continue
+		}
+		if err := DeleteSession(store, sid); err != nil {
+			return err
+		}
+		RemoveUserSession(store, userID, sid)
+	}
+	return nil
+}
+
+//addOwnedSession adds `sid` to the store's UserIndex if the
+//`sessionState` has an owner and the store has an index
+func addOwnedSession(store Store, sid SessionID, sessionState interface{}) error {
+	owner, ok := sessionState.(Owner)
+	if !ok || len(owner.SessionOwner()) == 0 {
+		return nil
+	}
+	index, err := userIndexOf(store)
+	if err != nil {
+		return nil
+	}
+	return index.AddUserSession(owner.SessionOwner(), sid)
+}
+
+//Handle returns a short identifier for the SessionID that is safe
+//to show to clients, e.g. in a list of a user's sessions. It can't
+//be used to authenticate.
+func (sid SessionID) Handle() string {
+	hash := sha256.Sum256([]byte(sid))
+	return base64.RawURLEncoding.EncodeToString(hash[:12])
+}
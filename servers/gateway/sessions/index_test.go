@@ -0,0 +1,263 @@
+package sessions
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type ownedState struct {
+	UserID string
+}
+
+func (os *ownedState) SessionOwner() string {
+	return os.UserID
+}
+
+func TestUserIndex(t *testing.T) {
+	key := "test key"
+	mem := NewMemStore(time.Hour, time.Minute)
+	encrypted, _ := NewEncryptedStore(mem, testEncKey)
+
+	cases := []struct {
+		name  string
+		store Store
+	}{
+		{"MemStore", mem},
+		{"EncryptedStore Wrapping MemStore", encrypted},
+	}
+	for _, c := range cases {
+		userID := "user-" + c.name
+		sid1, err := BeginSession(key, c.store, &ownedState{userID}, httptest.NewRecorder())
+		if err != nil {
+			t.Fatalf("case %s: error beginning session: %v", c.name, err)
+		}
+		sid2, _ := BeginSession(key, c.store, &ownedState{userID}, httptest.NewRecorder())
+		//sessions without an owner aren't indexed
+		BeginSession(key, c.store, 100, httptest.NewRecorder())
+
+		sids, err := UserSessions(c.store, userID)
+		if err != nil {
+			t.Fatalf("case %s: error listing user sessions: %v", c.name, err)
+		}
+		if len(sids) != 2 {
+			t.Fatalf("case %s: expected 2 sessions but got %d", c.name, len(sids))
+		}
+		for _, sid := range sids {
+			if sid != sid1 && sid != sid2 {
+				t.Errorf("case %s: unexpected SessionID %s in user index", c.name, sid)
+			}
+		}
+
+		if err := RemoveUserSession(c.store, userID, sid1); err != nil {
+			t.Errorf("case %s: error removing user session: %v", c.name, err)
+		}
+		sids, _ = UserSessions(c.store, userID)
+		if len(sids) != 1 || sids[0] != sid2 {
+			t.Errorf("case %s: expected only %s after removal but got %v", c.name, sid2, sids)
+		}
+	}
+}
+
+func TestSessionIDHandle(t *testing.T) {
+	sid, _ := NewSessionID("test key")
+	handle := sid.Handle()
+	if len(handle) == 0 {
+		t.Error("Handle() returned a zero-length string")
+	}
+	if handle != sid.Handle() {
+		t.Error("Handle() is not stable for the same SessionID")
+	}
+	if _, err := ValidateID(handle, "test key"); err == nil {
+		t.Error("a Handle() must not validate as a SessionID")
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	key := "test key"
+	defer func() { DefaultSessionLimit = SessionLimit{} }()
+
+	//evicting the oldest session
+	store := NewMemStore(time.Hour, time.Minute)
+	DefaultSessionLimit = SessionLimit{Max: 2, Policy: EvictOldest}
+	sid1, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	time.Sleep(time.Millisecond)
+	sid2, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	time.Sleep(time.Millisecond)
+	sid3, err := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("unexpected error beginning a session over the limit with EvictOldest: %v", err)
+	}
+	state := &ownedState{}
+	if err := store.Get(sid1, state); err != ErrStateNotFound {
+		t.Error("oldest session was not evicted")
+	}
+	sids, _ := UserSessions(store, "user1")
+	if len(sids) != 2 || sids[0] != sid2 || sids[1] != sid3 {
+		t.Errorf("expected sessions [%s %s] but got %v", sid2, sid3, sids)
+	}
+	//other users are not affected
+	if _, err := BeginSession(key, store, &ownedState{"user2"}, httptest.NewRecorder()); err != nil {
+		t.Errorf("unexpected error beginning another user's session: %v", err)
+	}
+
+	//rejecting the new session
+	store = NewMemStore(time.Hour, time.Minute)
+	DefaultSessionLimit = SessionLimit{Max: 1, Policy: RejectNew}
+	sid1, _ = BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	if _, err := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder()); err != ErrTooManySessions {
+		t.Errorf("incorrect error beginning a session over the limit with RejectNew: expected %v but got %v", ErrTooManySessions, err)
+	}
+	//once the session ends there's room again
+	store.Delete(sid1)
+	if _, err := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder()); err != nil {
+		t.Errorf("unexpected error beginning a session after the old one ended: %v", err)
+	}
+
+	//racing sessions can't all get in under the limit
+	store = NewMemStore(time.Hour, time.Minute)
+	DefaultSessionLimit = SessionLimit{Max: 2, Policy: RejectNew}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+		}()
+	}
+	wg.Wait()
+	if sids, _ := UserSessions(store, "user1"); len(sids) != 2 {
+		t.Errorf("expected 2 racing sessions to begin but got %d", len(sids))
+	}
+}
+
+func TestPendingSession(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	defer func() {
+		DefaultSessionLimit = SessionLimit{}
+		hooks = nil
+	}()
+	DefaultSessionLimit = SessionLimit{Max: 1, Policy: EvictOldest}
+	events := []*Event{}
+	AddHook(func(e *Event) { events = append(events, e) })
+
+	sid1, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	pendingID, err := BeginPendingSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("error beginning pending session: %v", err)
+	}
+	//the pending session neither evicts the user's session nor is indexed
+	state := &ownedState{}
+	if err := store.Get(sid1, state); err != nil {
+		t.Errorf("pending session evicted the user's session: %v", err)
+	}
+	if sids, _ := UserSessions(store, "user1"); len(sids) != 1 || sids[0] != sid1 {
+		t.Errorf("user index should only contain %s but has %v", sid1, sids)
+	}
+	if len(events) != 1 {
+		t.Errorf("expected only the first session's event but got %v", events)
+	}
+
+	//completing it counts against the limit like a new session
+	w := httptest.NewRecorder()
+	sid2, err := CompleteSession(key, store, pendingID, &ownedState{"user1"}, w)
+	if err != nil {
+		t.Fatalf("error completing session: %v", err)
+	}
+	if token := w.Header().Get(headerAuthorization); token != schemeBearer+sid2.String() {
+		t.Errorf("incorrect Authorization header after completing: %s", token)
+	}
+	if err := store.Get(pendingID, state); err != ErrStateNotFound {
+		t.Error("pending session still exists after completing it")
+	}
+	if err := store.Get(sid1, state); err != ErrStateNotFound {
+		t.Error("completed session did not evict the oldest one")
+	}
+	if sids, _ := UserSessions(store, "user1"); len(sids) != 1 || sids[0] != sid2 {
+		t.Errorf("user index should only contain %s but has %v", sid2, sids)
+	}
+	if len(events) != 3 || events[2].Type != EventStarted || events[2].Session != sid2.Handle() {
+		t.Errorf("expected a %s event for the completed session but got %v", EventStarted, events)
+	}
+}
+
+func TestDetachedSession(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	defer func() {
+		DefaultSessionLimit = SessionLimit{}
+		hooks = nil
+	}()
+	DefaultSessionLimit = SessionLimit{Max: 1, Policy: RejectNew}
+	events := []*Event{}
+	AddHook(func(e *Event) { events = append(events, e) })
+
+	sid1, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	sid2, err := NewDetachedSession(key, store, &ownedState{"user1"})
+	if err != nil {
+		t.Fatalf("unexpected error beginning a detached session over the limit: %v", err)
+	}
+	state := &ownedState{}
+	if err := store.Get(sid2, state); err != nil || state.UserID != "user1" {
+		t.Errorf("error getting detached session state: %v", err)
+	}
+	if sids, _ := UserSessions(store, "user1"); len(sids) != 1 || sids[0] != sid1 {
+		t.Errorf("user index should only contain %s but has %v", sid1, sids)
+	}
+	if len(events) != 2 || events[1].Type != EventStarted || events[1].Session != sid2.Handle() {
+		t.Errorf("expected a %s event for the detached session but got %v", EventStarted, events)
+	}
+}
+
+func TestEndUserSessions(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	sid1, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	sid2, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	sid3, _ := BeginSession(key, store, &ownedState{"user1"}, httptest.NewRecorder())
+	other, _ := BeginSession(key, store, &ownedState{"user2"}, httptest.NewRecorder())
+
+	if err := EndUserSessions(store, "user1", sid2); err != nil {
+		t.Fatalf("error ending user sessions: %v", err)
+	}
+	for _, sid := range []SessionID{sid1, sid3} {
+		if err := store.Get(sid, &ownedState{}); err != ErrStateNotFound {
+			t.Errorf("session %s was not ended", sid)
+		}
+	}
+	for _, sid := range []SessionID{sid2, other} {
+		if err := store.Get(sid, &ownedState{}); err != nil {
+			t.Errorf("session %s should not have been ended: %v", sid, err)
+		}
+	}
+	if sids, _ := UserSessions(store, "user1"); len(sids) != 1 || sids[0] != sid2 {
+		t.Errorf("expected only %s in the user index but got %v", sid2, sids)
+	}
+}
+
+func TestUserIndexDropsEnded(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	userID := "user1"
+	sid1, _ := BeginSession(key, store, &ownedState{userID}, httptest.NewRecorder())
+	sid2, _ := BeginSession(key, store, &ownedState{userID}, httptest.NewRecorder())
+	//as if the session expired, without the index being told
+	store.Delete(sid1)
+
+	sids, err := UserSessions(store, userID)
+	if err != nil {
+		t.Fatalf("error listing user sessions: %v", err)
+	}
+	if len(sids) != 1 || sids[0] != sid2 {
+		t.Errorf("expected only %s but got %v", sid2, sids)
+	}
+	store.Delete(sid2)
+	if sids, _ := UserSessions(store, userID); len(sids) != 0 {
+		t.Errorf("expected no sessions but got %v", sids)
+	}
+	if _, exists := store.users[userID]; exists {
+		t.Errorf("expected the empty index entry to be removed")
+	}
+}
@@ -0,0 +1,65 @@
+package sessions
+
+import (
+	"errors"
+)
+
+//ErrTooManySessions is returned from BeginSession when the user already
+//has the maximum number of sessions and the limit rejects new ones
+var ErrTooManySessions = errors.New("too many active sessions for this user")
+
+//LimitPolicy decides what happens when a user who already has the
+//maximum number of sessions begins another one
+type LimitPolicy int
+
+const (
+	//EvictOldest ends the user's oldest sessions to make room
+	EvictOldest LimitPolicy = iota
+	//RejectNew refuses to begin the new session
+	RejectNew
+)
+
+//SessionLimit caps how many sessions a user may have at once
+type SessionLimit struct {
+	//Max is the most sessions a user may have; 0 means no limit
+	Max    int
+	Policy LimitPolicy
+}
+
+//DefaultSessionLimit is enforced by BeginSession for session states
+//that are an Owner, using the store's UserIndex. Set it once during
+//startup, before serving requests.
+var DefaultSessionLimit SessionLimit
+
+//admit adds `sid` to the store's UserIndex, if `sessionState` has an
+//owner and the store has an index, and enforces the limit in the same
+//atomic step, so racing sign-ins can't all count the sessions before
+//any of them is added and get past the limit together. The sessions it
+//evicts are then ended.
+func (sl SessionLimit) admit(store Store, sid SessionID, sessionState interface{}) error {
+	owner, ok := sessionState.(Owner)
+	if !ok || len(owner.SessionOwner()) == 0 {
+		return nil
+	}
+	index, err := userIndexOf(store)
+	if err != nil {
+		return nil
+	}
+	userID := owner.SessionOwner()
+	if sl.Max > 0 {
+		//so sessions that expired since they were added don't count
+		if _, err := index.UserSessions(userID); err != nil {
+			return err
+		}
+	}
+	evicted, err := index.AddLimitedUserSession(userID, sid, sl)
+	if err != nil {
+		return err
+	}
+	for _, old := range evicted {
+		if err := DeleteSession(store, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+//lruEntry is a cached value along with when it stops being valid
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+//lruCache is a fixed size, least-recently-used cache of byte slices
+//whose entries also expire after a TTL. It is safe for concurrent use.
+type lruCache struct {
+	maxEntries int
+	ttl        time.Duration
+	order      *list.List
+	entries    map[string]*list.Element
+	mx         sync.Mutex
+}
+
+//newLRUCache constructs an lruCache holding at most `maxEntries`
+//entries, each valid for `ttl`
+func newLRUCache(maxEntries int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+//get returns the value cached for `key`, if it hasn't expired
+func (c *lruCache) get(key string) ([]byte, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+//set caches `value` for `key`, evicting the least recently
+//used entry if the cache is full
+func (c *lruCache) set(key string, value []byte) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if elem, found := c.entries[key]; found {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{
+		key:     key,
+		value:   value,
+		expires: expires,
+	})
+	if c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+}
+
+//remove evicts `key` from the cache
+func (c *lruCache) remove(key string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if elem, found := c.entries[key]; found {
+		c.removeElement(elem)
+	}
+}
+
+//removeElement removes `elem`; the caller must hold the lock
+func (c *lruCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).key)
+}
@@ -2,6 +2,8 @@ package sessions
 
 import (
 	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -11,13 +13,19 @@ import (
 //This should be used only for testing and prototyping.
 //Production systems should use a shared server store like redis
 type MemStore struct {
-	entries *cache.Cache
+	entries         *cache.Cache
+	sessionDuration time.Duration
+	//users maps user IDs to their SessionIDs and when each was added
+	users map[string]map[SessionID]time.Time
+	mx    sync.Mutex
 }
 
 //NewMemStore constructs and returns a new MemStore
 func NewMemStore(sessionDuration time.Duration, purgeInterval time.Duration) *MemStore {
 	return &MemStore{
-		entries: cache.New(sessionDuration, purgeInterval),
+		entries:         cache.New(sessionDuration, purgeInterval),
+		sessionDuration: sessionDuration,
+		users:           make(map[string]map[SessionID]time.Time),
 	}
 }
 
@@ -29,19 +37,21 @@ func (ms *MemStore) Save(sid SessionID, state interface{}) error {
 	if nil != err {
 		return err
 	}
-	ms.entries.Set(sid.String(), j, cache.DefaultExpiration)
+	ms.entries.Set(sid.String(), j, durationOf(state, ms.sessionDuration))
 	return nil
 }
 
 //Get populates `sessionState` with the data previously saved
 //for the given SessionID
 func (ms *MemStore) Get(sid SessionID, state interface{}) error {
-	j, found := ms.entries.Get(sid.String())
+	j, expires, found := ms.entries.GetWithExpiration(sid.String())
 	if !found {
 		return ErrStateNotFound
 	}
-	//reset TTL
-	ms.entries.Set(sid.String(), j, 0)
+	//reset TTL, without shortening Durable sessions
+	if time.Until(expires) < ms.sessionDuration {
+		ms.entries.Set(sid.String(), j, cache.DefaultExpiration)
+	}
 	return json.Unmarshal(j.([]byte), state)
 }
 
@@ -50,3 +60,124 @@ func (ms *MemStore) Delete(sid SessionID) error {
 	ms.entries.Delete(sid.String())
 	return nil
 }
+
+//AtomicStore implementation
+
+//Take populates `sessionState` with the data saved for the SessionID
+//and deletes it in one step, so only one caller can take it
+func (ms *MemStore) Take(sid SessionID, state interface{}) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	j, found := ms.entries.Get(sid.String())
+	if !found {
+		return ErrStateNotFound
+	}
+	ms.entries.Delete(sid.String())
+	return json.Unmarshal(j.([]byte), state)
+}
+
+//Claim saves `sessionState` for the SessionID only if nothing is
+//saved for it yet, and reports whether it was saved
+func (ms *MemStore) Claim(sid SessionID, state interface{}) (bool, error) {
+	j, err := json.Marshal(state)
+	if err != nil {
+		return false, err
+	}
+	//Add fails if the key exists, and is atomic
+	return ms.entries.Add(sid.String(), j, durationOf(state, ms.sessionDuration)) == nil, nil
+}
+
+//UpdateStore implementation
+
+//Update saves `sessionState` for the SessionID only if state is
+//already saved for it, and returns ErrStateNotFound if it isn't
+func (ms *MemStore) Update(sid SessionID, state interface{}) error {
+	j, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	//Replace fails if the key doesn't exist, and is atomic
+	if err := ms.entries.Replace(sid.String(), j, durationOf(state, ms.sessionDuration)); err != nil {
+		return ErrStateNotFound
+	}
+	return nil
+}
+
+//UserIndex implementation
+
+//AddUserSession records that `sid` belongs to `userID`
+func (ms *MemStore) AddUserSession(userID string, sid SessionID) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	if _, exists := ms.users[userID]; !exists {
+		ms.users[userID] = make(map[SessionID]time.Time)
+	}
+	ms.users[userID][sid] = time.Now()
+	return nil
+}
+
+//AddLimitedUserSession records that `sid` belongs to `userID` and
+//enforces the `limit`, under the same lock
+func (ms *MemStore) AddLimitedUserSession(userID string, sid SessionID, limit SessionLimit) ([]SessionID, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	if _, exists := ms.users[userID]; !exists {
+		ms.users[userID] = make(map[SessionID]time.Time)
+	}
+	added := ms.users[userID]
+	added[sid] = time.Now()
+	over := len(added) - limit.Max
+	if limit.Max <= 0 || over <= 0 {
+		return nil, nil
+	}
+	if limit.Policy == RejectNew {
+		delete(added, sid)
+		return nil, ErrTooManySessions
+	}
+	sids := make([]SessionID, 0, len(added))
+	for other := range added {
+		sids = append(sids, other)
+	}
+	sort.Slice(sids, func(i, j int) bool {
+		return added[sids[i]].Before(added[sids[j]])
+	})
+	evicted := sids[:over]
+	for _, old := range evicted {
+		delete(added, old)
+	}
+	return evicted, nil
+}
+
+//RemoveUserSession forgets that `sid` belongs to `userID`
+func (ms *MemStore) RemoveUserSession(userID string, sid SessionID) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	delete(ms.users[userID], sid)
+	if len(ms.users[userID]) == 0 {
+		delete(ms.users, userID)
+	}
+	return nil
+}
+
+//UserSessions returns the SessionIDs recorded for `userID`, oldest
+//first, removing those whose sessions have expired
+func (ms *MemStore) UserSessions(userID string) ([]SessionID, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	added := ms.users[userID]
+	sids := make([]SessionID, 0, len(added))
+	for sid := range added {
+		if _, found := ms.entries.Get(sid.String()); !found {
+			delete(added, sid)
+			continue
+		}
+		sids = append(sids, sid)
+	}
+	if len(added) == 0 {
+		delete(ms.users, userID)
+	}
+	sort.Slice(sids, func(i, j int) bool {
+		return added[sids[i]].Before(added[sids[j]])
+	})
+	return sids, nil
+}
@@ -3,6 +3,7 @@ package sessions
 import (
 	"encoding/json"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -75,3 +76,92 @@ func TestMemStoreSaveUnmarshalble(t *testing.T) {
 		t.Error("expected error when attempting to save a session state with an unmarshalable field")
 	}
 }
+
+//succeedConcurrently calls `f` from `n` goroutines at once and
+//returns how many of the calls succeeded
+func succeedConcurrently(n int, f func() error) int {
+	wg := sync.WaitGroup{}
+	mx := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if f() == nil {
+				mx.Lock()
+				succeeded++
+				mx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return succeeded
+}
+
+func TestMemStoreAtomic(t *testing.T) {
+	store := NewMemStore(time.Hour, time.Minute)
+	sid, _ := NewSessionID("test key")
+
+	var state string
+	if err := store.Take(sid, &state); err != ErrStateNotFound {
+		t.Errorf("incorrect error taking state that was never stored: expected %v but got %v", ErrStateNotFound, err)
+	}
+	claimed := succeedConcurrently(10, func() error {
+		if ok, err := store.Claim(sid, "claimed"); !ok || err != nil {
+			return ErrStateNotFound
+		}
+		return nil
+	})
+	if claimed != 1 {
+		t.Errorf("expected exactly one claim to succeed but %d did", claimed)
+	}
+	taken := succeedConcurrently(10, func() error {
+		var state string
+		return store.Take(sid, &state)
+	})
+	if taken != 1 {
+		t.Errorf("expected exactly one take to succeed but %d did", taken)
+	}
+	if err := store.Get(sid, &state); err != ErrStateNotFound {
+		t.Errorf("state still exists after being taken")
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	type sessionState struct {
+		Sval string
+	}
+	mem := NewMemStore(time.Hour, time.Minute)
+	encrypted, _ := NewEncryptedStore(NewMemStore(time.Hour, time.Minute), testEncKey)
+	cached := NewCachedStore(NewMemStore(time.Hour, time.Minute), nil, 10, time.Hour)
+	cases := []struct {
+		name  string
+		store Store
+	}{
+		{"MemStore", mem},
+		{"EncryptedStore Wrapping MemStore", encrypted},
+		{"CachedStore Wrapping MemStore", cached},
+	}
+	for _, c := range cases {
+		sid, _ := NewSessionID("test key")
+		if err := UpdateState(c.store, sid, &sessionState{"new"}); err != ErrStateNotFound {
+			t.Errorf("case %s: expected %v updating a session that was never saved but got %v", c.name, ErrStateNotFound, err)
+		}
+		c.store.Save(sid, &sessionState{"old"})
+		if err := UpdateState(c.store, sid, &sessionState{"new"}); err != nil {
+			t.Errorf("case %s: error updating a saved session: %v", c.name, err)
+		}
+		state := &sessionState{}
+		if err := c.store.Get(sid, state); err != nil || state.Sval != "new" {
+			t.Errorf("case %s: expected the updated state but got %v, %v", c.name, state, err)
+		}
+		//a session that ended isn't brought back
+		c.store.Delete(sid)
+		if err := UpdateState(c.store, sid, &sessionState{"newer"}); err != ErrStateNotFound {
+			t.Errorf("case %s: expected %v updating an ended session but got %v", c.name, ErrStateNotFound, err)
+		}
+		if err := c.store.Get(sid, state); err != ErrStateNotFound {
+			t.Errorf("case %s: an ended session was saved again", c.name)
+		}
+	}
+}
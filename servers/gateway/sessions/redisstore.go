@@ -9,14 +9,15 @@ import (
 
 //RedisStore represents a session.Store backed by redis.
 type RedisStore struct {
-	//Redis client used to talk to redis server.
-	Client *redis.Client
+	//Redis client used to talk to redis server. This can be a single
+	//server client, a Sentinel failover client or a Cluster client.
+	Client redis.UniversalClient
 	//Used for key expiry time on redis.
 	SessionDuration time.Duration
 }
 
 //NewRedisStore constructs a new RedisStore
-func NewRedisStore(client *redis.Client, sessionDuration time.Duration) *RedisStore {
+func NewRedisStore(client redis.UniversalClient, sessionDuration time.Duration) *RedisStore {
 	store := &RedisStore{
 		Client:          client,
 		SessionDuration: sessionDuration,
@@ -36,23 +37,32 @@ func (rs *RedisStore) Save(sid SessionID, sessionState interface{}) error {
 		return err
 	}
 	key := sid.getRedisKey()
-	_, err1 := rs.Client.Set(key, jsonVal, rs.SessionDuration).Result()
+	_, err1 := rs.Client.Set(key, jsonVal, durationOf(sessionState, rs.SessionDuration)).Result()
 	if err1 != nil {
 		return err1
 	}
 	return nil
 }
 
+//getAndExtend gets a key and extends its expiry to at least ARGV[1]
+//seconds in a single round trip, so Durable sessions aren't shortened
+var getAndExtend = redis.NewScript(`
+local val = redis.call("GET", KEYS[1])
+if val and redis.call("TTL", KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call("EXPIRE", KEYS[1], ARGV[1])
+end
+return val`)
+
 //Get populates `sessionState` with the data previously saved
-//for the given SessionID
+//for the given SessionID. The GET and the EXPIRE that resets the
+//session duration are run by a script in a single round trip.
 func (rs *RedisStore) Get(sid SessionID, sessionState interface{}) error {
-	result, err := rs.Client.Get(sid.getRedisKey()).Result()
+	key := sid.getRedisKey()
+	result, err := getAndExtend.Run(rs.Client, []string{key}, int64(rs.SessionDuration/time.Second)).String()
 	if err != nil {
 		return ErrStateNotFound
 	}
-	json.Unmarshal([]byte(result), &sessionState)
-	rs.Client.Expire(sid.getRedisKey(), rs.SessionDuration)
-	return nil
+	return json.Unmarshal([]byte(result), &sessionState)
 }
 
 //Delete deletes all state data associated with the SessionID from the store.
@@ -64,6 +74,164 @@ func (rs *RedisStore) Delete(sid SessionID) error {
 	return nil
 }
 
+//AtomicStore implementation
+
+//getAndDelete gets a key and deletes it in a single step, for redis
+//servers older than 6.2, which have no GETDEL
+var getAndDelete = redis.NewScript(`
+local val = redis.call("GET", KEYS[1])
+if val then
+	redis.call("DEL", KEYS[1])
+end
+return val`)
+
+//Take populates `sessionState` with the data saved for the SessionID
+//and deletes it in one step, so only one caller can take it
+func (rs *RedisStore) Take(sid SessionID, sessionState interface{}) error {
+	result, err := getAndDelete.Run(rs.Client, []string{sid.getRedisKey()}).String()
+	if err != nil {
+		return ErrStateNotFound
+	}
+	return json.Unmarshal([]byte(result), sessionState)
+}
+
+//Claim saves `sessionState` for the SessionID with SETNX, so it is
+//only saved if nothing is saved for it yet, and reports whether it was
+func (rs *RedisStore) Claim(sid SessionID, sessionState interface{}) (bool, error) {
+	jsonVal, err := json.Marshal(sessionState)
+	if err != nil {
+		return false, err
+	}
+	return rs.Client.SetNX(sid.getRedisKey(), jsonVal, durationOf(sessionState, rs.SessionDuration)).Result()
+}
+
+//UpdateStore implementation
+
+//Update saves `sessionState` for the SessionID with SET XX, so it is
+//only saved if state is already saved for it, and returns
+//ErrStateNotFound if it isn't
+func (rs *RedisStore) Update(sid SessionID, sessionState interface{}) error {
+	jsonVal, err := json.Marshal(sessionState)
+	if err != nil {
+		return err
+	}
+	saved, err := rs.Client.SetXX(sid.getRedisKey(), jsonVal, durationOf(sessionState, rs.SessionDuration)).Result()
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return ErrStateNotFound
+	}
+	return nil
+}
+
+//UserIndex implementation
+
+//AddUserSession records that `sid` belongs to `userID`. The index is
+//a sorted set scored by when each session was added. It doesn't expire,
+//since sessions slide and may outlive the one added last; UserSessions
+//drops the members whose sessions have ended instead.
+func (rs *RedisStore) AddUserSession(userID string, sid SessionID) error {
+	return rs.Client.ZAdd(getUserRedisKey(userID), redis.Z{
+		Score:  float64(time.Now().UnixNano()),
+		Member: sid.String(),
+	}).Err()
+}
+
+//addLimited adds ARGV[2] with the score ARGV[1] to the sorted set
+//KEYS[1], then if it has more than ARGV[3] members either removes the
+//oldest and returns them, or, if ARGV[4] is "reject", removes ARGV[2]
+//again and returns nil
+var addLimited = redis.NewScript(`
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[2])
+local max = tonumber(ARGV[3])
+local over = redis.call("ZCARD", KEYS[1]) - max
+if max <= 0 or over <= 0 then
+	return {}
+end
+if ARGV[4] == "reject" then
+	redis.call("ZREM", KEYS[1], ARGV[2])
+	return false
+end
+local evicted = redis.call("ZRANGE", KEYS[1], 0, over - 1)
+redis.call("ZREMRANGEBYRANK", KEYS[1], 0, over - 1)
+return evicted
+`)
+
+//AddLimitedUserSession records that `sid` belongs to `userID` and
+//enforces the `limit` in one script, so it is atomic
+func (rs *RedisStore) AddLimitedUserSession(userID string, sid SessionID, limit SessionLimit) ([]SessionID, error) {
+	policy := "evict"
+	if limit.Policy == RejectNew {
+		policy = "reject"
+	}
+	res, err := addLimited.Run(rs.Client, []string{getUserRedisKey(userID)},
+		time.Now().UnixNano(), sid.String(), limit.Max, policy).Result()
+	if err == redis.Nil {
+		return nil, ErrTooManySessions
+	}
+	if err != nil {
+		return nil, err
+	}
+	members, _ := res.([]interface{})
+	evicted := make([]SessionID, 0, len(members))
+	for _, member := range members {
+		if s, ok := member.(string); ok {
+			evicted = append(evicted, SessionID(s))
+		}
+	}
+	return evicted, nil
+}
+
+//RemoveUserSession forgets that `sid` belongs to `userID`
+func (rs *RedisStore) RemoveUserSession(userID string, sid SessionID) error {
+	return rs.Client.ZRem(getUserRedisKey(userID), sid.String()).Err()
+}
+
+//UserSessions returns the SessionIDs recorded for `userID`, oldest first,
+//removing those whose sessions have expired. The session keys are checked
+//with EXISTS so listing them doesn't extend them; they are on other
+//cluster slots than the index, so this can't be a single script.
+func (rs *RedisStore) UserSessions(userID string) ([]SessionID, error) {
+	key := getUserRedisKey(userID)
+	members, err := rs.Client.ZRange(key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := rs.Client.Pipeline()
+	exists := make([]*redis.IntCmd, len(members))
+	for i, member := range members {
+		exists[i] = pipe.Exists(SessionID(member).getRedisKey())
+	}
+	if len(members) > 0 {
+		if _, err := pipe.Exec(); err != nil {
+			return nil, err
+		}
+	}
+	sids := make([]SessionID, 0, len(members))
+	for i, member := range members {
+		if exists[i].Val() == 0 {
+			rs.Client.ZRem(key, member)
+			continue
+		}
+		sids = append(sids, SessionID(member))
+	}
+	return sids, nil
+}
+
+//getUserRedisKey returns the redis key of the sorted set of SessionIDs for a user
+func getUserRedisKey(userID string) string {
+	return "usid:" + userHashTag(userID)
+}
+
+//userHashTag wraps the user ID in a redis Cluster hash tag. Only the
+//part of a key inside the braces is hashed, so every key built with
+//the same user's tag lands on the same cluster slot and can be used
+//together in a transaction or pipeline.
+func userHashTag(userID string) string {
+	return "{" + userID + "}"
+}
+
 //getRedisKey() returns the redis key to use for the SessionID
 func (sid SessionID) getRedisKey() string {
 	//convert the SessionID to a string and add the prefix "sid:" to keep
@@ -3,6 +3,7 @@ package sessions
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -81,3 +82,14 @@ func TestRedisStore(t *testing.T) {
 		t.Fatalf("incorrect error when getting state that was deleted: expected %v but got %v", ErrStateNotFound, err)
 	}
 }
+
+func TestUserRedisKey(t *testing.T) {
+	//all of a user's keys must share a cluster hash tag
+	key := getUserRedisKey("1234")
+	if !strings.Contains(key, "{1234}") {
+		t.Errorf("user key %s does not contain the hash tag {1234}", key)
+	}
+	if strings.Count(key, "{") != 1 {
+		t.Errorf("user key %s should contain exactly one hash tag", key)
+	}
+}
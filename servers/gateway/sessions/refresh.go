@@ -0,0 +1,172 @@
+package sessions
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrInvalidRefreshToken is returned when a refresh token is malformed,
+//unknown, expired, or belongs to a revoked family
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+//ErrRefreshTokenReused is returned when a refresh token that was already
+//redeemed is presented again. This means the token was probably stolen,
+//so its whole family has been revoked.
+var ErrRefreshTokenReused = errors.New("refresh token was already used; all tokens for this sign-in have been revoked")
+
+//refreshToken is the state saved for each refresh token
+type refreshToken struct {
+	FamilyID SessionID
+}
+
+//usedID returns the key claimed when a refresh token is redeemed. Only
+//one redemption can claim it, so racing redemptions count as reuse.
+func usedID(tokenID SessionID) SessionID {
+	return SessionID("used:" + tokenID)
+}
+
+//refreshFamily is the state saved for a chain of refresh tokens that
+//descend from a single sign-in. Each redemption adds a session and
+//replaces Current with a new token.
+type refreshFamily struct {
+	UserID   string
+	Current  SessionID
+	Sessions []SessionID
+	Revoked  bool
+	IssuedAt time.Time
+}
+
+//userRevocation is the state saved when all of a user's refresh
+//tokens are revoked, e.g. after a password reset
+type userRevocation struct {
+	Before time.Time
+}
+
+//userRevocationID returns the key a user's revocation is saved under
+func userRevocationID(userID string) SessionID {
+	return SessionID("user:" + userID)
+}
+
+//RefreshTokens issues and redeems long-lived, single-use refresh tokens.
+//Redeeming a token rotates it: the client gets a new token and the old
+//one becomes invalid. Refresh tokens are signed like SessionIDs but with
+//a key derived from the session signing key, so one can never be used
+//in place of the other. The store should be an AtomicStore, so that of
+//two requests racing with the same token only one succeeds.
+type RefreshTokens struct {
+	signingKey string
+	//store holds the token and family state; its duration is the
+	//refresh token lifetime
+	store Store
+	//sessions is the session store, so a revoked family's sessions can be ended
+	sessions Store
+}
+
+//NewRefreshTokens constructs a new RefreshTokens. The `store` should keep
+//state for as long as refresh tokens are meant to last, e.g. a RedisStore
+//with a duration of several weeks. `sessionStore` is the regular store
+//for session state.
+func NewRefreshTokens(signingKey string, store Store, sessionStore Store) *RefreshTokens {
+	return &RefreshTokens{
+		signingKey: signingKey + ":refresh",
+		store:      store,
+		sessions:   sessionStore,
+	}
+}
+
+//Issue starts a new family of refresh tokens for the user, whose first
+//session is `sid`, and returns the first refresh token
+func (rt *RefreshTokens) Issue(userID string, sid SessionID) (SessionID, error) {
+	familyID, err := NewSessionID(rt.signingKey)
+	if err != nil {
+		return InvalidSessionID, err
+	}
+	family := &refreshFamily{
+		UserID:   userID,
+		Sessions: []SessionID{sid},
+		IssuedAt: time.Now(),
+	}
+	return rt.next(familyID, family)
+}
+
+//Redeem validates and uses up the refresh token, returning the ID of the
+//user it was issued to and the family it belongs to. Pass the family to
+//Rotate once the new session has begun. If the token was already used,
+//the family is revoked, its sessions are ended and ErrRefreshTokenReused
+//is returned.
+func (rt *RefreshTokens) Redeem(token string) (string, SessionID, error) {
+	tokenID, err := ValidateID(token, rt.signingKey)
+	if err != nil {
+		return "", InvalidSessionID, ErrInvalidRefreshToken
+	}
+	state := &refreshToken{}
+	if err := rt.store.Get(tokenID, state); err != nil {
+		return "", InvalidSessionID, ErrInvalidRefreshToken
+	}
+	family := &refreshFamily{}
+	if err := rt.store.Get(state.FamilyID, family); err != nil || family.Revoked {
+		return "", InvalidSessionID, ErrInvalidRefreshToken
+	}
+	revocation := &userRevocation{}
+	if err := rt.store.Get(userRevocationID(family.UserID), revocation); err == nil && !family.IssuedAt.After(revocation.Before) {
+		return "", InvalidSessionID, ErrInvalidRefreshToken
+	}
+	if family.Current != tokenID {
+		rt.revoke(state.FamilyID, family)
+		return "", InvalidSessionID, ErrRefreshTokenReused
+	}
+	claimed, err := claim(rt.store, usedID(tokenID), time.Now())
+	if err != nil {
+		return "", InvalidSessionID, err
+	}
+	if !claimed {
+		rt.revoke(state.FamilyID, family)
+		return "", InvalidSessionID, ErrRefreshTokenReused
+	}
+	return family.UserID, state.FamilyID, nil
+}
+
+//Rotate records that `sid` was begun by redeeming a token from the
+//family, and returns the family's next refresh token
+func (rt *RefreshTokens) Rotate(familyID SessionID, sid SessionID) (SessionID, error) {
+	family := &refreshFamily{}
+	if err := rt.store.Get(familyID, family); err != nil || family.Revoked {
+		return InvalidSessionID, ErrInvalidRefreshToken
+	}
+	family.Sessions = append(family.Sessions, sid)
+	return rt.next(familyID, family)
+}
+
+//next creates a new token in the family and makes it the current one
+func (rt *RefreshTokens) next(familyID SessionID, family *refreshFamily) (SessionID, error) {
+	tokenID, err := NewSessionID(rt.signingKey)
+	if err != nil {
+		return InvalidSessionID, err
+	}
+	if err := rt.store.Save(tokenID, &refreshToken{FamilyID: familyID}); err != nil {
+		return InvalidSessionID, err
+	}
+	family.Current = tokenID
+	if err := rt.store.Save(familyID, family); err != nil {
+		return InvalidSessionID, err
+	}
+	return tokenID, nil
+}
+
+//RevokeUser invalidates every refresh token issued to the user so far,
+//e.g. when their password is reset. It doesn't end their sessions.
+func (rt *RefreshTokens) RevokeUser(userID string) error {
+	return rt.store.Save(userRevocationID(userID), &userRevocation{Before: time.Now()})
+}
+
+//revoke marks the family revoked, deletes its current token and
+//ends every session it minted
+func (rt *RefreshTokens) revoke(familyID SessionID, family *refreshFamily) {
+	family.Revoked = true
+	rt.store.Save(familyID, family)
+	rt.store.Delete(family.Current)
+	for _, sid := range family.Sessions {
+		DeleteSession(rt.sessions, sid)
+		RemoveUserSession(rt.sessions, family.UserID, sid)
+	}
+}
@@ -0,0 +1,118 @@
+package sessions
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	key := "test key"
+	sessionStore := NewMemStore(time.Hour, time.Minute)
+	rt := NewRefreshTokens(key, NewMemStore(time.Hour, time.Minute), sessionStore)
+
+	sid1, _ := NewSessionID(key)
+	sessionStore.Save(sid1, 1)
+	token1, err := rt.Issue("user1", sid1)
+	if err != nil {
+		t.Fatalf("error issuing refresh token: %v", err)
+	}
+
+	//a refresh token is not a SessionID and vice versa
+	if _, err := ValidateID(token1.String(), key); err == nil {
+		t.Error("refresh token validated as a SessionID")
+	}
+	if _, _, err := rt.Redeem(sid1.String()); err != ErrInvalidRefreshToken {
+		t.Errorf("incorrect error redeeming a SessionID: expected %v but got %v", ErrInvalidRefreshToken, err)
+	}
+
+	userID, familyID, err := rt.Redeem(token1.String())
+	if err != nil {
+		t.Fatalf("error redeeming refresh token: %v", err)
+	}
+	if userID != "user1" {
+		t.Errorf("incorrect user ID: expected user1 but got %s", userID)
+	}
+	sid2, _ := NewSessionID(key)
+	sessionStore.Save(sid2, 2)
+	token2, err := rt.Rotate(familyID, sid2)
+	if err != nil {
+		t.Fatalf("error rotating refresh token: %v", err)
+	}
+	if token2 == token1 {
+		t.Error("rotated refresh token is the same as the old one")
+	}
+
+	//presenting the old token again revokes the whole family
+	if _, _, err := rt.Redeem(token1.String()); err != ErrRefreshTokenReused {
+		t.Errorf("incorrect error reusing a refresh token: expected %v but got %v", ErrRefreshTokenReused, err)
+	}
+	if _, _, err := rt.Redeem(token2.String()); err != ErrInvalidRefreshToken {
+		t.Errorf("incorrect error redeeming a token from a revoked family: expected %v but got %v", ErrInvalidRefreshToken, err)
+	}
+	var state int
+	for _, sid := range []SessionID{sid1, sid2} {
+		if err := sessionStore.Get(sid, &state); err != ErrStateNotFound {
+			t.Errorf("session %s minted by a revoked family was not ended", sid)
+		}
+	}
+	if _, err := rt.Rotate(familyID, sid2); err != ErrInvalidRefreshToken {
+		t.Errorf("incorrect error rotating a revoked family: expected %v but got %v", ErrInvalidRefreshToken, err)
+	}
+}
+
+func TestRefreshTokensRevokeUser(t *testing.T) {
+	key := "test key"
+	sessionStore := NewMemStore(time.Hour, time.Minute)
+	rt := NewRefreshTokens(key, NewMemStore(time.Hour, time.Minute), sessionStore)
+
+	sid, _ := NewSessionID(key)
+	token, _ := rt.Issue("user1", sid)
+	otherToken, _ := rt.Issue("user2", sid)
+	time.Sleep(time.Millisecond)
+	if err := rt.RevokeUser("user1"); err != nil {
+		t.Fatalf("error revoking user: %v", err)
+	}
+	if _, _, err := rt.Redeem(token.String()); err != ErrInvalidRefreshToken {
+		t.Errorf("incorrect error redeeming a revoked user's token: expected %v but got %v", ErrInvalidRefreshToken, err)
+	}
+	if _, _, err := rt.Redeem(otherToken.String()); err != nil {
+		t.Errorf("error redeeming another user's token: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	newToken, _ := rt.Issue("user1", sid)
+	if _, _, err := rt.Redeem(newToken.String()); err != nil {
+		t.Errorf("error redeeming a token issued after revocation: %v", err)
+	}
+}
+
+func TestRefreshTokensRedeemOnce(t *testing.T) {
+	key := "test key"
+	sessionStore := NewMemStore(time.Hour, time.Minute)
+	rt := NewRefreshTokens(key, NewMemStore(time.Hour, time.Minute), sessionStore)
+	sid, _ := NewSessionID(key)
+	sessionStore.Save(sid, 1)
+	token, _ := rt.Issue("user1", sid)
+
+	mx := sync.Mutex{}
+	reused := 0
+	redeemed := succeedConcurrently(20, func() error {
+		_, _, err := rt.Redeem(token.String())
+		if err == ErrRefreshTokenReused {
+			mx.Lock()
+			reused++
+			mx.Unlock()
+		}
+		return err
+	})
+	if redeemed != 1 {
+		t.Errorf("expected a refresh token to be redeemed once but it was redeemed %d times", redeemed)
+	}
+	if reused == 0 {
+		t.Errorf("expected racing redemptions to be treated as reuse")
+	}
+	var state int
+	if err := sessionStore.Get(sid, &state); err != ErrStateNotFound {
+		t.Errorf("reuse did not end the family's sessions")
+	}
+}
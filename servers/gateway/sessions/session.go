@@ -2,9 +2,7 @@ package sessions
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
-	"strings"
 )
 
 const headerAuthorization = "Authorization"
@@ -17,32 +15,135 @@ var ErrNoSessionID = errors.New("no session ID found in " + headerAuthorization
 //ErrInvalidScheme is used when the authorization scheme is not supported
 var ErrInvalidScheme = errors.New("authorization scheme not supported")
 
-//BeginSession creates a new SessionID, saves the `sessionState` to the store, adds an
-//Authorization header to the response with the SessionID, and returns the new SessionID
+//BeginSession creates a new SessionID, saves the `sessionState` to the store, adds the
+//SessionID to the response using the DefaultTransport (an Authorization header unless
+//configured otherwise), and returns the new SessionID. If the `sessionState` is an Owner
+//the session is also added to the store's UserIndex, and the DefaultSessionLimit is
+//enforced, which either ends the user's oldest sessions or returns ErrTooManySessions.
+//Registered hooks are sent an EventStarted.
 func BeginSession(signingKey string, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	sessionID, err := NewSession(signingKey, store, sessionState)
+	if err != nil {
+		return InvalidSessionID, err
+	}
+	DefaultTransport.WriteSessionID(w, sessionID, signingKey)
+	return sessionID, nil
+}
+
+//NewSession begins a session like BeginSession, but doesn't add the
+//SessionID to a response. Use it to hand a session to someone other
+//than the client making the request, e.g. a third-party integration.
+func NewSession(signingKey string, store Store, sessionState interface{}) (SessionID, error) {
 	sessionID, err := NewSessionID(signingKey)
 	if err != nil {
 		return InvalidSessionID, ErrNoSessionID
 	}
-	store.Save(sessionID, sessionState)
-	w.Header().Add(headerAuthorization, schemeBearer+sessionID.String())
+	if err := store.Save(sessionID, sessionState); err != nil {
+		return InvalidSessionID, err
+	}
+	if err := DefaultSessionLimit.admit(store, sessionID, sessionState); err != nil {
+		store.Delete(sessionID)
+		return InvalidSessionID, err
+	}
+	fire(EventStarted, sessionID, sessionState)
 	return sessionID, nil
 }
 
-//GetSessionID extracts and validates the SessionID from the request headers
-func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
-	authHeader := r.Header.Get(headerAuthorization)
-	if len(authHeader) == 0 {
-		authHeader = r.URL.Query().Get("auth")
+//NewDetachedSession begins a session like NewSession, but doesn't add it
+//to the store's UserIndex or count it against the DefaultSessionLimit. Use
+//it for a session someone else holds as the user, e.g. an admin
+//impersonating them, which mustn't crowd out the user's own sessions.
+//Registered hooks are still sent an EventStarted.
+func NewDetachedSession(signingKey string, store Store, sessionState interface{}) (SessionID, error) {
+	sessionID, err := NewSessionID(signingKey)
+	if err != nil {
+		return InvalidSessionID, ErrNoSessionID
+	}
+	if err := store.Save(sessionID, sessionState); err != nil {
+		return InvalidSessionID, err
+	}
+	fire(EventStarted, sessionID, sessionState)
+	return sessionID, nil
+}
+
+//RotateSession moves the `sessionState` of the session `oldID` to a newly
+//signed SessionID, deletes the old session, adds the new SessionID to
+//the response using the DefaultTransport, and returns it. Call it whenever
+//a session gains privileges or its credentials change, so a SessionID
+//planted or leaked before that point stops working. The new session is
+//saved before the old one is deleted, so the user is never signed out.
+//Registered hooks are sent an EventStarted for the new SessionID, then
+//an EventEnded for the old one.
+func RotateSession(signingKey string, store Store, oldID SessionID, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	sessionID, err := NewSessionID(signingKey)
+	if err != nil {
+		return InvalidSessionID, ErrNoSessionID
+	}
+	if err := store.Save(sessionID, sessionState); err != nil {
+		return InvalidSessionID, err
+	}
+	if err := addOwnedSession(store, sessionID, sessionState); err != nil {
+		return InvalidSessionID, err
+	}
+	fire(EventStarted, sessionID, sessionState)
+	if err := DeleteSession(store, oldID); err != nil {
+		return InvalidSessionID, err
 	}
-	if len(authHeader) == 0 {
+	if owner, ok := sessionState.(Owner); ok {
+		RemoveUserSession(store, owner.SessionOwner(), oldID)
+	}
+	DefaultTransport.WriteSessionID(w, sessionID, signingKey)
+	return sessionID, nil
+}
+
+//BeginPendingSession begins a session that can't be used until it is
+//completed, e.g. one waiting for the user's second factor. It is saved
+//and its SessionID added to the response like BeginSession, but it isn't
+//added to the store's UserIndex, doesn't count against the
+//DefaultSessionLimit, and hooks aren't sent an EventStarted, so pending
+//sessions can't crowd out a user's real ones. End it by deleting it from
+//the store, or move it to a real session with CompleteSession.
+func BeginPendingSession(signingKey string, store Store, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	sessionID, err := NewSessionID(signingKey)
+	if err != nil {
 		return InvalidSessionID, ErrNoSessionID
 	}
-	if !strings.HasPrefix(authHeader, schemeBearer) {
-		return InvalidSessionID, ErrInvalidScheme
+	if err := store.Save(sessionID, sessionState); err != nil {
+		return InvalidSessionID, err
+	}
+	DefaultTransport.WriteSessionID(w, sessionID, signingKey)
+	return sessionID, nil
+}
+
+//CompleteSession moves the `sessionState` of the pending session
+//`pendingID` to a session begun with BeginSession, which enforces the
+//DefaultSessionLimit and sends hooks an EventStarted, then deletes the
+//pending session and returns the new SessionID
+func CompleteSession(signingKey string, store Store, pendingID SessionID, sessionState interface{}, w http.ResponseWriter) (SessionID, error) {
+	sessionID, err := BeginSession(signingKey, store, sessionState, w)
+	if err != nil {
+		return InvalidSessionID, err
+	}
+	if err := store.Delete(pendingID); err != nil {
+		return InvalidSessionID, err
+	}
+	return sessionID, nil
+}
+
+//GetSessionID extracts and validates the SessionID from the request
+//using the DefaultTransport. If DefaultTickets is set, a request without
+//an Authorization header may instead carry a ticket in the `auth` query
+//string parameter, which is redeemed for the SessionID it stands in for.
+func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
+	if DefaultTickets != nil && len(r.Header.Get(headerAuthorization)) == 0 {
+		if ticketID := r.URL.Query().Get(paramAuthorization); len(ticketID) > 0 {
+			return DefaultTickets.Redeem(ticketID, r.URL.Path)
+		}
+	}
+	extractedID, err := DefaultTransport.ReadSessionID(r, signingKey)
+	if err != nil {
+		return InvalidSessionID, err
 	}
-	authTokens := strings.Split(strings.Trim(authHeader, " "), " ")
-	extractedID := authTokens[len(authTokens)-1]
 	sessionID, err := ValidateID(extractedID, signingKey)
 	if err != nil {
 		//return the validation error.
@@ -53,31 +154,45 @@ func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
 
 //GetState extracts the SessionID from the request,
 //gets the associated state from the provided store into
-//the `sessionState` parameter, and returns the SessionID
+//the `sessionState` parameter, and returns the SessionID.
+//If the state is a Client, the request must also match the
+//DefaultBinding: otherwise ErrClientMismatch is returned, or
+//ErrStepUpRequired along with the SessionID and loaded state.
 func GetState(r *http.Request, signingKey string, store Store, sessionState interface{}) (SessionID, error) {
 	sessionID, err := GetSessionID(r, signingKey)
 	if err != nil {
 		return InvalidSessionID, ErrInvalidID
 	}
-	fmt.Println(sessionID.String())
 	errorGet := store.Get(sessionID, &sessionState)
 	if errorGet != nil {
 		return InvalidSessionID, ErrStateNotFound
 	}
+	if err := DefaultBinding.check(r, sessionState); err != nil {
+		if err == ErrStepUpRequired {
+			return sessionID, err
+		}
+		return InvalidSessionID, err
+	}
 	return sessionID, nil
 }
 
 //EndSession extracts the SessionID from the request,
 //and deletes the associated data in the provided store, returning
-//the extracted SessionID.
+//the extracted SessionID. Registered hooks are sent an EventEnded.
 func EndSession(r *http.Request, signingKey string, store Store) (SessionID, error) {
 	sessionID, err := GetSessionID(r, signingKey)
 	if err != nil {
 		return InvalidSessionID, ErrInvalidID
 	}
-	errorDelete := store.Delete(sessionID)
+	errorDelete := DeleteSession(store, sessionID)
 	if errorDelete != nil {
 		return InvalidSessionID, ErrStateNotFound
 	}
 	return sessionID, nil
 }
+
+//ClearSessionID tells the client to forget its SessionID, using the
+//DefaultTransport. Call it after EndSession when responding.
+func ClearSessionID(w http.ResponseWriter) {
+	DefaultTransport.ClearSessionID(w)
+}
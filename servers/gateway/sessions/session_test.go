@@ -166,3 +166,67 @@ func TestSessionCycle(t *testing.T) {
 		t.Error("expected error when attempting to end session with no Authorization header in request")
 	}
 }
+
+func TestRotateSession(t *testing.T) {
+	store := NewMemStore(time.Hour, time.Minute)
+	key := "test key"
+	state := &ownedState{"user1"}
+
+	oldID, err := BeginSession(key, store, state, httptest.NewRecorder())
+	if err != nil {
+		t.Fatalf("error beginning session: %v", err)
+	}
+
+	respRec := httptest.NewRecorder()
+	newID, err := RotateSession(key, store, oldID, state, respRec)
+	if err != nil {
+		t.Fatalf("error rotating session: %v", err)
+	}
+	if newID == oldID {
+		t.Error("rotated SessionID is the same as the old one")
+	}
+	if token := respRec.Header().Get(headerAuthorization); token != schemeBearer+newID.String() {
+		t.Errorf("incorrect Authorization header after rotation: expected %s but got %s", schemeBearer+newID.String(), token)
+	}
+
+	stateRet := &ownedState{}
+	if err := store.Get(oldID, stateRet); err != ErrStateNotFound {
+		t.Error("old session still exists after rotation")
+	}
+	if err := store.Get(newID, stateRet); err != nil {
+		t.Errorf("error getting rotated session state: %v", err)
+	}
+	if stateRet.UserID != state.UserID {
+		t.Errorf("incorrect rotated session state: expected %v but got %v", state, stateRet)
+	}
+
+	sids, _ := UserSessions(store, "user1")
+	if len(sids) != 1 || sids[0] != newID {
+		t.Errorf("user index should only contain %s after rotation but has %v", newID, sids)
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	state := &ownedState{"user1"}
+
+	sid, err := NewSession(key, store, state)
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	if _, err := ValidateID(sid.String(), key); err != nil {
+		t.Errorf("new SessionID does not validate: %v", err)
+	}
+	stateRet := &ownedState{}
+	if err := store.Get(sid, stateRet); err != nil {
+		t.Errorf("error getting new session state: %v", err)
+	}
+	if stateRet.UserID != state.UserID {
+		t.Errorf("incorrect session state: expected %v but got %v", state, stateRet)
+	}
+	sids, _ := UserSessions(store, "user1")
+	if len(sids) != 1 || sids[0] != sid {
+		t.Errorf("user index should contain %s but has %v", sid, sids)
+	}
+}
@@ -65,7 +65,9 @@ func ValidateID(id string, signingKey string) (SessionID, error) {
 		return InvalidSessionID, fmt.Errorf("error signing key should not be empty")
 	}
 	decodedSessionID, err := base64.URLEncoding.DecodeString(id)
-	if err != nil {
+	// any base64 value can reach here, e.g. a cookie, and a short one
+	// would panic when split into its ID and signature below
+	if err != nil || len(decodedSessionID) != signedLength {
 		return InvalidSessionID, ErrInvalidID
 	}
 	hasher := hmac.New(sha256.New, []byte(signingKey))
@@ -128,6 +128,17 @@ func TestValidateID(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"Shorter Than ID Portion",
+			"If the decoded ID is shorter than `signedLength`, it should return an error instead of slicing past its end",
+			"test key",
+			"test key",
+			func(sid SessionID) SessionID {
+				buf, _ := base64.URLEncoding.DecodeString(string(sid))
+				return SessionID(base64.URLEncoding.EncodeToString(buf[0 : idLength-1]))
+			},
+			true,
+		},
 	}
 
 	for _, c := range cases {
@@ -26,3 +26,73 @@ type Store interface {
 	//Delete deletes all state data associated with the SessionID from the store.
 	Delete(sid SessionID) error
 }
+
+//AtomicStore is implemented by stores that can change state in ways
+//that stay correct when several requests race for the same SessionID,
+//like redeeming a single-use token twice at once
+type AtomicStore interface {
+	Store
+
+	//Take populates `sessionState` with the data saved for the SessionID
+	//and deletes it in one step, so only one caller can take it. It
+	//returns ErrStateNotFound if there is nothing to take.
+	Take(sid SessionID, sessionState interface{}) error
+
+	//Claim saves `sessionState` for the SessionID only if nothing is
+	//saved for it yet, and reports whether it was saved
+	Claim(sid SessionID, sessionState interface{}) (bool, error)
+}
+
+//UpdateStore is implemented by stores that can save a session's state
+//only if it still exists, so a request that read the state before the
+//session ended can't bring it back by saving it again
+type UpdateStore interface {
+	Store
+
+	//Update saves `sessionState` for the SessionID only if state is
+	//already saved for it, and returns ErrStateNotFound if it isn't
+	Update(sid SessionID, sessionState interface{}) error
+}
+
+//UpdateState saves `sessionState` for `sid` only if the session still
+//exists, returning ErrStateNotFound if it has ended, e.g. to save a
+//change to a session read earlier in the request. Stores that aren't an
+//UpdateStore fall back to a Get and a Save, which can race.
+func UpdateState(store Store, sid SessionID, sessionState interface{}) error {
+	if updater, ok := store.(UpdateStore); ok {
+		return updater.Update(sid, sessionState)
+	}
+	var existing interface{}
+	if err := store.Get(sid, &existing); err != nil {
+		return err
+	}
+	return store.Save(sid, sessionState)
+}
+
+//take takes the state of `sid` from the store. Stores that aren't an
+//AtomicStore fall back to a Get and a Delete, which can race.
+func take(store Store, sid SessionID, sessionState interface{}) error {
+	if atomic, ok := store.(AtomicStore); ok {
+		return atomic.Take(sid, sessionState)
+	}
+	if err := store.Get(sid, sessionState); err != nil {
+		return err
+	}
+	return store.Delete(sid)
+}
+
+//claim claims `sid` in the store. Stores that aren't an AtomicStore
+//fall back to a Get and a Save, which can race.
+func claim(store Store, sid SessionID, sessionState interface{}) (bool, error) {
+	if atomic, ok := store.(AtomicStore); ok {
+		return atomic.Claim(sid, sessionState)
+	}
+	var existing interface{}
+	if err := store.Get(sid, &existing); err == nil {
+		return false, nil
+	}
+	if err := store.Save(sid, sessionState); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,362 @@
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/patrickmn/go-cache"
+)
+
+//ErrThrottled is returned from Throttle.Check and Throttle.Attempt when
+//too many attempts failed recently and the caller must wait before
+//trying again
+var ErrThrottled = errors.New("too many failed attempts, try again later")
+
+//Failures are the recent failed attempts for a key
+type Failures struct {
+	Count int
+	Last  time.Time
+}
+
+//Attempts counts failed attempts per key, like an email or IP address.
+//A key's failures are forgotten once it goes `window` without one.
+type Attempts interface {
+	//Fail records a failed attempt for the key and returns its failures
+	Fail(key string, window time.Duration) (*Failures, error)
+
+	//Attempt counts an attempt for the key, as a failure, before it is
+	//made and returns the failures before it, in one step, so each of
+	//a burst of racing attempts sees the ones before it
+	Attempt(key string, window time.Duration) (*Failures, error)
+
+	//Release takes back an attempt counted by Attempt that succeeded
+	Release(key string) error
+
+	//Get returns the failures for the key
+	Get(key string) (*Failures, error)
+
+	//Reset forgets the failures for the key
+	Reset(key string) error
+}
+
+//MemAttempts is an in-process Attempts, for testing and prototyping
+type MemAttempts struct {
+	entries *cache.Cache
+	mx      sync.Mutex
+}
+
+//NewMemAttempts constructs and returns a new MemAttempts
+func NewMemAttempts() *MemAttempts {
+	return &MemAttempts{
+		entries: cache.New(time.Hour, time.Minute),
+	}
+}
+
+//Fail records a failed attempt for the key and returns its failures
+func (ma *MemAttempts) Fail(key string, window time.Duration) (*Failures, error) {
+	ma.mx.Lock()
+	defer ma.mx.Unlock()
+	f := Failures{}
+	if prev, found := ma.entries.Get(key); found {
+		f = prev.(Failures)
+	}
+	f.Count++
+	f.Last = time.Now()
+	ma.entries.Set(key, f, window)
+	return &f, nil
+}
+
+//Attempt counts an attempt for the key before it is made
+//and returns the failures before it
+func (ma *MemAttempts) Attempt(key string, window time.Duration) (*Failures, error) {
+	ma.mx.Lock()
+	defer ma.mx.Unlock()
+	prev := Failures{}
+	if found, ok := ma.entries.Get(key); ok {
+		prev = found.(Failures)
+	}
+	f := Failures{Count: prev.Count + 1, Last: time.Now()}
+	ma.entries.Set(key, f, window)
+	return &prev, nil
+}
+
+//Release takes back an attempt counted by Attempt that succeeded
+func (ma *MemAttempts) Release(key string) error {
+	ma.mx.Lock()
+	defer ma.mx.Unlock()
+	found, expires, ok := ma.entries.GetWithExpiration(key)
+	if !ok {
+		return nil
+	}
+	f := found.(Failures)
+	f.Count--
+	if f.Count <= 0 {
+		ma.entries.Delete(key)
+		return nil
+	}
+	ma.entries.Set(key, f, time.Until(expires))
+	return nil
+}
+
+//Get returns the failures for the key
+func (ma *MemAttempts) Get(key string) (*Failures, error) {
+	ma.mx.Lock()
+	defer ma.mx.Unlock()
+	f := Failures{}
+	if prev, found := ma.entries.Get(key); found {
+		f = prev.(Failures)
+	}
+	return &f, nil
+}
+
+//Reset forgets the failures for the key
+func (ma *MemAttempts) Reset(key string) error {
+	ma.entries.Delete(key)
+	return nil
+}
+
+//RedisAttempts is an Attempts backed by redis, so failures
+//count across all gateways
+type RedisAttempts struct {
+	Client redis.UniversalClient
+}
+
+//NewRedisAttempts constructs a new RedisAttempts
+func NewRedisAttempts(client redis.UniversalClient) *RedisAttempts {
+	return &RedisAttempts{
+		Client: client,
+	}
+}
+
+//Fail records a failed attempt for the key and returns its failures
+func (ra *RedisAttempts) Fail(key string, window time.Duration) (*Failures, error) {
+	redisKey := getAttemptsRedisKey(key)
+	now := time.Now()
+	pipe := ra.Client.TxPipeline()
+	count := pipe.HIncrBy(redisKey, "count", 1)
+	pipe.HSet(redisKey, "last", now.UnixNano())
+	pipe.Expire(redisKey, window)
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	return &Failures{Count: int(count.Val()), Last: now}, nil
+}
+
+//countAttempt counts an attempt for the key in KEYS[1] at ARGV[1]
+//nanoseconds, to be forgotten after ARGV[2] milliseconds, and returns
+//the count and time of the failures before it, in one step
+var countAttempt = redis.NewScript(`
+local last = redis.call("HGET", KEYS[1], "last")
+local count = redis.call("HINCRBY", KEYS[1], "count", 1)
+redis.call("HSET", KEYS[1], "last", ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return {count - 1, last or "0"}`)
+
+//releaseAttempt takes back an attempt for the key in KEYS[1], forgetting
+//the key once it has no failures, without creating it if it expired
+var releaseAttempt = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 and redis.call("HINCRBY", KEYS[1], "count", -1) <= 0 then
+	redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+//Attempt counts an attempt for the key before it is made and returns
+//the failures before it. Both are done by a script, in one step.
+func (ra *RedisAttempts) Attempt(key string, window time.Duration) (*Failures, error) {
+	now := time.Now()
+	result, err := countAttempt.Run(ra.Client, []string{getAttemptsRedisKey(key)},
+		now.UnixNano(), int64(window/time.Millisecond)).Result()
+	if err != nil {
+		return nil, err
+	}
+	vals, ok := result.([]interface{})
+	if !ok || len(vals) != 2 {
+		return nil, fmt.Errorf("error unexpected attempt count %v", result)
+	}
+	count, _ := vals[0].(int64)
+	last, _ := vals[1].(string)
+	f := &Failures{Count: int(count)}
+	if nanos, _ := strconv.ParseInt(last, 10, 64); nanos > 0 {
+		f.Last = time.Unix(0, nanos)
+	}
+	return f, nil
+}
+
+//Release takes back an attempt counted by Attempt that succeeded
+func (ra *RedisAttempts) Release(key string) error {
+	return releaseAttempt.Run(ra.Client, []string{getAttemptsRedisKey(key)}).Err()
+}
+
+//Get returns the failures for the key
+func (ra *RedisAttempts) Get(key string) (*Failures, error) {
+	fields, err := ra.Client.HGetAll(getAttemptsRedisKey(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	f := &Failures{}
+	if len(fields) == 0 {
+		return f, nil
+	}
+	f.Count, _ = strconv.Atoi(fields["count"])
+	last, _ := strconv.ParseInt(fields["last"], 10, 64)
+	f.Last = time.Unix(0, last)
+	return f, nil
+}
+
+//Reset forgets the failures for the key
+func (ra *RedisAttempts) Reset(key string) error {
+	return ra.Client.Del(getAttemptsRedisKey(key)).Err()
+}
+
+//getAttemptsRedisKey returns the redis key holding a key's failures
+func getAttemptsRedisKey(key string) string {
+	return "attempts:" + key
+}
+
+//ThrottlePolicy decides how long to wait after failed attempts
+type ThrottlePolicy struct {
+	//FreeAttempts may fail without having to wait
+	FreeAttempts int
+	//BaseDelay is the wait after the first failure past FreeAttempts,
+	//doubling with each failure after that, up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	//LockoutAfter failures lock the key out for LockoutDuration;
+	//0 never locks out
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+//Wait returns how long after `now` the next attempt must wait
+func (tp ThrottlePolicy) Wait(f *Failures, now time.Time) time.Duration {
+	var delay time.Duration
+	switch {
+	case tp.LockoutAfter > 0 && f.Count >= tp.LockoutAfter:
+		delay = tp.LockoutDuration
+	case f.Count > tp.FreeAttempts:
+		delay = tp.MaxDelay
+		//stop doubling before it could overflow
+		if doublings := f.Count - tp.FreeAttempts - 1; doublings < 32 {
+			if d := tp.BaseDelay << uint(doublings); d < delay {
+				delay = d
+			}
+		}
+	}
+	wait := f.Last.Add(delay).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+//window is how long failures are remembered: long enough
+//to outlast any wait the policy could require
+func (tp ThrottlePolicy) window() time.Duration {
+	window := tp.MaxDelay
+	if tp.LockoutDuration > window {
+		window = tp.LockoutDuration
+	}
+	if window < time.Hour {
+		window = time.Hour
+	}
+	return window
+}
+
+//Throttle slows down and locks out repeated failed attempts, e.g. at
+//guessing a password, with exponential backoff per key
+type Throttle struct {
+	attempts Attempts
+	policy   ThrottlePolicy
+}
+
+//NewThrottle constructs a new Throttle counting failures in `attempts`
+func NewThrottle(attempts Attempts, policy ThrottlePolicy) *Throttle {
+	return &Throttle{
+		attempts: attempts,
+		policy:   policy,
+	}
+}
+
+//Check returns ErrThrottled, and how long to wait, if any of the
+//keys must wait before their next attempt
+func (t *Throttle) Check(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		f, err := t.attempts.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if w := t.policy.Wait(f, now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait, ErrThrottled
+	}
+	return 0, nil
+}
+
+//Attempt counts an attempt for each of the keys before it is made, and
+//returns ErrThrottled, and how long to wait, if any of them had to wait
+//first. The attempt is counted either way and decided from the count,
+//so a burst of racing attempts can't all get in before the first fails.
+//A successful attempt must be taken back with Release, or its key's
+//failures forgotten with Reset.
+func (t *Throttle) Attempt(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	throttled := false
+	var wait time.Duration
+	for _, key := range keys {
+		prev, err := t.attempts.Attempt(key, t.policy.window())
+		if err != nil {
+			return 0, err
+		}
+		if t.policy.Wait(prev, now) > 0 {
+			throttled = true
+		}
+		// the next attempt has to wait for this one too
+		if w := t.policy.Wait(&Failures{Count: prev.Count + 1, Last: now}, now); w > wait {
+			wait = w
+		}
+	}
+	if throttled {
+		return wait, ErrThrottled
+	}
+	return 0, nil
+}
+
+//Release takes back an attempt that succeeded for each of the keys
+func (t *Throttle) Release(keys ...string) error {
+	for _, key := range keys {
+		if err := t.attempts.Release(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Fail records a failed attempt for each of the keys
+func (t *Throttle) Fail(keys ...string) error {
+	for _, key := range keys {
+		if _, err := t.attempts.Fail(key, t.policy.window()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Reset forgets the failures for each of the keys, e.g. after a
+//successful attempt or when an admin unlocks an account
+func (t *Throttle) Reset(keys ...string) error {
+	for _, key := range keys {
+		if err := t.attempts.Reset(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
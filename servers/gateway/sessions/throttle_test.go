@@ -0,0 +1,129 @@
+package sessions
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyWait(t *testing.T) {
+	policy := ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       10 * time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+	}
+	now := time.Now()
+	cases := []struct {
+		name     string
+		failures int
+		expected time.Duration
+	}{
+		{"No Failures", 0, 0},
+		{"Free Attempts", 3, 0},
+		{"First Delay", 4, 10 * time.Second},
+		{"Doubled Delay", 6, 40 * time.Second},
+		{"Capped Delay", 9, time.Minute},
+		{"Locked Out", 10, 15 * time.Minute},
+	}
+	for _, c := range cases {
+		wait := policy.Wait(&Failures{Count: c.failures, Last: now}, now)
+		if wait != c.expected {
+			t.Errorf("case %s: expected a wait of %s but got %s", c.name, c.expected, wait)
+		}
+	}
+	//waits count from the last failure
+	if wait := policy.Wait(&Failures{Count: 4, Last: now.Add(-20 * time.Second)}, now); wait != 0 {
+		t.Errorf("expected no wait once the delay has passed but got %s", wait)
+	}
+	//a huge count doesn't overflow the doubling
+	policy.LockoutAfter = 0
+	if wait := policy.Wait(&Failures{Count: 1000, Last: now}, now); wait != time.Minute {
+		t.Errorf("expected the max delay for many failures but got %s", wait)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle(NewMemAttempts(), ThrottlePolicy{
+		FreeAttempts:    2,
+		BaseDelay:       time.Hour,
+		MaxDelay:        time.Hour,
+		LockoutAfter:    5,
+		LockoutDuration: 24 * time.Hour,
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := throttle.Check("email:a", "ip:1"); err != nil {
+			t.Fatalf("unexpected error within the free attempts: %v", err)
+		}
+		throttle.Fail("email:a", "ip:1")
+	}
+	if _, err := throttle.Check("email:a", "ip:1"); err != nil {
+		t.Fatalf("unexpected error after only the free attempts: %v", err)
+	}
+	throttle.Fail("email:a", "ip:1")
+	wait, err := throttle.Check("email:a", "ip:1")
+	if err != ErrThrottled || wait <= 0 {
+		t.Errorf("expected %v and a wait but got %v and %s", ErrThrottled, err, wait)
+	}
+	//either key alone is throttled
+	if _, err := throttle.Check("email:b", "ip:1"); err != ErrThrottled {
+		t.Errorf("expected another email from the same IP to be throttled but got %v", err)
+	}
+	if _, err := throttle.Check("email:a", "ip:2"); err != ErrThrottled {
+		t.Errorf("expected the same email from another IP to be throttled but got %v", err)
+	}
+	//unlocking the email leaves the IP throttled
+	throttle.Reset("email:a")
+	if _, err := throttle.Check("email:a"); err != nil {
+		t.Errorf("unexpected error after reset: %v", err)
+	}
+	if _, err := throttle.Check("ip:1"); err != ErrThrottled {
+		t.Errorf("expected the IP to still be throttled but got %v", err)
+	}
+}
+
+func TestThrottleAttempt(t *testing.T) {
+	attempts := NewMemAttempts()
+	throttle := NewThrottle(attempts, ThrottlePolicy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Hour,
+		MaxDelay:     time.Hour,
+	})
+
+	//a burst of racing attempts only lets in the free ones
+	allowed := 0
+	mx := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := throttle.Attempt("email:a"); err == nil {
+				mx.Lock()
+				allowed++
+				mx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Errorf("expected 3 attempts to be allowed but %d were", allowed)
+	}
+	if wait, err := throttle.Attempt("email:a"); err != ErrThrottled || wait <= 0 {
+		t.Errorf("expected %v and a wait but got %v and %s", ErrThrottled, err, wait)
+	}
+
+	//a released attempt doesn't count as a failure
+	throttle.Attempt("email:b")
+	throttle.Attempt("email:b")
+	throttle.Release("email:b")
+	if f, _ := attempts.Get("email:b"); f.Count != 1 {
+		t.Errorf("expected 1 failure after releasing an attempt but got %d", f.Count)
+	}
+	throttle.Release("email:b")
+	throttle.Release("email:b")
+	if f, _ := attempts.Get("email:b"); f.Count != 0 {
+		t.Errorf("expected no failures after releasing every attempt but got %d", f.Count)
+	}
+}
@@ -0,0 +1,82 @@
+package sessions
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+//ErrInvalidTicket is returned when a ticket is malformed, unknown,
+//already used, expired, or used for a different path
+var ErrInvalidTicket = errors.New("invalid ticket")
+
+//ticket is the state saved for each ticket
+type ticket struct {
+	SessionID SessionID
+	Path      string
+	Expires   time.Time
+}
+
+//Tickets issues and redeems short-lived, single-use tickets. A ticket
+//stands in for a SessionID in the `auth` query string parameter, for
+//requests like WebSocket upgrades and download links where the client
+//can't set an Authorization header. Each ticket is bound to one path.
+//Tickets are signed with a key derived from the session signing key.
+type Tickets struct {
+	signingKey string
+	store      Store
+	lifetime   time.Duration
+}
+
+//DefaultTickets, if set, makes GetSessionID accept tickets instead of
+//raw SessionIDs in the `auth` query string parameter. Set it once
+//during startup, before serving requests.
+var DefaultTickets *Tickets
+
+//NewTickets constructs a new Tickets issuing tickets valid for `lifetime`.
+//The `store` only needs to keep state for that long.
+func NewTickets(signingKey string, store Store, lifetime time.Duration) *Tickets {
+	return &Tickets{
+		signingKey: signingKey + ":ticket",
+		store:      store,
+		lifetime:   lifetime,
+	}
+}
+
+//Issue creates a ticket that authenticates as `sid` for one
+//request to `path`, and returns it with its expiry time
+func (tk *Tickets) Issue(sid SessionID, path string) (SessionID, time.Time, error) {
+	ticketID, err := NewSessionID(tk.signingKey)
+	if err != nil {
+		return InvalidSessionID, time.Time{}, err
+	}
+	state := &ticket{
+		SessionID: sid,
+		Path:      path,
+		Expires:   time.Now().Add(tk.lifetime),
+	}
+	if err := tk.store.Save(ticketID, state); err != nil {
+		return InvalidSessionID, time.Time{}, err
+	}
+	return ticketID, state.Expires, nil
+}
+
+//Redeem uses up the ticket for a request to `path` and returns
+//the SessionID it stands in for
+func (tk *Tickets) Redeem(ticketID string, path string) (SessionID, error) {
+	ticketID = strings.TrimPrefix(ticketID, schemeBearer)
+	sid, err := ValidateID(ticketID, tk.signingKey)
+	if err != nil {
+		return InvalidSessionID, ErrInvalidTicket
+	}
+	//single use, even if it's being used for the wrong path, and
+	//taken atomically so racing requests can't both redeem it
+	state := &ticket{}
+	if err := take(tk.store, sid, state); err != nil {
+		return InvalidSessionID, ErrInvalidTicket
+	}
+	if state.Path != path || time.Now().After(state.Expires) {
+		return InvalidSessionID, ErrInvalidTicket
+	}
+	return state.SessionID, nil
+}
@@ -0,0 +1,93 @@
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTickets(t *testing.T) {
+	key := "test key"
+	sid, _ := NewSessionID(key)
+	tickets := NewTickets(key, NewMemStore(time.Minute, time.Minute), time.Minute)
+
+	issue := func() SessionID {
+		ticketID, expires, err := tickets.Issue(sid, "/v1/ws")
+		if err != nil {
+			t.Fatalf("error issuing ticket: %v", err)
+		}
+		if time.Until(expires) > time.Minute {
+			t.Errorf("ticket expires too late: %v", expires)
+		}
+		return ticketID
+	}
+
+	ticketID := issue()
+	if _, err := ValidateID(ticketID.String(), key); err == nil {
+		t.Error("ticket validated as a SessionID")
+	}
+	sidRet, err := tickets.Redeem(ticketID.String(), "/v1/ws")
+	if err != nil {
+		t.Fatalf("error redeeming ticket: %v", err)
+	}
+	if sidRet != sid {
+		t.Errorf("incorrect SessionID: expected %s but got %s", sid, sidRet)
+	}
+	if _, err := tickets.Redeem(ticketID.String(), "/v1/ws"); err != ErrInvalidTicket {
+		t.Errorf("incorrect error redeeming a ticket twice: expected %v but got %v", ErrInvalidTicket, err)
+	}
+
+	ticketID = issue()
+	if _, err := tickets.Redeem(ticketID.String(), "/v1/other"); err != ErrInvalidTicket {
+		t.Errorf("incorrect error redeeming a ticket for another path: expected %v but got %v", ErrInvalidTicket, err)
+	}
+
+	if _, err := tickets.Redeem(sid.String(), "/v1/ws"); err != ErrInvalidTicket {
+		t.Errorf("incorrect error redeeming a SessionID as a ticket: expected %v but got %v", ErrInvalidTicket, err)
+	}
+
+	expiring := NewTickets(key, NewMemStore(time.Minute, time.Minute), time.Millisecond)
+	ticketID, _, _ = expiring.Issue(sid, "/v1/ws")
+	time.Sleep(5 * time.Millisecond)
+	if _, err := expiring.Redeem(ticketID.String(), "/v1/ws"); err != ErrInvalidTicket {
+		t.Errorf("incorrect error redeeming an expired ticket: expected %v but got %v", ErrInvalidTicket, err)
+	}
+}
+
+func TestSessionGetSessionIDFromTicket(t *testing.T) {
+	key := "test key"
+	sid, _ := NewSessionID(key)
+	DefaultTickets = NewTickets(key, NewMemStore(time.Minute, time.Minute), time.Minute)
+	defer func() { DefaultTickets = nil }()
+
+	ticketID, _, _ := DefaultTickets.Issue(sid, "/v1/ws")
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/v1/ws?%s=%s", paramAuthorization, ticketID), nil)
+	sidRet, err := GetSessionID(req, key)
+	if err != nil {
+		t.Fatalf("error getting SessionID from ticket: %v", err)
+	}
+	if sidRet != sid {
+		t.Errorf("incorrect SessionID: expected %s but got %s", sid, sidRet)
+	}
+
+	//raw SessionIDs are no longer accepted in the query string
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/v1/ws?%s=%s%s", paramAuthorization, schemeBearer, sid), nil)
+	if _, err := GetSessionID(req, key); err == nil {
+		t.Error("expected error when passing a raw SessionID in the query string")
+	}
+}
+
+func TestTicketsRedeemOnce(t *testing.T) {
+	key := "test key"
+	sid, _ := NewSessionID(key)
+	tickets := NewTickets(key, NewMemStore(time.Minute, time.Minute), time.Minute)
+	ticketID, _, _ := tickets.Issue(sid, "/v1/ws")
+	redeemed := succeedConcurrently(20, func() error {
+		_, err := tickets.Redeem(ticketID.String(), "/v1/ws")
+		return err
+	})
+	if redeemed != 1 {
+		t.Errorf("expected a ticket to be redeemed once but it was redeemed %d times", redeemed)
+	}
+}
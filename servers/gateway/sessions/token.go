@@ -0,0 +1,168 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//tokenPrefix marks stateless tokens so they can't be confused with
+//SessionIDs, which never contain a "."
+const tokenPrefix = "st1."
+
+//MaxTokenLifetime is the longest a stateless token may be valid for.
+//Tokens can't be ended like sessions, only denylisted, so they
+//must be short-lived.
+const MaxTokenLifetime = 15 * time.Minute
+
+//ErrNotToken is returned when the credentials in a request are not a stateless token
+var ErrNotToken = errors.New("credentials are not a stateless token")
+
+//ErrInvalidToken is returned when a token is malformed or its signature doesn't match
+var ErrInvalidToken = errors.New("invalid token")
+
+//ErrTokenExpired is returned when a token's lifetime has passed
+var ErrTokenExpired = errors.New("token has expired")
+
+//ErrTokenRevoked is returned when a token is on the denylist
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+//TokenClaims are the facts a stateless token asserts about its bearer.
+//A token is a base64 URL encoded JSON encoding of the claims and an
+//HMAC signature of them, so it can be validated without a store lookup:
+//
+//	st1.<claims>.<signature>
+type TokenClaims struct {
+	//ID uniquely identifies the token so it can be denylisted
+	ID     string `json:"jti"`
+	UserID string `json:"sub"`
+	TokenUser
+	IssuedAt int64 `json:"iat"`
+	Expires  int64 `json:"exp"`
+}
+
+//TokenUser is what a token asserts about its user as of when it was
+//minted, so requests carrying it can be forwarded without a lookup
+type TokenUser struct {
+	Email     string   `json:"email,omitempty"`
+	UserName  string   `json:"userName,omitempty"`
+	FirstName string   `json:"firstName,omitempty"`
+	LastName  string   `json:"lastName,omitempty"`
+	PhotoURL  string   `json:"photoURL,omitempty"`
+	Verified  bool     `json:"verified,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	//Permissions are those granted to the user directly, not by a role
+	Permissions []string `json:"perms,omitempty"`
+}
+
+//NewToken creates and signs a token for the user, asserting `user`
+//(which may be nil), that is valid for `lifetime`, which may not be
+//longer than MaxTokenLifetime
+func NewToken(signingKey string, userID string, user *TokenUser, lifetime time.Duration) (string, *TokenClaims, error) {
+	if len(signingKey) == 0 {
+		return "", nil, fmt.Errorf("error signing key should not be empty")
+	}
+	if lifetime <= 0 || lifetime > MaxTokenLifetime {
+		return "", nil, fmt.Errorf("error token lifetime must be between 0 and %v", MaxTokenLifetime)
+	}
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, fmt.Errorf("error generating token ID: %v", err)
+	}
+	now := time.Now()
+	claims := &TokenClaims{
+		ID:       base64.RawURLEncoding.EncodeToString(idBytes),
+		UserID:   userID,
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(lifetime).Unix(),
+	}
+	if user != nil {
+		claims.TokenUser = *user
+	}
+	jsonVal, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(jsonVal)
+	return tokenPrefix + payload + "." + signToken(payload, signingKey), claims, nil
+}
+
+//ValidateToken checks the token's signature and expiry, and that neither
+//it nor its user's tokens are on the `denylist` (which may be nil),
+//returning its claims if it is valid
+func ValidateToken(token string, signingKey string, denylist Denylist) (*TokenClaims, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrNotToken
+	}
+	parts := strings.Split(strings.TrimPrefix(token, tokenPrefix), ".")
+	if len(parts) != 2 || len(signingKey) == 0 {
+		return nil, ErrInvalidToken
+	}
+	expected := signToken(parts[0], signingKey)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(parts[1])) != 1 {
+		return nil, ErrInvalidToken
+	}
+	jsonVal, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(jsonVal, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, ErrTokenExpired
+	}
+	if denylist != nil {
+		revoked, err := denylist.IsRevoked(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+		revokedAt, err := denylist.UserRevokedAt(claims.UserID)
+		if err != nil {
+			return nil, err
+		}
+		// IssuedAt is in seconds, so a token from the same second as
+		// the revocation is revoked too, to be safe
+		if !revokedAt.IsZero() && claims.IssuedAt <= revokedAt.Unix() {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+//GetToken extracts and validates a stateless token from the request's
+//Authorization header. Tokens are never read from the `auth` query
+//string parameter, since URLs leak into logs and referrers. It returns
+//ErrNotToken if the request carries a SessionID, a ticket, or nothing instead.
+func GetToken(r *http.Request, signingKey string, denylist Denylist) (*TokenClaims, error) {
+	token, err := parseBearer(r.Header.Get(headerAuthorization))
+	if err != nil {
+		return nil, ErrNotToken
+	}
+	return ValidateToken(token, signingKey, denylist)
+}
+
+//ExpiresAt returns the time the token stops being valid
+func (tc *TokenClaims) ExpiresAt() time.Time {
+	return time.Unix(tc.Expires, 0)
+}
+
+//signToken returns the signature of the token payload. The payload is
+//prefixed so a token signature can never double as a SessionID signature.
+func signToken(payload string, signingKey string) string {
+	hasher := hmac.New(sha256.New, []byte(signingKey))
+	hasher.Write([]byte("token:" + payload))
+	return base64.RawURLEncoding.EncodeToString(hasher.Sum(nil))
+}
@@ -0,0 +1,115 @@
+package sessions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewToken(t *testing.T) {
+	cases := []struct {
+		name        string
+		signingKey  string
+		lifetime    time.Duration
+		expectError bool
+	}{
+		{"Empty Signing Key", "", time.Minute, true},
+		{"Zero Lifetime", "test key", 0, true},
+		{"Lifetime Too Long", "test key", MaxTokenLifetime + time.Second, true},
+		{"Valid", "test key", time.Minute, false},
+	}
+	for _, c := range cases {
+		token, claims, err := NewToken(c.signingKey, "user1", &TokenUser{Roles: []string{"admin"}, Permissions: []string{"audit:read"}}, c.lifetime)
+		if err != nil && !c.expectError {
+			t.Errorf("case %s: unexpected error: %v", c.name, err)
+		}
+		if err == nil && c.expectError {
+			t.Errorf("case %s: expected error but didn't get one", c.name)
+		}
+		if err == nil {
+			if !strings.HasPrefix(token, tokenPrefix) {
+				t.Errorf("case %s: token %s is missing the %s prefix", c.name, token, tokenPrefix)
+			}
+			if claims.UserID != "user1" || !reflect.DeepEqual(claims.Roles, []string{"admin"}) ||
+				!reflect.DeepEqual(claims.Permissions, []string{"audit:read"}) {
+				t.Errorf("case %s: incorrect claims %v", c.name, claims)
+			}
+		}
+	}
+}
+
+func TestValidateToken(t *testing.T) {
+	key := "test key"
+	token, claims, err := NewToken(key, "user1", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	sid, _ := NewSessionID(key)
+
+	//sign an already expired set of claims by hand
+	expiredJSON, _ := json.Marshal(&TokenClaims{ID: "old", UserID: "user1", Expires: time.Now().Add(-time.Minute).Unix()})
+	expiredPayload := base64.RawURLEncoding.EncodeToString(expiredJSON)
+	expired := tokenPrefix + expiredPayload + "." + signToken(expiredPayload, key)
+
+	revokedToken, revokedClaims, _ := NewToken(key, "user1", nil, time.Minute)
+	denylist := NewMemDenylist()
+	denylist.Revoke(revokedClaims.ID, revokedClaims.ExpiresAt())
+	userRevokedToken, _, _ := NewToken(key, "user2", nil, time.Minute)
+	denylist.RevokeUser("user2")
+
+	cases := []struct {
+		name          string
+		token         string
+		validationKey string
+		expectedErr   error
+	}{
+		{"Valid", token, key, nil},
+		{"SessionID", sid.String(), key, ErrNotToken},
+		{"Different Key", token, "different key", ErrInvalidToken},
+		{"Mutated Claims", tokenPrefix + "x" + strings.TrimPrefix(token, tokenPrefix), key, ErrInvalidToken},
+		{"Missing Signature", strings.Split(token, ".")[0] + "." + strings.Split(token, ".")[1], key, ErrInvalidToken},
+		{"Expired", expired, key, ErrTokenExpired},
+		{"Revoked", revokedToken, key, ErrTokenRevoked},
+		{"User Revoked", userRevokedToken, key, ErrTokenRevoked},
+	}
+	for _, c := range cases {
+		claimsRet, err := ValidateToken(c.token, c.validationKey, denylist)
+		if err != c.expectedErr {
+			t.Errorf("case %s: expected error %v but got %v", c.name, c.expectedErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(claims, claimsRet) {
+			t.Errorf("case %s: incorrect claims: expected %v but got %v", c.name, claims, claimsRet)
+		}
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	key := "test key"
+	token, claims, _ := NewToken(key, "user1", nil, time.Minute)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Add(headerAuthorization, schemeBearer+token)
+	claimsRet, err := GetToken(req, key, nil)
+	if err != nil {
+		t.Fatalf("error getting token from request: %v", err)
+	}
+	if claimsRet.ID != claims.ID {
+		t.Errorf("incorrect token ID: expected %s but got %s", claims.ID, claimsRet.ID)
+	}
+
+	//a token is not a SessionID
+	if _, err := GetSessionID(req, key); err == nil {
+		t.Error("expected error when getting a SessionID from a request with a token")
+	}
+
+	//tokens are only read from the Authorization header
+	req, _ = http.NewRequest("GET", "/?"+paramAuthorization+"="+url.QueryEscape(schemeBearer+token), nil)
+	if _, err := GetToken(req, key, nil); err != ErrNotToken {
+		t.Errorf("expected %v for a token in the query string but got %v", ErrNotToken, err)
+	}
+}
@@ -0,0 +1,207 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//headerCSRFToken is the request header clients echo the CSRF token in
+const headerCSRFToken = "X-CSRF-Token"
+
+//ErrCSRF is returned when a state-changing request authenticated by
+//cookie fails the CSRF check
+var ErrCSRF = errors.New("missing or invalid CSRF token")
+
+//Transport carries a SessionID between the server and the client.
+type Transport interface {
+	//WriteSessionID adds the SessionID to the response
+	WriteSessionID(w http.ResponseWriter, sid SessionID, signingKey string)
+
+	//ReadSessionID extracts the (not yet validated) session ID from the request
+	ReadSessionID(r *http.Request, signingKey string) (string, error)
+
+	//ClearSessionID tells the client to forget its SessionID
+	ClearSessionID(w http.ResponseWriter)
+}
+
+//DefaultTransport is the Transport used by BeginSession, GetSessionID
+//and ClearSessionID. Set it once during startup, before serving requests.
+var DefaultTransport Transport = HeaderTransport{}
+
+//HeaderTransport sends the SessionID in the Authorization header
+//using the Bearer scheme. Since browsers can't set headers on some
+//requests, it also accepts the `auth` query string parameter.
+type HeaderTransport struct{}
+
+//WriteSessionID adds an Authorization header with the SessionID to the response
+func (HeaderTransport) WriteSessionID(w http.ResponseWriter, sid SessionID, signingKey string) {
+	w.Header().Add(headerAuthorization, schemeBearer+sid.String())
+}
+
+//ReadSessionID reads the SessionID from the Authorization header,
+//or from the `auth` query string parameter if there is no header
+func (HeaderTransport) ReadSessionID(r *http.Request, signingKey string) (string, error) {
+	authHeader := r.Header.Get(headerAuthorization)
+	if len(authHeader) == 0 {
+		authHeader = r.URL.Query().Get(paramAuthorization)
+	}
+	return parseBearer(authHeader)
+}
+
+//ClearSessionID does nothing, since the client owns the header
+func (HeaderTransport) ClearSessionID(w http.ResponseWriter) {}
+
+//CSRFMode selects how CookieTransport protects state-changing requests
+type CSRFMode int
+
+const (
+	//CSRFDoubleSubmit requires the X-CSRF-Token header to match a token
+	//derived from the SessionID, which is handed to the client in a
+	//readable (non-HttpOnly) cookie when the session begins
+	CSRFDoubleSubmit CSRFMode = iota
+	//CSRFOrigin requires the Origin (or Referer) header to match
+	//one of the transport's AllowedOrigins
+	CSRFOrigin
+)
+
+//CookieTransport sends the SessionID in an HttpOnly, Secure cookie.
+//Requests with an Authorization header are still accepted so that
+//non-browser clients keep working, but the `auth` query string
+//parameter is not, since it leaks into logs and referrers.
+type CookieTransport struct {
+	//CookieName is the name of the session cookie
+	CookieName string
+	//CSRFCookieName is the name of the readable cookie holding the CSRF token
+	CSRFCookieName string
+	//Domain and Path scope the cookies
+	Domain string
+	Path   string
+	//SameSite is the SameSite attribute of the session cookie
+	SameSite http.SameSite
+	//CSRF selects how state-changing requests are checked
+	CSRF CSRFMode
+	//AllowedOrigins are the origins (e.g. "https://example.com")
+	//accepted when CSRF is CSRFOrigin
+	AllowedOrigins []string
+}
+
+//NewCookieTransport constructs a CookieTransport with default cookie names
+func NewCookieTransport(csrf CSRFMode, allowedOrigins []string) *CookieTransport {
+	return &CookieTransport{
+		CookieName:     "sid",
+		CSRFCookieName: "csrf_token",
+		Path:           "/",
+		SameSite:       http.SameSiteLaxMode,
+		CSRF:           csrf,
+		AllowedOrigins: allowedOrigins,
+	}
+}
+
+//WriteSessionID sets the session cookie, plus the CSRF cookie when
+//using double-submit tokens
+func (ct *CookieTransport) WriteSessionID(w http.ResponseWriter, sid SessionID, signingKey string) {
+	http.SetCookie(w, ct.cookie(ct.CookieName, sid.String(), true))
+	if ct.CSRF == CSRFDoubleSubmit {
+		http.SetCookie(w, ct.cookie(ct.CSRFCookieName, CSRFToken(sid.String(), signingKey), false))
+	}
+}
+
+//ReadSessionID reads the SessionID from the session cookie, enforcing
+//the CSRF check on state-changing methods. If there is no cookie it
+//falls back to the Authorization header.
+func (ct *CookieTransport) ReadSessionID(r *http.Request, signingKey string) (string, error) {
+	cookie, err := r.Cookie(ct.CookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return parseBearer(r.Header.Get(headerAuthorization))
+	}
+	if isStateChanging(r.Method) {
+		if err := ct.checkCSRF(r, cookie.Value, signingKey); err != nil {
+			return "", err
+		}
+	}
+	return cookie.Value, nil
+}
+
+//ClearSessionID expires the session and CSRF cookies
+func (ct *CookieTransport) ClearSessionID(w http.ResponseWriter) {
+	for _, name := range []string{ct.CookieName, ct.CSRFCookieName} {
+		cookie := ct.cookie(name, "", true)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+//cookie builds a cookie with the transport's attributes
+func (ct *CookieTransport) cookie(name string, value string, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   ct.Domain,
+		Path:     ct.Path,
+		Secure:   true,
+		HttpOnly: httpOnly,
+		SameSite: ct.SameSite,
+	}
+}
+
+//checkCSRF enforces the transport's CSRF mode on the request
+func (ct *CookieTransport) checkCSRF(r *http.Request, id string, signingKey string) error {
+	switch ct.CSRF {
+	case CSRFOrigin:
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			if referer, err := url.Parse(r.Header.Get("Referer")); err == nil && len(referer.Host) > 0 {
+				origin = referer.Scheme + "://" + referer.Host
+			}
+		}
+		for _, allowed := range ct.AllowedOrigins {
+			if len(origin) > 0 && strings.EqualFold(origin, allowed) {
+				return nil
+			}
+		}
+		return ErrCSRF
+	default:
+		expected := CSRFToken(id, signingKey)
+		actual := r.Header.Get(headerCSRFToken)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+			return ErrCSRF
+		}
+		return nil
+	}
+}
+
+//CSRFToken returns the double-submit CSRF token for the given session ID.
+//It is an HMAC of the ID, so it can't be forged without the signing key
+//and doesn't need to be stored.
+func CSRFToken(id string, signingKey string) string {
+	hasher := hmac.New(sha256.New, []byte(signingKey))
+	hasher.Write([]byte("csrf:" + id))
+	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+//isStateChanging reports whether the method can change server state
+func isStateChanging(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
+
+//parseBearer returns the ID from an Authorization value using the Bearer scheme
+func parseBearer(authHeader string) (string, error) {
+	if len(authHeader) == 0 {
+		return "", ErrNoSessionID
+	}
+	if !strings.HasPrefix(authHeader, schemeBearer) {
+		return "", ErrInvalidScheme
+	}
+	authTokens := strings.Split(strings.Trim(authHeader, " "), " ")
+	return authTokens[len(authTokens)-1], nil
+}
@@ -0,0 +1,130 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//withTransport runs `fn` with `t` installed as the DefaultTransport
+func withTransport(t Transport, fn func()) {
+	prev := DefaultTransport
+	DefaultTransport = t
+	defer func() { DefaultTransport = prev }()
+	fn()
+}
+
+func TestCookieTransportDoubleSubmit(t *testing.T) {
+	key := "test key"
+	store := NewMemStore(time.Hour, time.Minute)
+	withTransport(NewCookieTransport(CSRFDoubleSubmit, nil), func() {
+		respRec := httptest.NewRecorder()
+		sid, err := BeginSession(key, store, 100, respRec)
+		if err != nil {
+			t.Fatalf("error beginning session: %v", err)
+		}
+		if len(respRec.Header().Get(headerAuthorization)) != 0 {
+			t.Error("cookie transport should not add an Authorization header")
+		}
+		resp := respRec.Result()
+		var sessCookie, csrfCookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			switch c.Name {
+			case "sid":
+				sessCookie = c
+			case "csrf_token":
+				csrfCookie = c
+			}
+		}
+		if sessCookie == nil || csrfCookie == nil {
+			t.Fatalf("expected session and CSRF cookies but got %v", resp.Cookies())
+		}
+		if !sessCookie.HttpOnly || !sessCookie.Secure {
+			t.Error("session cookie should be HttpOnly and Secure")
+		}
+		if csrfCookie.HttpOnly {
+			t.Error("CSRF cookie must be readable by scripts")
+		}
+
+		cases := []struct {
+			name        string
+			method      string
+			csrfToken   string
+			expectError bool
+		}{
+			{"GET Without Token", "GET", "", false},
+			{"POST Without Token", "POST", "", true},
+			{"POST With Wrong Token", "POST", "invalid", true},
+			{"POST With Token", "POST", csrfCookie.Value, false},
+			{"DELETE With Token", "DELETE", csrfCookie.Value, false},
+		}
+		for _, c := range cases {
+			req, _ := http.NewRequest(c.method, "/", nil)
+			req.AddCookie(sessCookie)
+			if len(c.csrfToken) > 0 {
+				req.Header.Set(headerCSRFToken, c.csrfToken)
+			}
+			sidRet, err := GetSessionID(req, key)
+			if err != nil && !c.expectError {
+				t.Errorf("case %s: unexpected error: %v", c.name, err)
+			}
+			if err == nil && c.expectError {
+				t.Errorf("case %s: expected error but didn't get one", c.name)
+			}
+			if !c.expectError && sidRet != sid {
+				t.Errorf("case %s: incorrect SessionID returned: expected %s but got %s", c.name, sid, sidRet)
+			}
+		}
+
+		//the query string parameter is not accepted in cookie mode
+		req, _ := http.NewRequest("GET", "/?"+paramAuthorization+"="+schemeBearer+sid.String(), nil)
+		if _, err := GetSessionID(req, key); err == nil {
+			t.Error("expected error when passing the SessionID as a query string parameter")
+		}
+
+		respRec = httptest.NewRecorder()
+		ClearSessionID(respRec)
+		for _, c := range respRec.Result().Cookies() {
+			if c.MaxAge >= 0 {
+				t.Errorf("cookie %s was not expired", c.Name)
+			}
+		}
+	})
+}
+
+func TestCookieTransportOrigin(t *testing.T) {
+	key := "test key"
+	sid, _ := NewSessionID(key)
+	withTransport(NewCookieTransport(CSRFOrigin, []string{"https://example.com"}), func() {
+		cases := []struct {
+			name        string
+			origin      string
+			referer     string
+			expectError bool
+		}{
+			{"No Origin", "", "", true},
+			{"Allowed Origin", "https://example.com", "", false},
+			{"Other Origin", "https://evil.com", "", true},
+			{"Allowed Referer", "", "https://example.com/page", false},
+			{"Other Referer", "", "https://evil.com/page", true},
+		}
+		for _, c := range cases {
+			req, _ := http.NewRequest("PATCH", "/", nil)
+			req.AddCookie(&http.Cookie{Name: "sid", Value: sid.String()})
+			if len(c.origin) > 0 {
+				req.Header.Set("Origin", c.origin)
+			}
+			if len(c.referer) > 0 {
+				req.Header.Set("Referer", c.referer)
+			}
+			_, err := GetSessionID(req, key)
+			if err != nil && !c.expectError {
+				t.Errorf("case %s: unexpected error: %v", c.name, err)
+			}
+			if err == nil && c.expectError {
+				t.Errorf("case %s: expected error but didn't get one", c.name)
+			}
+		}
+	})
+}